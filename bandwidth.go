@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// bandwidth, when set, limits and counts the bytes written and read on every
+// scanner connection, shared by all workers
+var bandwidth *bandwidthLimiter
+
+// bandwidthLimiter is a token bucket over bytes. A transfer larger than the
+// bucket leaves it in debt, which the following transfers wait out, so the
+// average rate holds whatever the buffer sizes.
+type bandwidthLimiter struct {
+	rate  float64 // bytes per second
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+
+	sent     atomic.Int64
+	received atomic.Int64
+}
+
+// newBandwidthLimiter limits traffic to bitsPerSecond, allowing bursts of a
+// tenth of a second
+func newBandwidthLimiter(bitsPerSecond float64) *bandwidthLimiter {
+	rate := bitsPerSecond / 8
+	burst := max(rate/10, 1500)
+	return &bandwidthLimiter{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// parseBandwidth reads a rate in bits per second with an optional k, M or G
+// suffix, e.g. 512k or 10M
+func parseBandwidth(s string) (float64, error) {
+	num := strings.TrimSuffix(strings.TrimSpace(s), "bit")
+	scale := 1.0
+	if n := len(num); n > 0 {
+		switch num[n-1] {
+		case 'k', 'K':
+			scale, num = 1e3, num[:n-1]
+		case 'm', 'M':
+			scale, num = 1e6, num[:n-1]
+		case 'g', 'G':
+			scale, num = 1e9, num[:n-1]
+		}
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("invalid bandwidth %q, expected bits per second such as 512k or 10M", s)
+	}
+	return v * scale, nil
+}
+
+// wait blocks until n more bytes fit within the rate and returns how long
+// it waited
+func (l *bandwidthLimiter) wait(n int) time.Duration {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+	time.Sleep(delay)
+	return delay
+}
+
+// send waits until n bytes may be written and counts them
+func (l *bandwidthLimiter) send(n int) time.Duration {
+	wait := l.wait(n)
+	l.sent.Add(int64(n))
+	return wait
+}
+
+// receive counts n bytes read and waits them out, slowing the next read
+func (l *bandwidthLimiter) receive(n int) time.Duration {
+	l.received.Add(int64(n))
+	return l.wait(n)
+}
+
+// chunk is the most a single read or write moves before waiting again
+func (l *bandwidthLimiter) chunk() int {
+	return int(l.burst)
+}
+
+// limitedConn applies a bandwidthLimiter to a connection. The limiter waits
+// for every worker's traffic, so the deadlines set on the connection are
+// pushed back by the time it waits, and a timeout only counts time spent
+// waiting for the peer.
+type limitedConn struct {
+	net.Conn
+	limiter *bandwidthLimiter
+
+	mu            sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+func (c *limitedConn) Read(p []byte) (int, error) {
+	if len(p) > c.limiter.chunk() {
+		p = p[:c.limiter.chunk()]
+	}
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.extend(c.limiter.receive(n))
+	}
+	return n, err
+}
+
+func (c *limitedConn) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		size := min(len(p), c.limiter.chunk())
+		c.extend(c.limiter.send(size))
+		n, err := c.Conn.Write(p[:size])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[size:]
+	}
+	return written, nil
+}
+
+func (c *limitedConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline, c.writeDeadline = t, t
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *limitedConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *limitedConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeDeadline = t
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// extend moves the deadlines set on the connection back by a wait
+func (c *limitedConn) extend(wait time.Duration) {
+	if wait <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.readDeadline.IsZero() {
+		c.readDeadline = c.readDeadline.Add(wait)
+		c.Conn.SetReadDeadline(c.readDeadline)
+	}
+	if !c.writeDeadline.IsZero() {
+		c.writeDeadline = c.writeDeadline.Add(wait)
+		c.Conn.SetWriteDeadline(c.writeDeadline)
+	}
+}
+
+// limitConn wraps conn when a bandwidth limit is set
+func limitConn(conn net.Conn) net.Conn {
+	if bandwidth == nil {
+		return conn
+	}
+	return &limitedConn{Conn: conn, limiter: bandwidth}
+}
+
+// TrafficStats is the scanner traffic of a host scan and the throughput it
+// achieved under -max-bandwidth
+type TrafficStats struct {
+	BytesSent     int64   `json:"bytes_sent" xml:"bytes_sent,attr"`
+	BytesReceived int64   `json:"bytes_received" xml:"bytes_received,attr"`
+	Throughput    float64 `json:"throughput_bps" xml:"throughput_bps,attr"`
+	Limit         float64 `json:"limit_bps" xml:"limit_bps,attr"`
+}
+
+func (t *TrafficStats) String() string {
+	return fmt.Sprintf("%s sent, %s received, %s of %s",
+		formatBytes(t.BytesSent), formatBytes(t.BytesReceived), formatBits(t.Throughput), formatBits(t.Limit))
+}
+
+// trafficMark is the limiter's counters at one point in time
+type trafficMark struct {
+	sent, received int64
+	at             time.Time
+}
+
+func (l *bandwidthLimiter) mark() trafficMark {
+	return trafficMark{sent: l.sent.Load(), received: l.received.Load(), at: time.Now()}
+}
+
+// since returns the traffic counted after a mark
+func (l *bandwidthLimiter) since(m trafficMark) *TrafficStats {
+	t := &TrafficStats{
+		BytesSent:     l.sent.Load() - m.sent,
+		BytesReceived: l.received.Load() - m.received,
+		Limit:         l.rate * 8,
+	}
+	if elapsed := time.Since(m.at).Seconds(); elapsed > 0 {
+		t.Throughput = float64(t.BytesSent+t.BytesReceived) * 8 / elapsed
+	}
+	return t
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1e9:
+		return fmt.Sprintf("%.1f GB", float64(n)/1e9)
+	case n >= 1e6:
+		return fmt.Sprintf("%.1f MB", float64(n)/1e6)
+	case n >= 1e3:
+		return fmt.Sprintf("%.1f kB", float64(n)/1e3)
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+func formatBits(bps float64) string {
+	switch {
+	case bps >= 1e9:
+		return fmt.Sprintf("%.1f Gbit/s", bps/1e9)
+	case bps >= 1e6:
+		return fmt.Sprintf("%.1f Mbit/s", bps/1e6)
+	case bps >= 1e3:
+		return fmt.Sprintf("%.1f kbit/s", bps/1e3)
+	}
+	return fmt.Sprintf("%.0f bit/s", bps)
+}
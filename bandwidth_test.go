@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseBandwidth(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    float64
+		wantErr bool
+	}{
+		{"512k", 512e3, false},
+		{"10M", 10e6, false},
+		{"1.5G", 1.5e9, false},
+		{"100mbit", 100e6, false},
+		{" 64000 ", 64000, false},
+		{"0", 0, true},
+		{"-1M", 0, true},
+		{"fast", 0, true},
+		{"", 0, true},
+		{"M", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseBandwidth(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseBandwidth(%q) = %v, %v; want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// TestBandwidthLimitKeepsResults checks that probes read the same data with
+// and without -max-bandwidth when the limit makes a transfer take longer than
+// the timeout and other workers have left the limiter in debt
+func TestBandwidthLimitKeepsResults(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 2048) // 32 kB
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.Read(make([]byte, 64))
+				conn.Write(payload)
+			}()
+		}
+	}()
+	tcpPort := ln.Addr().(*net.TCPAddr).Port
+
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(payload)
+	}))
+	defer web.Close()
+
+	const timeout = 300 * time.Millisecond
+	probes := []struct {
+		name string
+		run  func() ([]byte, error)
+	}{
+		{"tcp exchange", func() ([]byte, error) {
+			return tcpExchange("127.0.0.1", tcpPort, []byte("HELLO\r\n"), 64*1024, timeout)
+		}},
+		{"http fetch", func() ([]byte, error) {
+			_, body, err := fetch(probeHTTPClient(timeout), web.URL+"/", 64*1024)
+			return body, err
+		}},
+	}
+
+	defer func() { bandwidth = nil }()
+	for _, p := range probes {
+		t.Run(p.name, func(t *testing.T) {
+			bandwidth = nil
+			want, err := p.run()
+			if err != nil || !bytes.Equal(want, payload) {
+				t.Fatalf("without limit: %d bytes, %v", len(want), err)
+			}
+
+			// 32 kB at 400 kbit/s takes about 0.65s, twice the timeout,
+			// after waiting out 200ms owed by other workers
+			bandwidth = newBandwidthLimiter(400e3)
+			bandwidth.tokens = -bandwidth.rate / 5
+			start := time.Now()
+			got, err := p.run()
+			if err != nil && err != io.EOF {
+				t.Fatalf("with limit: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("with limit got %d bytes, want the %d read without it", len(got), len(want))
+			}
+			if elapsed := time.Since(start); elapsed < timeout {
+				t.Errorf("transfer took %v, the limit was not applied", elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Host identifier types used to recognise one machine behind several
+// addresses
+const (
+	IdentSSHHostKey  = "ssh-host-key"
+	IdentTLSCert     = "tls-cert"
+	IdentSMBGUID     = "smb-guid"
+	IdentNetBIOSName = "netbios-name"
+	IdentSNMPEngine  = "snmp-engine-id"
+)
+
+// HostIdentifier is a value that only one machine should present, such as
+// its SSH host key
+type HostIdentifier struct {
+	Type  string `json:"type" xml:"type,attr"`
+	Value string `json:"value" xml:"value,attr"`
+	Port  int    `json:"port,omitempty" xml:"port,attr,omitempty"`
+}
+
+func (id HostIdentifier) key() string {
+	return id.Type + " " + id.Value
+}
+
+// HostIdentity groups the addresses found to belong to one logical host,
+// with the identifiers they were linked by
+type HostIdentity struct {
+	ID        string           `json:"id" xml:"id,attr"`
+	Name      string           `json:"name,omitempty" xml:"name,attr,omitempty"`
+	Addresses []string         `json:"addresses" xml:"address"`
+	Shared    []HostIdentifier `json:"shared" xml:"shared"`
+}
+
+func (h *HostIdentity) String() string {
+	name := h.Name
+	if name == "" {
+		name = h.ID
+	}
+	seen := map[string]bool{}
+	var types []string
+	for _, id := range h.Shared {
+		if !seen[id.Type] {
+			seen[id.Type] = true
+			types = append(types, id.Type)
+		}
+	}
+	return name + " at " + strings.Join(h.Addresses, ", ") + " (" + strings.Join(types, ", ") + ")"
+}
+
+// collectIdentifiers gathers the host identifiers of a scanned host: SSH
+// host keys and TLS certificates from its open ports, the SMB server GUID,
+// and the NetBIOS name and SNMP engine ID, which are queried over UDP
+// whether or not those ports were scanned
+func collectIdentifiers(summary *ScanSummary, workers int, timeout time.Duration) {
+	var mu sync.Mutex
+	add := func(id HostIdentifier) {
+		mu.Lock()
+		summary.Identifiers = append(summary.Identifiers, id)
+		mu.Unlock()
+	}
+
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	run := func(fn func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			fn()
+		}()
+	}
+
+	for _, res := range summary.Ports {
+		if res.proto() != "tcp" {
+			continue
+		}
+		port := res.Port
+		isSSH := port == 22 || strings.HasPrefix(res.Banner, "SSH-")
+		run(func() {
+			if isSSH {
+				if s, ok := takeSample(sampleSSH, summary.Target, port, timeout); ok {
+					add(HostIdentifier{Type: IdentSSHHostKey, Value: s.HostKey, Port: port})
+				}
+				return
+			}
+			if cert := tlsCertIdentity(summary.Target, port, timeout); cert != "" {
+				add(HostIdentifier{Type: IdentTLSCert, Value: cert, Port: port})
+			}
+			if port == 445 {
+				if guid, err := smbServerGUID(summary.Target, port, timeout); err == nil {
+					add(HostIdentifier{Type: IdentSMBGUID, Value: guid, Port: port})
+				}
+			}
+		})
+	}
+	run(func() {
+		if name, err := netbiosName(summary.Target, timeout); err == nil {
+			add(HostIdentifier{Type: IdentNetBIOSName, Value: name, Port: 137})
+		}
+	})
+	run(func() {
+		if engine, err := snmpDiscover(summary.Target, timeout); err == nil {
+			add(HostIdentifier{Type: IdentSNMPEngine, Value: hex.EncodeToString(engine.ID), Port: 161})
+		}
+	})
+	wg.Wait()
+
+	sort.Slice(summary.Identifiers, func(i, j int) bool {
+		a, b := summary.Identifiers[i], summary.Identifiers[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.Port < b.Port
+	})
+}
+
+// tlsCertIdentity fingerprints the certificate and public key a port
+// presents. Wildcard certificates are skipped, since the same one is often
+// deployed across a whole fleet.
+func tlsCertIdentity(host string, port int, timeout time.Duration) string {
+	conn, err := dialPort(host, port, timeout)
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	cfg := &tls.Config{InsecureSkipVerify: true}
+	if net.ParseIP(host) == nil {
+		cfg.ServerName = host
+	}
+	tc := tls.Client(conn, cfg)
+	if tc.Handshake() != nil {
+		return ""
+	}
+	certs := tc.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return ""
+	}
+	cert := certs[0]
+	for _, name := range append(cert.DNSNames, cert.Subject.CommonName) {
+		if strings.HasPrefix(name, "*.") {
+			return ""
+		}
+	}
+	certSum := sha256.Sum256(cert.Raw)
+	keySum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return hex.EncodeToString(certSum[:]) + "/" + hex.EncodeToString(keySum[:])
+}
+
+/* SMB */
+
+// smbServerGUID sends an SMB2 NEGOTIATE and reads the ServerGuid, which
+// Windows and Samba generate once per server
+func smbServerGUID(host string, port int, timeout time.Duration) (string, error) {
+	var req bytes.Buffer
+	header := make([]byte, 64)
+	copy(header, "\xfeSMB")
+	binary.LittleEndian.PutUint16(header[4:], 64) // StructureSize
+	binary.LittleEndian.PutUint16(header[14:], 1) // CreditRequest
+	req.Write(header)
+
+	dialects := []uint16{0x0202, 0x0210, 0x0300, 0x0302}
+	body := make([]byte, 36)
+	binary.LittleEndian.PutUint16(body[0:], 36)
+	binary.LittleEndian.PutUint16(body[2:], uint16(len(dialects)))
+	binary.LittleEndian.PutUint16(body[4:], 1) // signing enabled
+	rand.Read(body[12:28])                     // ClientGuid
+	req.Write(body)
+	for _, d := range dialects {
+		binary.Write(&req, binary.LittleEndian, d)
+	}
+
+	conn, err := dialPort(host, port, timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	// Direct TCP transport: a zero byte and a 24-bit length before each message
+	msg := req.Bytes()
+	frame := append([]byte{0, byte(len(msg) >> 16), byte(len(msg) >> 8), byte(len(msg))}, msg...)
+	if _, err := conn.Write(frame); err != nil {
+		return "", err
+	}
+	var nbss [4]byte
+	if _, err := io.ReadFull(conn, nbss[:]); err != nil {
+		return "", err
+	}
+	n := int(nbss[1])<<16 | int(nbss[2])<<8 | int(nbss[3])
+	if n < 64+24 || n > 1<<16 {
+		return "", errors.New("not an SMB2 negotiate response")
+	}
+	resp := make([]byte, n)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return "", err
+	}
+	if !bytes.HasPrefix(resp, []byte("\xfeSMB")) || binary.LittleEndian.Uint32(resp[8:]) != 0 {
+		return "", errors.New("SMB2 negotiate failed")
+	}
+	guid := resp[64+8 : 64+24]
+	if allZero(guid) {
+		return "", errors.New("empty SMB server GUID")
+	}
+	return formatRPCUUID(guid), nil
+}
+
+/* NetBIOS */
+
+// netbiosName sends a node status request for the wildcard name and returns
+// the unique workstation name the host registered
+func netbiosName(host string, timeout time.Duration) (string, error) {
+	req := []byte{
+		0x4e, 0x42, // transaction ID
+		0x00, 0x00, // flags
+		0x00, 0x01, // questions
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x20,
+	}
+	// "*" padded with NULs, in first-level encoding: each nibble becomes a letter
+	wildcard := append([]byte{'*'}, make([]byte, 15)...)
+	for _, c := range wildcard {
+		req = append(req, 'A'+(c>>4), 'A'+(c&0x0f))
+	}
+	req = append(req, 0x00, 0x00, 0x21, 0x00, 0x01) // NBSTAT, IN
+
+	resp, err := udpExchange(host, 137, req, timeout)
+	if err != nil {
+		return "", err
+	}
+	return parseNBStat(resp)
+}
+
+func parseNBStat(resp []byte) (string, error) {
+	// Skip the header and the echoed question name
+	off := 12
+	for off < len(resp) && resp[off] != 0 {
+		if resp[off]&0xc0 == 0xc0 {
+			off++
+			break
+		}
+		off += int(resp[off]) + 1
+	}
+	off += 1 + 10 // terminator, type, class, TTL, length
+	if off >= len(resp) {
+		return "", errors.New("short NBSTAT response")
+	}
+	count := int(resp[off])
+	off++
+	for i := 0; i < count && off+18 <= len(resp); i, off = i+1, off+18 {
+		entry := resp[off : off+18]
+		suffix := entry[15]
+		group := entry[16]&0x80 != 0
+		if suffix == 0x00 && !group {
+			return strings.ToUpper(strings.TrimRight(string(entry[:15]), " \x00")), nil
+		}
+	}
+	return "", errors.New("no workstation name in NBSTAT response")
+}
+
+/* Correlation */
+
+// correlateHosts groups scanned addresses that share any host identifier
+// and records the merged identity on each of them. Addresses that share
+// nothing are left without one. NetBIOS names are chosen by administrators
+// and reused across machines, so they only name groups that other
+// identifiers formed.
+func correlateHosts(summaries []ScanSummary) {
+	parent := make([]int, len(summaries))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	owners := map[string][]int{}
+	for i := range summaries {
+		summaries[i].Identity = nil
+		for _, id := range summaries[i].Identifiers {
+			if id.Type == IdentNetBIOSName {
+				continue
+			}
+			// The same SSH key on two ports of one address links nothing
+			if list := owners[id.key()]; len(list) == 0 || list[len(list)-1] != i {
+				owners[id.key()] = append(list, i)
+			}
+		}
+	}
+	for _, members := range owners {
+		for _, m := range members[1:] {
+			parent[find(m)] = find(members[0])
+		}
+	}
+
+	groups := map[int][]int{}
+	for i := range summaries {
+		root := find(i)
+		groups[root] = append(groups[root], i)
+	}
+	for _, members := range groups {
+		addresses := map[string]bool{}
+		for _, m := range members {
+			addresses[summaries[m].Target] = true
+		}
+		if len(addresses) < 2 {
+			continue
+		}
+
+		identity := &HostIdentity{}
+		for addr := range addresses {
+			identity.Addresses = append(identity.Addresses, addr)
+		}
+		sort.Strings(identity.Addresses)
+
+		shared := map[string]HostIdentifier{}
+		for _, m := range members {
+			for _, id := range summaries[m].Identifiers {
+				if id.Type == IdentNetBIOSName {
+					if identity.Name == "" {
+						identity.Name = id.Value
+					}
+				} else if len(owners[id.key()]) > 1 {
+					shared[id.key()] = HostIdentifier{Type: id.Type, Value: id.Value}
+				}
+			}
+		}
+		keys := make([]string, 0, len(shared))
+		for k := range shared {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			identity.Shared = append(identity.Shared, shared[k])
+		}
+		// Derived from an identifier rather than an address, so the same
+		// machine keeps its ID across scans
+		sum := sha256.Sum256([]byte(keys[0]))
+		identity.ID = "host-" + hex.EncodeToString(sum[:6])
+
+		for _, m := range members {
+			summaries[m].Identity = identity
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// nbstatResponse builds an NBSTAT answer with the given question name
+// encoding and 18-byte name entries
+func nbstatResponse(question []byte, count int, entries ...[]byte) []byte {
+	resp := []byte{0x12, 0x34, 0x84, 0x00, 0, 0, 0, 1, 0, 0, 0, 0}
+	resp = append(resp, question...)
+	resp = append(resp, 0x00, 0x21, 0x00, 0x01, 0, 0, 0, 0, 0, 0)
+	resp = append(resp, byte(count))
+	for _, e := range entries {
+		resp = append(resp, e...)
+	}
+	return resp
+}
+
+func nbstatEntry(name string, suffix byte, group bool) []byte {
+	e := []byte(name + strings.Repeat(" ", 15-len(name)))
+	flags := byte(0x04)
+	if group {
+		flags |= 0x80
+	}
+	return append(e, suffix, flags, 0x00)
+}
+
+func TestParseNBStat(t *testing.T) {
+	wildcard := append([]byte{0x20, 'C', 'K'}, bytes.Repeat([]byte{'A'}, 30)...)
+	wildcard = append(wildcard, 0x00)
+	pointer := []byte{0xc0, 0x0c}
+
+	tests := []struct {
+		name    string
+		resp    []byte
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "workstation after group",
+			resp: nbstatResponse(wildcard, 3,
+				nbstatEntry("WORKGROUP", 0x00, true),
+				nbstatEntry("FILESRV", 0x20, false),
+				nbstatEntry("FILESRV", 0x00, false)),
+			want: "FILESRV",
+		},
+		{
+			name: "lower case and compressed question",
+			resp: nbstatResponse(pointer, 1, nbstatEntry("nas01", 0x00, false)),
+			want: "NAS01",
+		},
+		{
+			name:    "no workstation entry",
+			resp:    nbstatResponse(wildcard, 1, nbstatEntry("FILESRV", 0x20, false)),
+			wantErr: true,
+		},
+		{
+			name:    "count beyond the data",
+			resp:    nbstatResponse(wildcard, 4, nbstatEntry("WORKGROUP", 0x00, true)),
+			wantErr: true,
+		},
+		{
+			name:    "truncated entry",
+			resp:    nbstatResponse(wildcard, 1, nbstatEntry("FILESRV", 0x00, false)[:10]),
+			wantErr: true,
+		},
+		{name: "header only", resp: make([]byte, 12), wantErr: true},
+		{name: "empty", resp: nil, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseNBStat(tt.resp)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("parseNBStat = %q, %v; want %q, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCorrelateHosts(t *testing.T) {
+	ssh := func(v string) HostIdentifier { return HostIdentifier{Type: IdentSSHHostKey, Value: v, Port: 22} }
+	smb := func(v string) HostIdentifier { return HostIdentifier{Type: IdentSMBGUID, Value: v, Port: 445} }
+	name := func(v string) HostIdentifier { return HostIdentifier{Type: IdentNetBIOSName, Value: v} }
+
+	summaries := []ScanSummary{
+		{Target: "10.0.0.1", Identifiers: []HostIdentifier{ssh("key-a"), name("FILESRV")}},
+		{Target: "10.0.1.1", Identifiers: []HostIdentifier{ssh("key-a"), smb("guid-a"), name("FILESRV")}},
+		{Target: "10.0.2.1", Identifiers: []HostIdentifier{smb("guid-a")}},
+		// Two machines imaged with the same name share nothing else
+		{Target: "10.0.0.7", Identifiers: []HostIdentifier{name("WORKSTATION"), ssh("key-b")}},
+		{Target: "10.0.0.8", Identifiers: []HostIdentifier{name("WORKSTATION"), ssh("key-c")}},
+		// The same key on two ports of one address
+		{Target: "10.0.0.9", Identifiers: []HostIdentifier{ssh("key-d"), {Type: IdentSSHHostKey, Value: "key-d", Port: 2222}}},
+		{Target: "10.0.0.10"},
+	}
+	correlateHosts(summaries)
+
+	got := map[string]string{}
+	for _, s := range summaries {
+		if s.Identity != nil {
+			got[s.Target] = s.Identity.String()
+		}
+	}
+	want := "FILESRV at 10.0.0.1, 10.0.1.1, 10.0.2.1 (smb-guid, ssh-host-key)"
+	if !reflect.DeepEqual(got, map[string]string{"10.0.0.1": want, "10.0.1.1": want, "10.0.2.1": want}) {
+		t.Errorf("identities = %q, want only the FILESRV addresses grouped as %q", got, want)
+	}
+
+	identity := summaries[0].Identity
+	if summaries[2].Identity != identity {
+		t.Error("grouped addresses do not share one identity")
+	}
+	wantShared := []HostIdentifier{{Type: IdentSMBGUID, Value: "guid-a"}, {Type: IdentSSHHostKey, Value: "key-a"}}
+	if !reflect.DeepEqual(identity.Shared, wantShared) {
+		t.Errorf("shared = %+v, want %+v", identity.Shared, wantShared)
+	}
+
+	// The ID follows the identifiers, not the addresses or the order of hosts
+	reordered := []ScanSummary{summaries[2], summaries[1], {Target: "192.168.0.1", Identifiers: []HostIdentifier{ssh("key-a")}}}
+	correlateHosts(reordered)
+	if reordered[0].Identity == nil || reordered[0].Identity.ID != identity.ID {
+		t.Errorf("ID changed across scans: %+v, want %s", reordered[0].Identity, identity.ID)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ContainerInfo describes the Docker container behind a scanned address
+type ContainerInfo struct {
+	ID        string   `json:"id" xml:"id,attr"`
+	Name      string   `json:"name" xml:"name,attr"`
+	Image     string   `json:"image" xml:"image,attr"`
+	Network   string   `json:"network" xml:"network,attr"`
+	Published []string `json:"published,omitempty" xml:"published"`
+}
+
+func (c *ContainerInfo) String() string {
+	id := c.ID
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	s := fmt.Sprintf("%s (%s, %s) on %s", c.Name, c.Image, id, c.Network)
+	if len(c.Published) > 0 {
+		s += ", published " + strings.Join(c.Published, ", ")
+	}
+	return s
+}
+
+// dockerTarget is one address of a running container, with the ports its
+// image and run configuration declare in ExposedPorts
+type dockerTarget struct {
+	Info     ContainerInfo
+	Declared []string
+}
+
+// dockerClient talks to the Docker Engine API over its Unix socket
+type dockerClient struct {
+	http *http.Client
+}
+
+func newDockerClient(socket string, timeout time.Duration) *dockerClient {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}
+	return &dockerClient{http: &http.Client{Transport: transport, Timeout: timeout}}
+}
+
+func (c *dockerClient) get(path string, v interface{}) error {
+	// The host is ignored by the socket dialer
+	resp, err := c.http.Get("http://docker" + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("docker API %s: %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+type dockerContainer struct {
+	ID    string   `json:"Id"`
+	Names []string `json:"Names"`
+	Image string   `json:"Image"`
+	Ports []struct {
+		IP          string `json:"IP"`
+		PrivatePort int    `json:"PrivatePort"`
+		PublicPort  int    `json:"PublicPort"`
+		Type        string `json:"Type"`
+	} `json:"Ports"`
+	NetworkSettings struct {
+		Networks map[string]struct {
+			IPAddress         string `json:"IPAddress"`
+			GlobalIPv6Address string `json:"GlobalIPv6Address"`
+		} `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+type dockerInspect struct {
+	Config struct {
+		ExposedPorts map[string]struct{} `json:"ExposedPorts"`
+	} `json:"Config"`
+}
+
+// dockerTargets lists the running containers and returns one target per
+// container address, keyed by address. Containers without an address of
+// their own, such as those on the host network, are skipped.
+func dockerTargets(socket string, timeout time.Duration) (map[string]*dockerTarget, []string, error) {
+	client := newDockerClient(socket, timeout)
+	var containers []dockerContainer
+	if err := client.get("/containers/json", &containers); err != nil {
+		return nil, nil, err
+	}
+
+	targets := map[string]*dockerTarget{}
+	var order []string
+	for _, c := range containers {
+		var inspect dockerInspect
+		if err := client.get("/containers/"+c.ID+"/json", &inspect); err != nil {
+			return nil, nil, err
+		}
+		var declared []string
+		for port := range inspect.Config.ExposedPorts {
+			declared = append(declared, port)
+		}
+		sort.Strings(declared)
+
+		info := ContainerInfo{ID: c.ID, Image: c.Image}
+		if len(c.Names) > 0 {
+			info.Name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		for _, p := range c.Ports {
+			if p.PublicPort != 0 {
+				info.Published = append(info.Published, fmt.Sprintf("%s:%d->%d/%s", p.IP, p.PublicPort, p.PrivatePort, p.Type))
+			}
+		}
+
+		networks := make([]string, 0, len(c.NetworkSettings.Networks))
+		for name := range c.NetworkSettings.Networks {
+			networks = append(networks, name)
+		}
+		sort.Strings(networks)
+		for _, network := range networks {
+			settings := c.NetworkSettings.Networks[network]
+			for _, addr := range []string{settings.IPAddress, settings.GlobalIPv6Address} {
+				if addr == "" || targets[addr] != nil {
+					continue
+				}
+				t := &dockerTarget{Info: info, Declared: declared}
+				t.Info.Network = network
+				targets[addr] = t
+				order = append(order, addr)
+			}
+		}
+	}
+	return targets, order, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeDocker serves the container list and inspect endpoints on a Unix socket
+func fakeDocker(t *testing.T, containers []map[string]any, exposed map[string][]string) string {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/containers/json", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(containers)
+	})
+	mux.HandleFunc("/containers/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/containers/"), "/json")
+		ports, ok := exposed[id]
+		if !ok {
+			http.Error(w, `{"message":"No such container"}`, http.StatusNotFound)
+			return
+		}
+		set := map[string]struct{}{}
+		for _, p := range ports {
+			set[p] = struct{}{}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"Config": map[string]any{"ExposedPorts": set}})
+	})
+
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(mux)
+	srv.Listener = ln
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return socket
+}
+
+func container(id, name string, networks map[string][2]string, ports ...map[string]any) map[string]any {
+	nets := map[string]any{}
+	for network, addrs := range networks {
+		nets[network] = map[string]string{"IPAddress": addrs[0], "GlobalIPv6Address": addrs[1]}
+	}
+	return map[string]any{
+		"Id":              id,
+		"Names":           []string{"/" + name},
+		"Image":           name + ":latest",
+		"Ports":           ports,
+		"NetworkSettings": map[string]any{"Networks": nets},
+	}
+}
+
+func TestDockerTargets(t *testing.T) {
+	web := container("aaaaaaaaaaaaaaaa", "web",
+		map[string][2]string{"frontend": {"172.18.0.2", ""}, "backend": {"172.19.0.2", "fd00::2"}},
+		map[string]any{"IP": "0.0.0.0", "PrivatePort": 80, "PublicPort": 8080, "Type": "tcp"},
+		map[string]any{"PrivatePort": 443, "Type": "tcp"})
+	hostNet := container("bbbbbbbbbbbbbbbb", "agent", map[string][2]string{"host": {"", ""}})
+	// A second container reusing an address is not scanned twice
+	clash := container("cccccccccccccccc", "clash", map[string][2]string{"frontend": {"172.18.0.2", ""}})
+
+	tests := []struct {
+		name       string
+		containers []map[string]any
+		exposed    map[string][]string
+		wantOrder  []string
+		wantErr    bool
+	}{
+		{
+			name:       "addresses per network",
+			containers: []map[string]any{web, hostNet, clash},
+			exposed: map[string][]string{
+				"aaaaaaaaaaaaaaaa": {"443/tcp", "80/tcp"},
+				"bbbbbbbbbbbbbbbb": {"9100/tcp"},
+				"cccccccccccccccc": nil,
+			},
+			wantOrder: []string{"172.19.0.2", "fd00::2", "172.18.0.2"},
+		},
+		{name: "no containers", wantOrder: nil},
+		{
+			name:       "inspect fails",
+			containers: []map[string]any{web},
+			exposed:    map[string][]string{},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			socket := fakeDocker(t, tt.containers, tt.exposed)
+			targets, order, err := dockerTargets(socket, time.Second)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(order, tt.wantOrder) {
+				t.Fatalf("order = %q, want %q", order, tt.wantOrder)
+			}
+			if len(targets) != len(order) {
+				t.Fatalf("%d targets for %d addresses", len(targets), len(order))
+			}
+			if len(order) == 0 {
+				return
+			}
+
+			backend := targets["fd00::2"]
+			want := &dockerTarget{
+				Info: ContainerInfo{
+					ID:        "aaaaaaaaaaaaaaaa",
+					Name:      "web",
+					Image:     "web:latest",
+					Network:   "backend",
+					Published: []string{"0.0.0.0:8080->80/tcp"},
+				},
+				Declared: []string{"443/tcp", "80/tcp"},
+			}
+			if !reflect.DeepEqual(backend, want) {
+				t.Errorf("target = %+v, want %+v", backend, want)
+			}
+			if got := targets["172.18.0.2"].Info; got.Name != "web" || got.Network != "frontend" {
+				t.Errorf("172.18.0.2 is %s on %s, want web on frontend", got.Name, got.Network)
+			}
+		})
+	}
+
+	if _, _, err := dockerTargets(filepath.Join(t.TempDir(), "missing.sock"), time.Second); err == nil {
+		t.Error("expected an error for a missing socket")
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FindingUndeclaredPort marks an open port that the host's declared
+// configuration does not account for
+const FindingUndeclaredPort = "undeclared-port"
+
+// PortDrift compares the ports a host is declared to serve, for example by
+// its container image or firewall rules, with the ports found open. Declared
+// UDP ports are not reported as not listening, since a connect scan cannot
+// see them.
+type PortDrift struct {
+	Source       string   `json:"source" xml:"source,attr"`
+	Declared     []string `json:"declared" xml:"declared"`
+	Undeclared   []string `json:"undeclared,omitempty" xml:"undeclared"`
+	NotListening []string `json:"not_listening,omitempty" xml:"not_listening"`
+}
+
+// PortDrifts holds one drift per source of declared ports, such as a
+// container that is also a Terraform instance
+type PortDrifts []*PortDrift
+
+// UnmarshalJSON also reads results saved when a host had a single drift
+func (d *PortDrifts) UnmarshalJSON(data []byte) error {
+	var single *PortDrift
+	if json.Unmarshal(data, &single) == nil {
+		*d = nil
+		if single != nil {
+			*d = PortDrifts{single}
+		}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]*PortDrift)(d))
+}
+
+// compareDeclared records the drift between declared ports, written as
+// "port/proto" or "low-high/proto", and the open ports of a scanned host.
+// Every open port that was not declared gets one finding, which names each
+// source that does not declare it.
+func compareDeclared(summary *ScanSummary, source string, declared []string) {
+	drift := &PortDrift{Source: source, Declared: declared}
+	var ranges []portRange
+	var entries []string
+	for _, d := range declared {
+		if r, ok := parsePortRange(d); ok {
+			ranges = append(ranges, r)
+			entries = append(entries, d)
+		}
+	}
+
+	listening := make([]bool, len(ranges))
+	for i := range summary.Ports {
+		res := &summary.Ports[i]
+		covered := false
+		for j, r := range ranges {
+			if r.contains(res.Port, res.proto()) {
+				covered, listening[j] = true, true
+			}
+		}
+		if !covered {
+			key := fmt.Sprintf("%d/%s", res.Port, res.proto())
+			drift.Undeclared = append(drift.Undeclared, key)
+			if f := res.finding(FindingUndeclaredPort); f != nil {
+				f.Detail += " or " + source
+				continue
+			}
+			res.Findings = append(res.Findings, Finding{
+				Type:   FindingUndeclaredPort,
+				Source: SourceDrift,
+				Detail: fmt.Sprintf("%s is open but not declared by %s", key, source),
+			})
+		}
+	}
+	for j, r := range ranges {
+		if !listening[j] && r.Proto == "tcp" {
+			drift.NotListening = append(drift.NotListening, entries[j])
+		}
+	}
+	summary.Drift = append(summary.Drift, drift)
+}
+
+// finding returns the result's finding of a type, if it has one
+func (r *ScanResult) finding(findingType string) *Finding {
+	for i := range r.Findings {
+		if r.Findings[i].Type == findingType {
+			return &r.Findings[i]
+		}
+	}
+	return nil
+}
+
+func (d *PortDrift) String() string {
+	s := fmt.Sprintf("%d declared by %s", len(d.Declared), d.Source)
+	if len(d.Undeclared) > 0 {
+		s += ", open but undeclared: " + strings.Join(d.Undeclared, ", ")
+	}
+	if len(d.NotListening) > 0 {
+		s += ", declared but not listening: " + strings.Join(d.NotListening, ", ")
+	}
+	return s
+}
+
+// maxDeclaredRange is the largest declared port range whose ports are all
+// added to the scan; wider ones are only checked on the ports scanned anyway
+const maxDeclaredRange = 1024
+
+// portRange is a declared "low-high/proto" entry
+type portRange struct {
+	Low, High int
+	Proto     string
+}
+
+func parsePortRange(s string) (portRange, bool) {
+	ports, proto, ok := strings.Cut(s, "/")
+	if !ok {
+		return portRange{}, false
+	}
+	low, high, isRange := strings.Cut(ports, "-")
+	if !isRange {
+		high = low
+	}
+	r := portRange{Proto: proto}
+	var err1, err2 error
+	r.Low, err1 = strconv.Atoi(low)
+	r.High, err2 = strconv.Atoi(high)
+	return r, err1 == nil && err2 == nil && r.Low >= 0 && r.Low <= r.High && r.High <= 65535
+}
+
+func (r portRange) contains(port int, proto string) bool {
+	return proto == r.Proto && port >= r.Low && port <= r.High
+}
+
+// declaredTCPPorts returns the TCP ports of declared entries, skipping
+// ranges wider than maxDeclaredRange
+func declaredTCPPorts(declared []string) []int {
+	var ports []int
+	for _, d := range declared {
+		r, ok := parsePortRange(d)
+		if !ok || r.Proto != "tcp" || r.High-r.Low >= maxDeclaredRange {
+			continue
+		}
+		for p := max(r.Low, 1); p <= r.High; p++ {
+			ports = append(ports, p)
+		}
+	}
+	return ports
+}
+
+// mergePorts adds extra ports to a port list, keeping it sorted and free
+// of duplicates
+func mergePorts(ports, extra []int) []int {
+	seen := map[int]bool{}
+	var merged []int
+	for _, list := range [][]int{ports, extra} {
+		for _, p := range list {
+			if !seen[p] {
+				seen[p] = true
+				merged = append(merged, p)
+			}
+		}
+	}
+	sort.Ints(merged)
+	return merged
+}
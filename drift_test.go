@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParsePortRange(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   portRange
+		wantOK bool
+	}{
+		{"80/tcp", portRange{80, 80, "tcp"}, true},
+		{"8000-8100/tcp", portRange{8000, 8100, "tcp"}, true},
+		{"53/udp", portRange{53, 53, "udp"}, true},
+		{"0-65535/tcp", portRange{0, 65535, "tcp"}, true},
+		{"80", portRange{}, false},
+		{"http/tcp", portRange{}, false},
+		{"90-80/tcp", portRange{}, false},
+		{"80-/tcp", portRange{}, false},
+		{"-1/tcp", portRange{}, false},
+		{"70000/tcp", portRange{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parsePortRange(tt.in)
+		if ok != tt.wantOK || (ok && got != tt.want) {
+			t.Errorf("parsePortRange(%q) = %+v, %v; want %+v, %v", tt.in, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestCompareDeclaredSources(t *testing.T) {
+	summary := ScanSummary{Ports: []ScanResult{
+		{Port: 22, Protocol: "tcp", State: "open"},
+		{Port: 80, Protocol: "tcp", State: "open"},
+		{Port: 8080, Protocol: "tcp", State: "open"},
+	}}
+	compareDeclared(&summary, "Docker ExposedPorts", []string{"80/tcp", "443/tcp"})
+	compareDeclared(&summary, "security groups of aws_instance.web", []string{"80/tcp", "8080/tcp"})
+
+	if len(summary.Drift) != 2 || summary.Drift[0].Source != "Docker ExposedPorts" {
+		t.Fatalf("drift = %+v, want one per source", summary.Drift)
+	}
+	if got := summary.Drift[0].NotListening; !reflect.DeepEqual(got, []string{"443/tcp"}) {
+		t.Errorf("not listening = %q", got)
+	}
+	wantDetail := map[int]string{
+		22:   "22/tcp is open but not declared by Docker ExposedPorts or security groups of aws_instance.web",
+		8080: "8080/tcp is open but not declared by Docker ExposedPorts",
+	}
+	for _, res := range summary.Ports {
+		var details []string
+		for _, f := range res.Findings {
+			details = append(details, f.Detail)
+		}
+		want := []string(nil)
+		if d, ok := wantDetail[res.Port]; ok {
+			want = []string{d}
+		}
+		if !reflect.DeepEqual(details, want) {
+			t.Errorf("port %d findings = %q, want %q", res.Port, details, want)
+		}
+	}
+}
+
+func TestPortDriftsUnmarshal(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want PortDrifts
+	}{
+		{"list", `[{"source":"a","declared":["80/tcp"]},{"source":"b","declared":null}]`,
+			PortDrifts{{Source: "a", Declared: []string{"80/tcp"}}, {Source: "b"}}},
+		{"single drift of older results", `{"source":"a","declared":["80/tcp"]}`,
+			PortDrifts{{Source: "a", Declared: []string{"80/tcp"}}}},
+		{"null", `null`, nil},
+	}
+	for _, tt := range tests {
+		var got PortDrifts
+		if err := json.Unmarshal([]byte(tt.json), &got); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// discoveredHost is a host and the open ports a discovery tool reported for it
+type discoveredHost struct {
+	Host  string
+	Ports []ScanResult
+}
+
+// readDiscoveryFiles reads the output of fast discovery tools, "-" meaning
+// standard input, and groups the ports by host in the order hosts first appear
+func readDiscoveryFiles(files []string, defaultPorts []int) ([]discoveredHost, error) {
+	d := &discoveryReader{defaultPorts: defaultPorts, index: map[string]int{}}
+	for _, file := range files {
+		var r io.Reader = os.Stdin
+		if file != "-" {
+			f, err := os.Open(file)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			r = f
+		}
+		if err := d.read(r); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return d.hosts, nil
+}
+
+// discoveryReader recognizes each line on its own, so files mixing formats
+// and concatenated outputs are accepted. Supported are masscan -oJ, -oD and
+// -oL, naabu plain and -json, zmap with a CSV header or bare addresses, and
+// host:port lines. Bare addresses are combined with the ports given by -ports.
+type discoveryReader struct {
+	defaultPorts []int
+	zmapColumns  map[string]int
+	hosts        []discoveredHost
+	index        map[string]int
+	seen         map[string]bool
+}
+
+func (d *discoveryReader) read(r io.Reader) error {
+	d.zmapColumns = nil
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		if err := d.parseLine(strings.TrimSpace(scanner.Text())); err != nil {
+			return fmt.Errorf("line %d: %w", lineNo, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func (d *discoveryReader) parseLine(line string) error {
+	// masscan -oJ wraps objects in an array, one per line with trailing commas
+	line = strings.TrimSuffix(line, ",")
+	switch {
+	case line == "" || line == "[" || line == "]" || strings.HasPrefix(line, "#"):
+		return nil
+	case strings.HasPrefix(line, "{finished"):
+		return nil
+	case strings.HasPrefix(line, "{"):
+		return d.parseJSON(line)
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) >= 4 && fields[0] == "banner" {
+		return nil
+	}
+	if len(fields) >= 4 && fields[0] == "open" {
+		// masscan -oL: open tcp 80 10.0.0.1 1700000000
+		port, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return fmt.Errorf("bad port in %q", line)
+		}
+		return d.add(fields[3], port, fields[1])
+	}
+
+	if strings.Contains(line, ",") {
+		return d.parseZmapCSV(line)
+	}
+
+	if host, portStr, err := net.SplitHostPort(line); err == nil {
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			return fmt.Errorf("bad port in %q", line)
+		}
+		return d.add(host, port, "tcp")
+	}
+
+	// zmap's default output is one address per line
+	if len(d.defaultPorts) == 0 {
+		return fmt.Errorf("%q has no port; give the probed ports with -ports", line)
+	}
+	for _, port := range d.defaultPorts {
+		if err := d.add(line, port, "tcp"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *discoveryReader) parseJSON(line string) error {
+	var rec struct {
+		IP       string          `json:"ip"`
+		Host     string          `json:"host"`
+		Port     json.RawMessage `json:"port"`
+		Protocol string          `json:"protocol"`
+		Proto    string          `json:"proto"`
+		Ports    []struct {
+			Port   int    `json:"port"`
+			Proto  string `json:"proto"`
+			Status string `json:"status"`
+		} `json:"ports"`
+	}
+	if err := json.Unmarshal([]byte(line), &rec); err != nil {
+		return err
+	}
+
+	// masscan: {"ip": "...", "ports": [{"port": 80, "proto": "tcp", "status": "open"}]}
+	if len(rec.Ports) > 0 {
+		for _, p := range rec.Ports {
+			if p.Status != "" && p.Status != "open" {
+				continue
+			}
+			if err := d.add(rec.IP, p.Port, p.Proto); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// naabu: {"host": "...", "ip": "...", "port": 80, "protocol": "tcp"}, where
+	// older releases wrote the port as {"Port": 80, "Protocol": 0}, and
+	// masscan -oD: {"ip": "...", "port": 80, "proto": "tcp", "rec_type": "status"}
+	host := rec.Host
+	if host == "" {
+		host = rec.IP
+	}
+	if rec.Protocol == "" {
+		rec.Protocol = rec.Proto
+	}
+	var port int
+	if err := json.Unmarshal(rec.Port, &port); err != nil {
+		var obj struct {
+			Port     int `json:"Port"`
+			Protocol int `json:"Protocol"`
+		}
+		if err := json.Unmarshal(rec.Port, &obj); err != nil {
+			return fmt.Errorf("unrecognized JSON record")
+		}
+		port = obj.Port
+		if obj.Protocol == 1 {
+			rec.Protocol = "udp"
+		}
+	}
+	return d.add(host, port, rec.Protocol)
+}
+
+// parseZmapCSV handles zmap -O csv output, whose first line names the fields
+func (d *discoveryReader) parseZmapCSV(line string) error {
+	cols := strings.Split(line, ",")
+	if d.zmapColumns == nil {
+		d.zmapColumns = map[string]int{}
+		for i, name := range cols {
+			d.zmapColumns[strings.TrimSpace(name)] = i
+		}
+		if _, ok := d.zmapColumns["saddr"]; !ok {
+			return fmt.Errorf("unrecognized line %q", line)
+		}
+		return nil
+	}
+
+	get := func(name string) string {
+		if i, ok := d.zmapColumns[name]; ok && i < len(cols) {
+			return strings.TrimSpace(cols[i])
+		}
+		return ""
+	}
+	if s := get("success"); s == "0" || s == "false" {
+		return nil
+	}
+	host := get("saddr")
+	// Replies come from the probed port, so it is the reply's source port
+	portStr := get("sport")
+	if portStr == "" {
+		portStr = get("dport")
+	}
+	if portStr == "" {
+		for _, port := range d.defaultPorts {
+			if err := d.add(host, port, "tcp"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return fmt.Errorf("bad port in %q", line)
+	}
+	return d.add(host, port, "tcp")
+}
+
+func (d *discoveryReader) add(host string, port int, proto string) error {
+	host = strings.Trim(host, "[]")
+	if host == "" || port <= 0 || port > 65535 {
+		return fmt.Errorf("invalid host or port %q:%d", host, port)
+	}
+	proto = strings.ToLower(proto)
+	if proto == "" {
+		proto = "tcp"
+	}
+
+	key := fmt.Sprintf("%s|%d|%s", host, port, proto)
+	if d.seen == nil {
+		d.seen = map[string]bool{}
+	}
+	if d.seen[key] {
+		return nil
+	}
+	d.seen[key] = true
+
+	i, ok := d.index[host]
+	if !ok {
+		i = len(d.hosts)
+		d.index[host] = i
+		d.hosts = append(d.hosts, discoveredHost{Host: host})
+	}
+	d.hosts[i].Ports = append(d.hosts[i].Ports, ScanResult{Port: port, Protocol: proto, State: "open"})
+	return nil
+}
+
+/* Enrichment */
+
+// enrichHost builds a summary from ports already known to be open. It skips
+// the connect sweep, grabs banners and identifies TLS, HTTP and banner-based
+// services on each TCP port.
+func enrichHost(found discoveredHost, workers int, timeout time.Duration) ScanSummary {
+	start := time.Now()
+	ports := append([]ScanResult(nil), found.Ports...)
+
+	var wg sync.WaitGroup
+	var done int
+	var mu sync.Mutex
+	sem := make(chan struct{}, workers)
+	for i := range ports {
+		if ports[i].proto() != "tcp" {
+			continue
+		}
+		wg.Add(1)
+		go func(res *ScanResult) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			res.Banner = grabBanner(found.Host, res.Port, timeout)
+			res.Probes = identifyService(found.Host, res.Port, res.Banner, timeout)
+
+			mu.Lock()
+			done++
+			fmt.Fprintf(os.Stderr, "\rEnriching: %d/%d ports", done, len(ports))
+			mu.Unlock()
+		}(&ports[i])
+	}
+	wg.Wait()
+	sort.Slice(ports, func(i, j int) bool { return ports[i].Port < ports[j].Port })
+
+	return ScanSummary{
+		Target:       found.Host,
+		OpenPorts:    len(ports),
+		ScannedPorts: len(ports),
+		TimeTaken:    time.Since(start),
+		Ports:        ports,
+	}
+}
+
+// grabBanner reads what a service sends on its own right after connecting
+func grabBanner(host string, port int, timeout time.Duration) string {
+	conn, err := dialPort(host, port, timeout)
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 256)
+	n, _ := conn.Read(buf)
+	return strings.TrimSpace(string(buf[:n]))
+}
+
+// bannerServices recognizes common services from the banner they send first
+var bannerServices = []struct {
+	pattern *regexp.Regexp
+	service string
+}{
+	{regexp.MustCompile(`^SSH-[\d.]+-(\S+)`), "SSH"},
+	{regexp.MustCompile(`^220[ -].*(?i:ftp)`), "FTP"},
+	{regexp.MustCompile(`^220[ -].*(?i:smtp|esmtp|mail)`), "SMTP"},
+	{regexp.MustCompile(`^\+OK`), "POP3"},
+	{regexp.MustCompile(`^\* OK`), "IMAP"},
+	{regexp.MustCompile(`^RFB (\d+\.\d+)`), "VNC"},
+	{regexp.MustCompile(`^.\x00\x00\x00\x0a([\d.]+[^\x00]*)`), "MySQL"},
+	{regexp.MustCompile(`^@RSYNCD: (\S+)`), "rsync"},
+}
+
+// identifyService names the service behind a TCP port. Silent ports are
+// tried with a TLS handshake and then HTTP, with or without TLS.
+func identifyService(host string, port int, banner string, timeout time.Duration) []ProbeResult {
+	if banner != "" && !strings.HasPrefix(banner, "HTTP/") {
+		for _, s := range bannerServices {
+			if m := s.pattern.FindStringSubmatch(banner); m != nil {
+				result := ProbeResult{Probe: "banner", Service: s.service}
+				if len(m) > 1 {
+					result.Version = m[1]
+				}
+				return []ProbeResult{result}
+			}
+		}
+		return nil
+	}
+
+	var results []ProbeResult
+	tlsResult, err := probeTLS(host, port, timeout)
+	if err == nil {
+		results = append(results, *tlsResult)
+	}
+	if httpResult := probeHTTP(host, port, err == nil, timeout); httpResult != nil {
+		results = append(results, *httpResult)
+	}
+	return results
+}
+
+// probeTLS completes a handshake and describes the negotiated session and
+// the server's certificate
+func probeTLS(host string, port int, timeout time.Duration) (*ProbeResult, error) {
+	conn, err := dialPort(host, port, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	cfg := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h2", "http/1.1"}}
+	if net.ParseIP(host) == nil {
+		cfg.ServerName = host
+	}
+	tc := tls.Client(conn, cfg)
+	if err := tc.Handshake(); err != nil {
+		return nil, err
+	}
+	state := tc.ConnectionState()
+
+	result := &ProbeResult{
+		Probe:   "tls",
+		Service: "TLS",
+		Version: tls.VersionName(state.Version),
+		Info:    map[string]string{"cipher": tls.CipherSuiteName(state.CipherSuite)},
+	}
+	if state.NegotiatedProtocol != "" {
+		result.Info["alpn"] = state.NegotiatedProtocol
+	}
+	if len(state.PeerCertificates) == 0 {
+		return result, nil
+	}
+	cert := state.PeerCertificates[0]
+	result.Info["subject"] = cert.Subject.String()
+	result.Info["issuer"] = cert.Issuer.String()
+	result.Info["not_after"] = cert.NotAfter.UTC().Format(time.RFC3339)
+	if cert.Subject.String() == cert.Issuer.String() {
+		result.Info["self_signed"] = "true"
+	}
+	result.Items = append(result.Items, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		result.Items = append(result.Items, ip.String())
+	}
+	return result, nil
+}
+
+var htmlTitle = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// probeHTTP requests / and records the status line, server and page title
+func probeHTTP(host string, port int, useTLS bool, timeout time.Duration) *ProbeResult {
+	// A body cut short still identifies the server
+	resp, body, _ := fetch(probeHTTPClient(timeout), probeURL(host, port, "/", useTLS), 64*1024)
+	if resp == nil {
+		return nil
+	}
+
+	service := "HTTP"
+	if useTLS {
+		service = "HTTPS"
+	}
+	result := &ProbeResult{
+		Probe:   "http",
+		Service: service,
+		Vendor:  resp.Header.Get("Server"),
+		Info:    map[string]string{"status": strconv.Itoa(resp.StatusCode)},
+	}
+	if loc := resp.Header.Get("Location"); loc != "" {
+		result.Info["location"] = loc
+	}
+	if powered := resp.Header.Get("X-Powered-By"); powered != "" {
+		result.Info["powered_by"] = powered
+	}
+	if m := htmlTitle.FindSubmatch(body); m != nil {
+		result.Info["title"] = strings.Join(strings.Fields(string(m[1])), " ")
+	}
+	return result
+}
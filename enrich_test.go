@@ -0,0 +1,154 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// discovered flattens hosts into "host port/proto" strings in read order
+func discovered(hosts []discoveredHost) []string {
+	var out []string
+	for _, h := range hosts {
+		for _, res := range h.Ports {
+			out = append(out, h.Host+" "+strconv.Itoa(res.Port)+"/"+res.proto())
+		}
+	}
+	return out
+}
+
+func TestDiscoveryReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		ports   []int
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "masscan -oJ",
+			input: `[
+{   "ip": "10.0.0.1",   "timestamp": "1700000000", "ports": [ {"port": 80, "proto": "tcp", "status": "open", "reason": "syn-ack", "ttl": 64} ] },
+{   "ip": "10.0.0.2",   "timestamp": "1700000001", "ports": [ {"port": 53, "proto": "udp", "status": "open", "reason": "none", "ttl": 64} ] },
+{   "ip": "10.0.0.1",   "timestamp": "1700000002", "ports": [ {"port": 80, "proto": "tcp", "service": {"name": "http.server", "banner": "nginx/1.24.0"} } ] },
+{   "ip": "10.0.0.3",   "timestamp": "1700000003", "ports": [ {"port": 22, "proto": "tcp", "status": "closed", "reason": "rst", "ttl": 64} ] }
+{finished: 1}
+]`,
+			want: []string{"10.0.0.1 80/tcp", "10.0.0.2 53/udp"},
+		},
+		{
+			name: "masscan -oD",
+			input: `{"ip":"10.0.0.1","timestamp":"1700000000","port":443,"proto":"tcp","rec_type":"status","data":{"status":"open","reason":"syn-ack","ttl":64}}
+{"ip":"10.0.0.1","timestamp":"1700000001","port":443,"proto":"tcp","rec_type":"banner","data":{"service_name":"ssl","banner":"TLS/1.2 cipher:0xc02f"}}
+{"ip":"10.0.0.2","timestamp":"1700000002","port":161,"proto":"udp","rec_type":"status","data":{"status":"open","reason":"none","ttl":63}}`,
+			want: []string{"10.0.0.1 443/tcp", "10.0.0.2 161/udp"},
+		},
+		{
+			name: "masscan -oL",
+			input: `#masscan
+open tcp 22 10.0.0.1 1700000000
+open udp 161 10.0.0.1 1700000001
+banner tcp 22 10.0.0.1 1700000002 ssh SSH-2.0-OpenSSH_9.6
+# end`,
+			want: []string{"10.0.0.1 22/tcp", "10.0.0.1 161/udp"},
+		},
+		{
+			name: "naabu -json",
+			input: `{"host":"scanme.example.com","ip":"203.0.113.5","port":443,"protocol":"tcp","tls":true,"timestamp":"2024-05-01T10:00:00.000Z"}
+{"ip":"203.0.113.6","port":8080,"protocol":"tcp","tls":false,"timestamp":"2024-05-01T10:00:01.000Z"}
+{"ip":"203.0.113.7","port":{"Port":53,"Protocol":1,"TLS":false},"timestamp":"2023-01-01T10:00:00.000Z"}`,
+			want: []string{"scanme.example.com 443/tcp", "203.0.113.6 8080/tcp", "203.0.113.7 53/udp"},
+		},
+		{
+			name:  "naabu host:port",
+			input: "scanme.example.com:80\n203.0.113.5:443\n[2001:db8::1]:8443\nscanme.example.com:80\n",
+			want:  []string{"scanme.example.com 80/tcp", "203.0.113.5 443/tcp", "2001:db8::1 8443/tcp"},
+		},
+		{
+			name: "zmap csv",
+			input: `saddr,daddr,sport,dport,seqnum,acknum,window,classification,success,repeat,cooldown,timestamp_str
+192.0.2.10,198.51.100.1,443,40123,3171937712,0,65535,synack,1,0,0,2024-05-01T10:00:00.123+0000
+192.0.2.11,198.51.100.1,443,40124,2837163120,0,0,rst,0,0,0,2024-05-01T10:00:00.124+0000`,
+			want: []string{"192.0.2.10 443/tcp"},
+		},
+		{
+			name:  "zmap csv without ports",
+			input: "saddr,classification,success\n192.0.2.10,synack,true\n192.0.2.11,rst,false\n",
+			ports: []int{80, 443},
+			want:  []string{"192.0.2.10 80/tcp", "192.0.2.10 443/tcp"},
+		},
+		{
+			name:  "zmap addresses",
+			input: "192.0.2.10\n192.0.2.11\n",
+			ports: []int{22},
+			want:  []string{"192.0.2.10 22/tcp", "192.0.2.11 22/tcp"},
+		},
+		{
+			name:  "mixed",
+			input: "open tcp 22 10.0.0.1 1700000000\n10.0.0.1:22\n" + `{"host":"10.0.0.1","port":80,"protocol":"tcp"}` + "\n10.0.0.2:25\n",
+			want:  []string{"10.0.0.1 22/tcp", "10.0.0.1 80/tcp", "10.0.0.2 25/tcp"},
+		},
+		{name: "address without ports", input: "192.0.2.10\n", wantErr: "line 1: \"192.0.2.10\" has no port; give the probed ports with -ports"},
+		{name: "truncated JSON", input: "[\n{   \"ip\": \"10.0.0.1\",   \"ports\": [ {\"port\": 80,\n", wantErr: "line 2: unexpected end of JSON input"},
+		{name: "JSON without port", input: `{"ip":"10.0.0.1","timestamp":"1700000000"}`, wantErr: "line 1: unrecognized JSON record"},
+		{name: "JSON port name", input: `{"host":"10.0.0.1","port":"http"}`, wantErr: "line 1: unrecognized JSON record"},
+		{name: "masscan list port name", input: "open tcp http 10.0.0.1 1700000000", wantErr: `line 1: bad port in "open tcp http 10.0.0.1 1700000000"`},
+		{name: "host:port name", input: "10.0.0.1:80\n10.0.0.1:ssh\n", wantErr: `line 2: bad port in "10.0.0.1:ssh"`},
+		{name: "port out of range", input: "10.0.0.1:70000", wantErr: `line 1: invalid host or port "10.0.0.1":70000`},
+		{name: "empty host", input: ":80", wantErr: `line 1: invalid host or port "":80`},
+		{name: "csv without saddr", input: "ip,port\n10.0.0.1,80\n", wantErr: `line 1: unrecognized line "ip,port"`},
+		{name: "zmap csv port name", input: "saddr,sport\n192.0.2.10,https\n", wantErr: `line 2: bad port in "192.0.2.10,https"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &discoveryReader{defaultPorts: tt.ports, index: map[string]int{}}
+			err := d.read(strings.NewReader(tt.input))
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := discovered(d.hosts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ports = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadDiscoveryFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	// Each zmap file starts with its own header
+	first := write("web.csv", "saddr,sport\n192.0.2.10,443\n192.0.2.11,80\n")
+	second := write("ssh.csv", "classification,saddr,success\nsynack,192.0.2.10,1\n")
+
+	hosts, err := readDiscoveryFiles([]string{first, second}, []int{22})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"192.0.2.10 443/tcp", "192.0.2.10 22/tcp", "192.0.2.11 80/tcp"}
+	if got := discovered(hosts); !reflect.DeepEqual(got, want) {
+		t.Errorf("ports = %q, want %q", got, want)
+	}
+
+	bad := write("bad.txt", "10.0.0.1:80\n10.0.0.1:http\n")
+	if _, err := readDiscoveryFiles([]string{first, bad}, nil); err == nil || !strings.HasPrefix(err.Error(), bad+": line 2:") {
+		t.Errorf("error = %v, want it to name %s line 2", err, bad)
+	}
+	if _, err := readDiscoveryFiles([]string{filepath.Join(dir, "missing.txt")}, nil); err == nil {
+		t.Error("missing file accepted")
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// FindingOpenPort is the implicit finding recorded for every open port
+const FindingOpenPort = "open-port"
+
+// Sources of findings other than probes, which are named after the probe
+const (
+	SourceScan     = "scan"
+	SourceLBDetect = "lb-detect"
+	SourceDrift    = "drift"
+)
+
+// hostFinding is a finding flattened together with the host and port it belongs to
+type hostFinding struct {
+	Host    string
+	Port    int
+	Proto   string
+	Finding Finding
+}
+
+// Fingerprint identifies a finding stably across scans
+func (f hostFinding) Fingerprint() string {
+	return findingFingerprint(f.Host, f.Proto, f.Port, f.Finding.Type)
+}
+
+// LegacyFingerprint is the fingerprint of a TCP finding from before the
+// protocol was part of it, or "" for other protocols. Jira labels and
+// suppressions written then still carry it.
+func (f hostFinding) LegacyFingerprint() string {
+	if f.Proto != "tcp" {
+		return ""
+	}
+	return legacyFingerprint(f.Host, f.Port, f.Finding.Type)
+}
+
+func findingFingerprint(host, proto string, port int, findingType string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d/%s|%s", host, port, proto, findingType)))
+	return hex.EncodeToString(sum[:8])
+}
+
+func legacyFingerprint(host string, port int, findingType string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%s", host, port, findingType)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// fingerprintFindings records the fingerprint of every open port and finding
+// of a host, so reports show what a suppression has to match
+func fingerprintFindings(summary *ScanSummary) {
+	for i := range summary.Ports {
+		res := &summary.Ports[i]
+		res.Fingerprint = ""
+		if res.State == "open" {
+			res.Fingerprint = findingFingerprint(summary.Target, res.proto(), res.Port, FindingOpenPort)
+		}
+		for j := range res.Findings {
+			res.Findings[j].Fingerprint = findingFingerprint(summary.Target, res.proto(), res.Port, res.Findings[j].Type)
+		}
+	}
+}
+
+// collectFindings lists every finding of a scan, including one per open port
+func collectFindings(summary ScanSummary) []hostFinding {
+	var findings []hostFinding
+	for _, res := range summary.Ports {
+		if res.State != "open" {
+			continue
+		}
+		findings = append(findings, hostFinding{
+			Host:  summary.Target,
+			Port:  res.Port,
+			Proto: res.proto(),
+			Finding: Finding{
+				Type:     FindingOpenPort,
+				Source:   SourceScan,
+				Score:    res.Score,
+				Severity: res.Severity,
+				Guidance: res.Guidance,
+				Accepted: res.Accepted,
+
+				Fingerprint: res.Fingerprint,
+			},
+		})
+		for _, f := range res.Findings {
+			findings = append(findings, hostFinding{Host: summary.Target, Port: res.Port, Proto: res.proto(), Finding: f})
+		}
+	}
+	return findings
+}
@@ -1,3 +1,23 @@
 module github.com/jevonteul
 
 go 1.22.2
+
+require (
+	github.com/eclipse/paho.mqtt.golang v1.4.3
+	github.com/nats-io/nats.go v1.37.0
+	github.com/segmentio/kafka-go v0.4.47
+	golang.org/x/crypto v0.18.0
+	golang.org/x/net v0.17.0
+	google.golang.org/protobuf v1.34.2
+)
+
+require (
+	github.com/gorilla/websocket v1.5.0 // indirect
+	github.com/klauspost/compress v1.17.2 // indirect
+	github.com/nats-io/nkeys v0.4.7 // indirect
+	github.com/nats-io/nuid v1.0.1 // indirect
+	github.com/pierrec/lz4/v4 v4.1.15 // indirect
+	golang.org/x/sync v0.1.0 // indirect
+	golang.org/x/sys v0.16.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
+)
@@ -0,0 +1,112 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Guidance explains a finding or exposed service to whoever has to fix it
+type Guidance struct {
+	Title       string   `json:"title" xml:"title"`
+	Description string   `json:"description,omitempty" xml:"description,omitempty"`
+	Impact      string   `json:"impact,omitempty" xml:"impact,omitempty"`
+	Remediation []string `json:"remediation,omitempty" xml:"remediation>step,omitempty"`
+	References  []string `json:"references,omitempty" xml:"references>url,omitempty"`
+}
+
+// Text renders the guidance as plain text, one section per paragraph
+func (g *Guidance) Text() string {
+	var b strings.Builder
+	b.WriteString(g.Title)
+	if g.Description != "" {
+		b.WriteString("\n" + g.Description)
+	}
+	if g.Impact != "" {
+		b.WriteString("\nImpact: " + g.Impact)
+	}
+	if len(g.Remediation) > 0 {
+		b.WriteString("\nRemediation:")
+		for _, step := range g.Remediation {
+			b.WriteString("\n  - " + step)
+		}
+	}
+	if len(g.References) > 0 {
+		b.WriteString("\nReferences:")
+		for _, ref := range g.References {
+			b.WriteString("\n  - " + ref)
+		}
+	}
+	return b.String()
+}
+
+//go:embed guidance.json
+var embeddedGuidance []byte
+
+// KnowledgeBase maps finding types and service names to guidance. Ports name
+// the service usually found on a port ("6379" or "6379/tcp") for ports no
+// probe identified.
+type KnowledgeBase struct {
+	Findings map[string]*Guidance `json:"findings"`
+	Services map[string]*Guidance `json:"services"`
+	Ports    map[string]string    `json:"ports"`
+}
+
+// loadKnowledgeBase reads the embedded knowledge base and lays an optional
+// JSON file of the same shape over it, replacing entries key by key
+func loadKnowledgeBase(path string) (*KnowledgeBase, error) {
+	kb := &KnowledgeBase{}
+	if err := json.Unmarshal(embeddedGuidance, kb); err != nil {
+		return nil, fmt.Errorf("embedded guidance: %w", err)
+	}
+	if path == "" {
+		return kb, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var override KnowledgeBase
+	if err := json.Unmarshal(data, &override); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for k, g := range override.Findings {
+		kb.Findings[k] = g
+	}
+	for k, g := range override.Services {
+		kb.Services[k] = g
+	}
+	for k, name := range override.Ports {
+		kb.Ports[k] = name
+	}
+	return kb, nil
+}
+
+// Annotate attaches guidance to every open port and finding of a host
+func (kb *KnowledgeBase) Annotate(summary *ScanSummary) {
+	for i := range summary.Ports {
+		res := &summary.Ports[i]
+		res.Guidance = kb.service(*res)
+		for j := range res.Findings {
+			res.Findings[j].Guidance = kb.Findings[res.Findings[j].Type]
+		}
+	}
+}
+
+// service looks a port up by the probes that identified it, then by port number
+func (kb *KnowledgeBase) service(res ScanResult) *Guidance {
+	for _, p := range res.Probes {
+		if g, ok := kb.Services[p.Probe]; ok {
+			return g
+		}
+	}
+	name, ok := kb.Ports[strconv.Itoa(res.Port)+"/"+res.proto()]
+	if !ok {
+		name = kb.Ports[strconv.Itoa(res.Port)]
+	}
+	return kb.Services[name]
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKnowledgeBaseAnnotate(t *testing.T) {
+	kb, err := loadKnowledgeBase("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for port, name := range kb.Ports {
+		if kb.Services[name] == nil {
+			t.Errorf("port %s names service %q, which has no guidance", port, name)
+		}
+	}
+
+	summary := ScanSummary{Ports: []ScanResult{
+		{Port: 6379, Protocol: "tcp", State: "open"},
+		{Port: 445, Protocol: "tcp", State: "open"},
+		{Port: 8443, Protocol: "tcp", State: "open", Probes: []ProbeResult{{Probe: "tls"}, {Probe: "http"}}},
+		{Port: 2049, Protocol: "tcp", State: "open", Findings: []Finding{{Type: FindingNFSWorldExport}, {Type: "made-up"}}},
+		{Port: 6379, Protocol: "udp", State: "open"},
+		{Port: 8080, Protocol: "tcp", State: "open"},
+	}}
+	kb.Annotate(&summary)
+
+	want := []string{"Redis exposed", "SMB exposed", "TLS service", "NFS exposed", "", ""}
+	for i, res := range summary.Ports {
+		got := ""
+		if res.Guidance != nil {
+			got = res.Guidance.Title
+		}
+		if got != want[i] {
+			t.Errorf("%d/%s guidance = %q, want %q", res.Port, res.proto(), got, want[i])
+		}
+	}
+	nfs := summary.Ports[3].Findings
+	if nfs[0].Guidance == nil || nfs[0].Guidance != kb.Findings[FindingNFSWorldExport] {
+		t.Errorf("%s finding has no guidance", FindingNFSWorldExport)
+	}
+	if nfs[1].Guidance != nil {
+		t.Errorf("unknown finding got guidance %q", nfs[1].Guidance.Title)
+	}
+}
+
+func TestLoadKnowledgeBaseOverride(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "guidance.json")
+	data := `{"services": {"redis": {"title": "Redis runbook", "references": ["https://wiki.example.com/redis"]}}, "ports": {"8080/tcp": "redis"}}`
+	if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	kb, err := loadKnowledgeBase(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := kb.Services["redis"]; g.Title != "Redis runbook" || g.Description != "" {
+		t.Errorf("redis guidance = %+v, want the override to replace it", g)
+	}
+	if kb.Services["smb"] == nil || kb.Ports["6379/tcp"] != "redis" || kb.Ports["8080/tcp"] != "redis" {
+		t.Error("override dropped built-in entries")
+	}
+
+	if err := os.WriteFile(file, []byte(`{"services": []}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadKnowledgeBase(file); err == nil {
+		t.Error("malformed guidance file accepted")
+	}
+}
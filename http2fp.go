@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// HTTP2Fingerprint describes how a server speaks HTTP/2. Settings, window
+// sizes and header compression come from the server's HTTP/2 stack, so they
+// survive proxies that strip or rewrite response headers.
+type HTTP2Fingerprint struct {
+	Mode         string         `json:"mode"` // h2 over TLS, or h2c with prior knowledge
+	Settings     []HTTP2Setting `json:"settings"`
+	WindowUpdate uint32         `json:"window_update,omitempty"`
+	FrameOrder   []string       `json:"frame_order"`
+	HPACK        []string       `json:"hpack,omitempty"`
+	HeaderOrder  []string       `json:"header_order,omitempty"`
+	Server       string         `json:"server,omitempty"`
+	Fingerprint  string         `json:"fingerprint"`
+	Match        string         `json:"match,omitempty"`
+}
+
+// HTTP2Setting is one parameter of the server's initial SETTINGS frame, in
+// the order it was sent
+type HTTP2Setting struct {
+	ID    uint16 `json:"id"`
+	Name  string `json:"name"`
+	Value uint32 `json:"value"`
+}
+
+// knownHTTP2Servers maps the settings and connection window part of a
+// fingerprint to the server software that sends them by default
+var knownHTTP2Servers = map[string]string{
+	"3:128;4:65536;5:16777215|2147418112":                   "nginx",
+	"5:1048576;3:250;6:1048896;1:4096;4:1048576|983041":     "Go net/http",
+	"5:1048576;3:250;6:1048896;1:4096;4:1048576;9:1|983041": "Go net/http",
+}
+
+// maxHTTP2Frames bounds the frames read from one connection
+const maxHTTP2Frames = 64
+
+// fingerprintHTTP2 tries HTTP/2 on every open TCP port, over TLS with ALPN
+// first and then in cleartext with prior knowledge
+func fingerprintHTTP2(summary *ScanSummary, workers int, timeout time.Duration) {
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i := range summary.Ports {
+		if summary.Ports[i].proto() != "tcp" {
+			continue
+		}
+		wg.Add(1)
+		go func(res *ScanResult) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			if fp := probeHTTP2(summary.Target, res.Port, true, timeout); fp != nil {
+				res.HTTP2 = fp
+			} else {
+				res.HTTP2 = probeHTTP2(summary.Target, res.Port, false, timeout)
+			}
+		}(&summary.Ports[i])
+	}
+	wg.Wait()
+}
+
+// probeHTTP2 sends two GET / requests on one connection, the second to see
+// whether the server reuses its HPACK dynamic table, and records every frame
+// the server sends until the second response headers arrive
+func probeHTTP2(host string, port int, useTLS bool, timeout time.Duration) *HTTP2Fingerprint {
+	conn, err := dialPort(host, port, timeout)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	fp := &HTTP2Fingerprint{Mode: "h2c"}
+	scheme := "http"
+	if useTLS {
+		cfg := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h2"}}
+		if net.ParseIP(host) == nil {
+			cfg.ServerName = host
+		}
+		tc := tls.Client(conn, cfg)
+		if tc.Handshake() != nil || tc.ConnectionState().NegotiatedProtocol != "h2" {
+			return nil
+		}
+		conn, fp.Mode, scheme = transcripts.recordDecrypted(tc, host, port), "h2", "https"
+	}
+
+	var reqBuf bytes.Buffer
+	enc := hpack.NewEncoder(&reqBuf)
+	request := func() []byte {
+		reqBuf.Reset()
+		for _, f := range []hpack.HeaderField{
+			{Name: ":method", Value: "GET"},
+			{Name: ":scheme", Value: scheme},
+			{Name: ":authority", Value: net.JoinHostPort(host, strconv.Itoa(port))},
+			{Name: ":path", Value: "/"},
+			{Name: "user-agent", Value: "portscanner"},
+			{Name: "accept", Value: "*/*"},
+		} {
+			enc.WriteField(f)
+		}
+		return append([]byte(nil), reqBuf.Bytes()...)
+	}
+
+	fr := http2.NewFramer(conn, conn)
+	if _, err := io.WriteString(conn, http2.ClientPreface); err != nil {
+		return nil
+	}
+	fr.WriteSettings(http2.Setting{ID: http2.SettingEnablePush, Val: 0}, http2.Setting{ID: http2.SettingInitialWindowSize, Val: 1 << 24})
+	fr.WriteWindowUpdate(0, 1<<24)
+	fr.WriteHeaders(http2.HeadersFrameParam{StreamID: 1, BlockFragment: request(), EndStream: true, EndHeaders: true})
+
+	dec := hpack.NewDecoder(4096, nil)
+	var first, second hpackStats
+	var block []byte
+	settingsSeen, firstDone, secondSent := false, false, false
+
+frames:
+	for i := 0; i < maxHTTP2Frames; i++ {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		frame, err := fr.ReadFrame()
+		if err != nil {
+			break
+		}
+		fp.FrameOrder = appendFrame(fp.FrameOrder, frame)
+
+		h := frame.Header()
+		if !settingsSeen && h.Type != http2.FrameSettings {
+			// A server's first frame must be SETTINGS
+			return nil
+		}
+		if h.StreamID == 1 && (h.Type == http2.FrameRSTStream ||
+			(h.Type == http2.FrameData || h.Type == http2.FrameHeaders) && h.Flags.Has(http2.FlagDataEndStream)) {
+			firstDone = true
+		}
+
+		headersEnded := false
+		switch f := frame.(type) {
+		case *http2.SettingsFrame:
+			if f.IsAck() || settingsSeen {
+				break
+			}
+			settingsSeen = true
+			f.ForeachSetting(func(s http2.Setting) error {
+				fp.Settings = append(fp.Settings, HTTP2Setting{ID: uint16(s.ID), Name: s.ID.String(), Value: s.Val})
+				return nil
+			})
+			fr.WriteSettingsAck()
+
+		case *http2.WindowUpdateFrame:
+			if h.StreamID == 0 && fp.WindowUpdate == 0 {
+				fp.WindowUpdate = f.Increment
+			}
+
+		case *http2.PingFrame:
+			if !f.IsAck() {
+				fr.WritePing(true, f.Data)
+			}
+
+		case *http2.GoAwayFrame:
+			break frames
+
+		case *http2.HeadersFrame:
+			block = append(block[:0], f.HeaderBlockFragment()...)
+			headersEnded = f.HeadersEnded()
+
+		case *http2.ContinuationFrame:
+			block = append(block, f.HeaderBlockFragment()...)
+			headersEnded = f.HeadersEnded()
+		}
+
+		if headersEnded {
+			fields, _ := dec.DecodeFull(block)
+			if h.StreamID != 1 {
+				second.read(block)
+				break frames
+			}
+			first.read(block)
+			for _, f := range fields {
+				fp.HeaderOrder = append(fp.HeaderOrder, f.Name)
+				if f.Name == "server" {
+					fp.Server = f.Value
+				}
+			}
+		}
+
+		// The second request goes out once the first one is answered
+		if firstDone && !secondSent {
+			secondSent = true
+			fr.WriteHeaders(http2.HeadersFrameParam{StreamID: 3, BlockFragment: request(), EndStream: true, EndHeaders: true})
+		}
+	}
+	if !settingsSeen {
+		return nil
+	}
+
+	fp.HPACK = hpackTraits(first, second)
+	fp.Fingerprint = fp.signature()
+	fp.Match = knownHTTP2Servers[fp.settingsKey()]
+	return fp
+}
+
+// appendFrame records a frame type with its notable flags, collapsing runs
+// of DATA frames that only reflect the size of the response body
+func appendFrame(order []string, frame http2.Frame) []string {
+	h := frame.Header()
+	name := h.Type.String()
+	switch {
+	case h.Type == http2.FrameSettings && h.Flags.Has(http2.FlagSettingsAck),
+		h.Type == http2.FramePing && h.Flags.Has(http2.FlagPingAck):
+		name += "+ACK"
+	case h.Type == http2.FrameData && h.Flags.Has(http2.FlagDataEndStream),
+		h.Type == http2.FrameHeaders && h.Flags.Has(http2.FlagHeadersEndStream):
+		name += "+END_STREAM"
+	case h.Type == http2.FrameWindowUpdate && h.StreamID != 0:
+		name += "(stream)"
+	}
+	if n := len(order); n > 0 && name == "DATA" && (order[n-1] == "DATA" || order[n-1] == "DATA*") {
+		order[n-1] = "DATA*"
+		return order
+	}
+	return append(order, name)
+}
+
+func (fp *HTTP2Fingerprint) settingsKey() string {
+	var settings []string
+	for _, s := range fp.Settings {
+		settings = append(settings, fmt.Sprintf("%d:%d", s.ID, s.Value))
+	}
+	return strings.Join(settings, ";") + "|" + strconv.FormatUint(uint64(fp.WindowUpdate), 10)
+}
+
+// signature joins settings, connection window update, HPACK traits and frame
+// order into one comparable string
+func (fp *HTTP2Fingerprint) signature() string {
+	return strings.Join([]string{fp.settingsKey(), strings.Join(fp.HPACK, ","), strings.Join(fp.FrameOrder, ",")}, "|")
+}
+
+func (fp *HTTP2Fingerprint) String() string {
+	s := fp.Mode + " " + fp.Fingerprint
+	if fp.Match != "" {
+		s += " (" + fp.Match + ")"
+	}
+	return s
+}
+
+// hpackStats counts the header field representations of one header block
+// (RFC 7541 section 6)
+type hpackStats struct {
+	indexedStatic  int
+	indexedDynamic int
+	incremental    int
+	noIndexing     int
+	neverIndexed   int
+	sizeUpdate     int
+	huffman        int
+	raw            int
+}
+
+func (s *hpackStats) read(block []byte) {
+	for len(block) > 0 {
+		b := block[0]
+		var index uint64
+		var ok bool
+		switch {
+		case b&0x80 != 0:
+			if index, block, ok = hpackInt(block, 7); !ok {
+				return
+			}
+			if index > 61 {
+				s.indexedDynamic++
+			} else {
+				s.indexedStatic++
+			}
+			continue
+		case b&0xc0 == 0x40:
+			s.incremental++
+			index, block, ok = hpackInt(block, 6)
+		case b&0xe0 == 0x20:
+			s.sizeUpdate++
+			if _, block, ok = hpackInt(block, 5); !ok {
+				return
+			}
+			continue
+		case b&0xf0 == 0x10:
+			s.neverIndexed++
+			index, block, ok = hpackInt(block, 4)
+		default:
+			s.noIndexing++
+			index, block, ok = hpackInt(block, 4)
+		}
+		if !ok {
+			return
+		}
+		// Literal fields carry a name string unless the name is indexed,
+		// then a value string
+		strs := 1
+		if index == 0 {
+			strs = 2
+		}
+		for ; strs > 0; strs-- {
+			if len(block) == 0 {
+				return
+			}
+			if block[0]&0x80 != 0 {
+				s.huffman++
+			} else {
+				s.raw++
+			}
+			var n uint64
+			if n, block, ok = hpackInt(block, 7); !ok || n > uint64(len(block)) {
+				return
+			}
+			block = block[n:]
+		}
+	}
+}
+
+// hpackInt decodes an integer with an n-bit prefix
+func hpackInt(b []byte, n uint) (uint64, []byte, bool) {
+	if len(b) == 0 {
+		return 0, b, false
+	}
+	max := uint64(1)<<n - 1
+	v := uint64(b[0]) & max
+	b = b[1:]
+	if v < max {
+		return v, b, true
+	}
+	for shift := uint(0); len(b) > 0 && shift < 63; shift += 7 {
+		c := b[0]
+		b = b[1:]
+		v += uint64(c&0x7f) << shift
+		if c&0x80 == 0 {
+			return v, b, true
+		}
+	}
+	return 0, b, false
+}
+
+// hpackTraits describes how the server compresses response headers: the
+// literal representations it uses, whether strings are Huffman coded and
+// whether the second response refers back to the dynamic table
+func hpackTraits(first, second hpackStats) []string {
+	var traits []string
+	if first.sizeUpdate > 0 {
+		traits = append(traits, "size-update")
+	}
+	if first.incremental > 0 {
+		traits = append(traits, "incremental")
+	}
+	if first.noIndexing > 0 {
+		traits = append(traits, "no-indexing")
+	}
+	if first.neverIndexed > 0 {
+		traits = append(traits, "never-indexed")
+	}
+	switch {
+	case first.huffman > 0 && first.raw > 0:
+		traits = append(traits, "huffman-mixed")
+	case first.huffman > 0:
+		traits = append(traits, "huffman")
+	case first.raw > 0:
+		traits = append(traits, "raw")
+	}
+	if second.indexedDynamic > 0 {
+		traits = append(traits, "dynamic-reuse")
+	}
+	return traits
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestHPACKStatsRead(t *testing.T) {
+	tests := []struct {
+		name  string
+		block string
+		want  hpackStats
+	}{
+		// Header blocks from RFC 7541 appendix C
+		{"C.2.2 literal without indexing", "040c2f73616d706c652f70617468", hpackStats{noIndexing: 1, raw: 1}},
+		{"C.2.3 never indexed new name", "100870617373776f726406736563726574", hpackStats{neverIndexed: 1, raw: 2}},
+		{"C.3.1 request", "828684410f7777772e6578616d706c652e636f6d", hpackStats{indexedStatic: 3, incremental: 1, raw: 1}},
+		{"C.3.2 dynamic table reuse", "828684be58086e6f2d6361636865", hpackStats{indexedStatic: 3, indexedDynamic: 1, incremental: 1, raw: 1}},
+		{"C.4.1 huffman request", "828684418cf1e3c2e5f23a6ba0ab90f4ff", hpackStats{indexedStatic: 3, incremental: 1, huffman: 1}},
+		{"table size update", "3fe11f82", hpackStats{sizeUpdate: 1, indexedStatic: 1}},
+		{"truncated value", "410f77", hpackStats{incremental: 1, raw: 1}},
+		{"truncated integer", "ff", hpackStats{}},
+		{"empty", "", hpackStats{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block, err := hex.DecodeString(tt.block)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got hpackStats
+			got.read(block)
+			if got != tt.want {
+				t.Errorf("read = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// IPv6 target generation strategies
+const (
+	IPv6LowByte = "lowbyte"
+	IPv6Ports   = "ports"
+	IPv6Seeds   = "seeds"
+	IPv6EUI64   = "eui64"
+)
+
+var ipv6Strategies = []string{IPv6LowByte, IPv6Ports, IPv6Seeds, IPv6EUI64}
+
+// ipv6ServicePorts are embedded in addresses like 2001:db8::443 by admins
+// numbering hosts after the service they run
+var ipv6ServicePorts = []int{22, 25, 53, 80, 110, 143, 443, 445, 587, 993, 995, 1433, 3306, 3389, 5432, 8080, 8443}
+
+// macVendors are OUIs commonly seen on servers and appliances, used to guess
+// SLAAC addresses derived from MAC addresses
+var macVendors = map[string][]string{
+	"vmware":     {"00:50:56", "00:0c:29"},
+	"hyperv":     {"00:15:5d"},
+	"qemu":       {"52:54:00"},
+	"xen":        {"00:16:3e"},
+	"virtualbox": {"08:00:27"},
+	"raspberry":  {"b8:27:eb", "dc:a6:32", "e4:5f:01"},
+	"supermicro": {"00:25:90", "ac:1f:6b"},
+	"dell":       {"00:14:22", "f8:bc:12", "18:66:da"},
+	"hpe":        {"00:17:a4", "94:18:82"},
+	"cisco":      {"00:1b:54", "00:26:0b"},
+	"ubiquiti":   {"24:a4:3c", "fc:ec:da"},
+}
+
+// ipv6Generator produces likely-used addresses inside IPv6 prefixes, since
+// sweeping a /64 is impossible. Each strategy yields at most PerStrategy
+// addresses per prefix and generation stops after Max addresses overall.
+type ipv6Generator struct {
+	Prefixes    []netip.Prefix
+	Strategies  map[string]bool
+	Seeds       []netip.Addr
+	OUIs        [][3]byte
+	Max         int
+	PerStrategy int
+}
+
+func newIPv6Generator(prefixList, strategyList, seedFile, vendorList string) (*ipv6Generator, error) {
+	g := &ipv6Generator{Strategies: map[string]bool{}}
+
+	for _, p := range strings.Split(prefixList, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(p)
+		if err != nil || !prefix.Addr().Is6() || prefix.Bits() > 64 {
+			return nil, fmt.Errorf("invalid IPv6 prefix %q, expected /64 or shorter", p)
+		}
+		g.Prefixes = append(g.Prefixes, prefix.Masked())
+	}
+
+	for _, s := range strings.Split(strategyList, ",") {
+		s = strings.TrimSpace(s)
+		if s == "all" {
+			for _, name := range ipv6Strategies {
+				g.Strategies[name] = true
+			}
+			continue
+		}
+		valid := false
+		for _, name := range ipv6Strategies {
+			valid = valid || name == s
+		}
+		if !valid {
+			return nil, fmt.Errorf("unknown IPv6 strategy %q (%s)", s, strings.Join(ipv6Strategies, ", "))
+		}
+		g.Strategies[s] = true
+	}
+
+	if seedFile != "" {
+		seeds, err := readIPv6Seeds(seedFile)
+		if err != nil {
+			return nil, err
+		}
+		g.Seeds = seeds
+		// A seed's own /64 is worth searching with every other strategy too
+		for _, seed := range seeds {
+			prefix := netip.PrefixFrom(seed, 64).Masked()
+			if !containsPrefix(g.Prefixes, prefix) {
+				g.Prefixes = append(g.Prefixes, prefix)
+			}
+		}
+	}
+
+	for _, v := range strings.Split(vendorList, ",") {
+		if v = strings.ToLower(strings.TrimSpace(v)); v == "" {
+			continue
+		}
+		ouis := macVendors[v]
+		if v == "all" {
+			names := make([]string, 0, len(macVendors))
+			for name := range macVendors {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				ouis = append(ouis, macVendors[name]...)
+			}
+		} else if ouis == nil {
+			ouis = []string{v}
+		}
+		for _, s := range ouis {
+			b, err := hex.DecodeString(strings.NewReplacer(":", "", "-", "").Replace(s))
+			if err != nil || len(b) != 3 {
+				return nil, fmt.Errorf("unknown MAC vendor or OUI %q", v)
+			}
+			g.OUIs = append(g.OUIs, [3]byte{b[0], b[1], b[2]})
+		}
+	}
+	return g, nil
+}
+
+func readIPv6Seeds(file string) ([]netip.Addr, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var seeds []netip.Addr
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		addr, err := netip.ParseAddr(line)
+		if err != nil || !addr.Is6() {
+			return nil, fmt.Errorf("%s: invalid IPv6 seed %q", file, line)
+		}
+		seeds = append(seeds, addr)
+	}
+	return seeds, scanner.Err()
+}
+
+// Run sends generated addresses to out as they are consumed and reports how
+// many it produced
+func (g *ipv6Generator) Run(out chan<- string) int {
+	seen := map[netip.Addr]bool{}
+	total := 0
+	emit := func(addr netip.Addr) bool {
+		if g.Max > 0 && total >= g.Max {
+			return false
+		}
+		if !seen[addr] {
+			seen[addr] = true
+			total++
+			out <- addr.String()
+		}
+		return true
+	}
+
+	for _, prefix := range g.Prefixes {
+		// Prefixes shorter than /64 are searched one /64 at a time, lowest
+		// subnet ID first, until -ipv6-max is reached
+		eachSubnet64(prefix, func(subnet netip.Prefix) bool {
+			for _, strategy := range ipv6Strategies {
+				if !g.Strategies[strategy] {
+					continue
+				}
+				n := 0
+				ok := g.generate(strategy, subnet, func(iid uint64) bool {
+					if g.PerStrategy > 0 && n >= g.PerStrategy {
+						return false
+					}
+					n++
+					return emit(withIID(subnet, iid))
+				})
+				if !ok && g.Max > 0 && total >= g.Max {
+					return false
+				}
+			}
+			return true
+		})
+		if g.Max > 0 && total >= g.Max {
+			return total
+		}
+	}
+	return total
+}
+
+// eachSubnet64 calls yield with every /64 of a prefix in order until it
+// returns false
+func eachSubnet64(prefix netip.Prefix, yield func(subnet netip.Prefix) bool) {
+	b := prefix.Masked().Addr().As16()
+	network := binary.BigEndian.Uint64(b[:8])
+	last := uint64(0)
+	if bits := 64 - prefix.Bits(); bits > 0 {
+		last = ^uint64(0) >> (64 - bits)
+	}
+	for i := uint64(0); ; i++ {
+		binary.BigEndian.PutUint64(b[:8], network|i)
+		if !yield(netip.PrefixFrom(netip.AddrFrom16(b), 64)) || i == last {
+			return
+		}
+	}
+}
+
+// generate yields interface identifiers for one strategy until yield
+// returns false
+func (g *ipv6Generator) generate(strategy string, prefix netip.Prefix, yield func(iid uint64) bool) bool {
+	switch strategy {
+	case IPv6LowByte:
+		for i := uint64(1); i <= 0xff; i++ {
+			if !yield(i) {
+				return false
+			}
+		}
+
+	case IPv6Ports:
+		// Both ::443 written with the decimal digits and ::1bb, its real value
+		for _, port := range ipv6ServicePorts {
+			if v, err := strconv.ParseUint(strconv.Itoa(port), 16, 16); err == nil && !yield(v) {
+				return false
+			}
+			if !yield(uint64(port)) {
+				return false
+			}
+		}
+
+	case IPv6Seeds:
+		// Neighbours of seeds in their own /64, then every seed's interface
+		// identifier reused in this prefix, as static IIDs repeat across VLANs
+		for _, seed := range g.Seeds {
+			if !prefix.Contains(seed) {
+				continue
+			}
+			iid := interfaceID(seed)
+			for d := uint64(1); d <= 16; d++ {
+				if !yield(iid+d) || (iid > d && !yield(iid-d)) {
+					return false
+				}
+			}
+		}
+		for _, seed := range g.Seeds {
+			if !yield(interfaceID(seed)) {
+				return false
+			}
+		}
+
+	case IPv6EUI64:
+		// Interleave vendors so a cap still covers all of them
+		if len(g.OUIs) == 0 {
+			return true
+		}
+		for nic := uint32(1); nic < 1<<24; nic++ {
+			for _, oui := range g.OUIs {
+				mac := [6]byte{oui[0], oui[1], oui[2], byte(nic >> 16), byte(nic >> 8), byte(nic)}
+				if !yield(eui64(mac)) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// eui64 derives the modified EUI-64 interface identifier of a MAC address
+func eui64(mac [6]byte) uint64 {
+	b := [8]byte{mac[0] ^ 0x02, mac[1], mac[2], 0xff, 0xfe, mac[3], mac[4], mac[5]}
+	return binary.BigEndian.Uint64(b[:])
+}
+
+func interfaceID(addr netip.Addr) uint64 {
+	b := addr.As16()
+	return binary.BigEndian.Uint64(b[8:])
+}
+
+func withIID(prefix netip.Prefix, iid uint64) netip.Addr {
+	b := prefix.Addr().As16()
+	binary.BigEndian.PutUint64(b[8:], iid)
+	return netip.AddrFrom16(b)
+}
+
+func containsPrefix(list []netip.Prefix, p netip.Prefix) bool {
+	for _, q := range list {
+		if q == p {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIPv6GeneratorSubnets(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		max    int
+		want   []string
+	}{
+		{
+			name:   "/64",
+			prefix: "2001:db8:0:5::/64",
+			want:   []string{"2001:db8:0:5::1", "2001:db8:0:5::2"},
+		},
+		{
+			name:   "every /64 of a /63",
+			prefix: "2001:db8:0:4::/63",
+			want:   []string{"2001:db8:0:4::1", "2001:db8:0:4::2", "2001:db8:0:5::1", "2001:db8:0:5::2"},
+		},
+		{
+			name:   "/48 bounded by max",
+			prefix: "2001:db8:1::/48",
+			max:    5,
+			want:   []string{"2001:db8:1::1", "2001:db8:1::2", "2001:db8:1:1::1", "2001:db8:1:1::2", "2001:db8:1:2::1"},
+		},
+		{
+			name:   "/0 bounded by max",
+			prefix: "::/0",
+			max:    3,
+			want:   []string{"::1", "::2", "::1:0:0:0:1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := newIPv6Generator(tt.prefix, IPv6LowByte, "", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			g.Max = tt.max
+			g.PerStrategy = 2
+
+			out := make(chan string)
+			done := make(chan int, 1)
+			go func() {
+				done <- g.Run(out)
+				close(out)
+			}()
+			var got []string
+			for addr := range out {
+				got = append(got, addr)
+			}
+			if n := <-done; n != len(got) {
+				t.Errorf("Run reported %d addresses, sent %d", n, len(got))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("generated %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
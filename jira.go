@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Labels placed on every issue the scanner manages. The fingerprint label is
+// what makes issue creation idempotent across runs.
+const (
+	jiraLabel       = "portscan"
+	jiraFingerprint = "portscan-fp-"
+	jiraHostLabel   = "portscan-host-"
+	jiraPortLabel   = "portscan-port-"
+	jiraProtoLabel  = "portscan-proto-"
+	jiraSourceLabel = "portscan-source-"
+)
+
+// jiraClient creates, comments on and transitions issues for scan findings
+type jiraClient struct {
+	BaseURL           string
+	Project           string
+	User              string
+	Token             string
+	IssueType         string
+	ResolveTransition string
+	ReopenTransition  string
+	HTTP              *http.Client
+}
+
+// jiraIssue is the subset of a Jira issue the scanner reads back
+type jiraIssue struct {
+	Key    string `json:"key"`
+	Fields struct {
+		Labels []string `json:"labels"`
+		Status struct {
+			StatusCategory struct {
+				Key string `json:"key"`
+			} `json:"statusCategory"`
+		} `json:"status"`
+	} `json:"fields"`
+}
+
+func (i jiraIssue) label(prefix string) string {
+	if labels := i.labels(prefix); len(labels) > 0 {
+		return labels[0]
+	}
+	return ""
+}
+
+// labels returns the values of all labels with a prefix; issues migrated
+// from legacy fingerprints carry two
+func (i jiraIssue) labels(prefix string) []string {
+	var values []string
+	for _, l := range i.Fields.Labels {
+		if strings.HasPrefix(l, prefix) {
+			values = append(values, strings.TrimPrefix(l, prefix))
+		}
+	}
+	return values
+}
+
+// proto is the transport of the issue's port; issues created before it was
+// labelled are all TCP
+func (i jiraIssue) proto() string {
+	if proto := i.label(jiraProtoLabel); proto != "" {
+		return proto
+	}
+	return "tcp"
+}
+
+func (i jiraIssue) resolved() bool {
+	return i.Fields.Status.StatusCategory.Key == "done"
+}
+
+func newJiraClient(baseURL, project, user, token string) *jiraClient {
+	return &jiraClient{
+		BaseURL:           strings.TrimRight(baseURL, "/"),
+		Project:           project,
+		User:              user,
+		Token:             token,
+		IssueType:         "Task",
+		ResolveTransition: "Done",
+		HTTP:              &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// forProject returns a copy of the client that files issues in another project
+func (c *jiraClient) forProject(project string) *jiraClient {
+	copied := *c
+	copied.Project = project
+	return &copied
+}
+
+// Sync reconciles the issues of one host with its latest scan. New findings
+// get an issue, recurring ones a comment, and open issues for scanned ports
+// that no longer show the finding are transitioned to resolved. Only issues
+// whose source, the scan itself or the probe that found them, ran this time
+// are resolved, so a run with fewer probes leaves the others alone. Issues
+// found by the legacy fingerprint of a TCP finding are relabelled with the
+// current one.
+func (c *jiraClient) Sync(summary ScanSummary, scannedPorts []int, sources map[string]bool) error {
+	existing, err := c.searchHost(summary.Target)
+	if err != nil {
+		return err
+	}
+
+	byFingerprint := make(map[string]jiraIssue, len(existing))
+	for _, issue := range existing {
+		for _, fp := range issue.labels(jiraFingerprint) {
+			byFingerprint[fp] = issue
+		}
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	seen := make(map[string]bool)
+	observed := make(map[string]bool)
+	for _, f := range collectFindings(summary) {
+		fp := f.Fingerprint()
+		if seen[fp] {
+			continue
+		}
+		seen[fp] = true
+
+		issue, ok := byFingerprint[fp]
+		if !ok && f.LegacyFingerprint() != "" {
+			issue, ok = byFingerprint[f.LegacyFingerprint()]
+		}
+		if ok {
+			observed[issue.Key] = true
+		}
+		// Accepted findings are neither reported nor resolved
+		if f.Finding.Accepted != nil {
+			continue
+		}
+
+		if !ok {
+			if err := c.createIssue(f, summary.Owner); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := c.addComment(issue.Key, "Finding observed again by scan at "+now); err != nil {
+			return err
+		}
+		if !slices.Contains(issue.labels(jiraFingerprint), fp) {
+			if err := c.addLabel(issue.Key, jiraFingerprint+fp); err != nil {
+				return err
+			}
+		}
+		// Issues created before sources were labelled get theirs now
+		if issue.label(jiraSourceLabel) == "" && f.Finding.Source != "" {
+			if err := c.addLabel(issue.Key, jiraSourceLabel+f.Finding.Source); err != nil {
+				return err
+			}
+		}
+		if issue.resolved() && c.ReopenTransition != "" {
+			if err := c.transition(issue.Key, c.ReopenTransition); err != nil {
+				return err
+			}
+		}
+	}
+
+	scanned := make(map[int]bool, len(scannedPorts))
+	for _, p := range scannedPorts {
+		scanned[p] = true
+	}
+	for _, issue := range existing {
+		if issue.resolved() || observed[issue.Key] || !sources[issue.label(jiraSourceLabel)] {
+			continue
+		}
+		// UDP probes always try their ports, so a UDP finding whose source
+		// ran was looked for; TCP findings need their port scanned
+		port, err := strconv.Atoi(issue.label(jiraPortLabel))
+		if err != nil || issue.proto() == "tcp" && !scanned[port] {
+			continue
+		}
+		if err := c.addComment(issue.Key, "Finding no longer observed by scan at "+now); err != nil {
+			return err
+		}
+		if err := c.transition(issue.Key, c.ResolveTransition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *jiraClient) searchHost(host string) ([]jiraIssue, error) {
+	jql := fmt.Sprintf("project = %s AND labels = %s", jqlQuote(c.Project), jqlQuote(jiraHostLabel+host))
+
+	var issues []jiraIssue
+	for {
+		q := url.Values{}
+		q.Set("jql", jql)
+		q.Set("fields", "labels,status")
+		q.Set("startAt", strconv.Itoa(len(issues)))
+		q.Set("maxResults", "100")
+
+		var page struct {
+			Total  int         `json:"total"`
+			Issues []jiraIssue `json:"issues"`
+		}
+		if err := c.do(http.MethodGet, "/rest/api/2/search?"+q.Encode(), nil, &page); err != nil {
+			return nil, err
+		}
+		issues = append(issues, page.Issues...)
+		if len(page.Issues) == 0 || len(issues) >= page.Total {
+			return issues, nil
+		}
+	}
+}
+
+func (c *jiraClient) createIssue(f hostFinding, owner *Owner) error {
+	summary := fmt.Sprintf("%s: %s on %d/%s", f.Host, f.Finding.Type, f.Port, f.Proto)
+	description := fmt.Sprintf("Host: %s\nPort: %d/%s\nFinding: %s\nFingerprint: %s\nFirst seen: %s",
+		f.Host, f.Port, f.Proto, f.Finding.Type, f.Fingerprint(), time.Now().Format(time.RFC3339))
+	if owner != nil {
+		description += "\nOwner: " + owner.String()
+	}
+	if f.Finding.Detail != "" {
+		description += "\n\n" + f.Finding.Detail
+	}
+	if f.Finding.Guidance != nil {
+		description += "\n\n" + f.Finding.Guidance.Text()
+	}
+
+	labels := []string{
+		jiraLabel,
+		jiraFingerprint + f.Fingerprint(),
+		jiraHostLabel + f.Host,
+		jiraPortLabel + strconv.Itoa(f.Port),
+		jiraProtoLabel + f.Proto,
+	}
+	if f.Finding.Source != "" {
+		labels = append(labels, jiraSourceLabel+f.Finding.Source)
+	}
+	body := map[string]any{
+		"fields": map[string]any{
+			"project":     map[string]string{"key": c.Project},
+			"issuetype":   map[string]string{"name": c.IssueType},
+			"summary":     summary,
+			"description": description,
+			"labels":      labels,
+		},
+	}
+	return c.do(http.MethodPost, "/rest/api/2/issue", body, nil)
+}
+
+func (c *jiraClient) addLabel(key, label string) error {
+	body := map[string]any{"update": map[string]any{"labels": []map[string]string{{"add": label}}}}
+	return c.do(http.MethodPut, "/rest/api/2/issue/"+key, body, nil)
+}
+
+func (c *jiraClient) addComment(key, text string) error {
+	return c.do(http.MethodPost, "/rest/api/2/issue/"+key+"/comment", map[string]string{"body": text}, nil)
+}
+
+// transition applies the named workflow transition, looking up its ID first
+// since IDs differ between Jira workflows
+func (c *jiraClient) transition(key, name string) error {
+	var available struct {
+		Transitions []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"transitions"`
+	}
+	path := "/rest/api/2/issue/" + key + "/transitions"
+	if err := c.do(http.MethodGet, path, nil, &available); err != nil {
+		return err
+	}
+	for _, t := range available.Transitions {
+		if strings.EqualFold(t.Name, name) {
+			body := map[string]any{"transition": map[string]string{"id": t.ID}}
+			return c.do(http.MethodPost, path, body, nil)
+		}
+	}
+	return fmt.Errorf("issue %s has no transition %q", key, name)
+}
+
+func (c *jiraClient) do(method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.BaseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.User != "" {
+		req.SetBasicAuth(c.User, c.Token)
+	} else if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("jira %s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func jqlQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeJira keeps issues in memory and answers the REST calls jiraClient makes
+type fakeJira struct {
+	mu       sync.Mutex
+	issues   []*fakeIssue
+	comments map[string][]string
+}
+
+type fakeIssue struct {
+	Key    string
+	Labels []string
+	Done   bool
+}
+
+func (j *fakeJira) add(labels []string, done bool) *fakeIssue {
+	issue := &fakeIssue{Key: fmt.Sprintf("PS-%d", len(j.issues)+1), Labels: labels, Done: done}
+	j.issues = append(j.issues, issue)
+	return issue
+}
+
+func (j *fakeJira) find(key string) *fakeIssue {
+	for _, issue := range j.issues {
+		if issue.Key == key {
+			return issue
+		}
+	}
+	return nil
+}
+
+func (j *fakeJira) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var body map[string]any
+	json.NewDecoder(r.Body).Decode(&body)
+	path := strings.TrimPrefix(r.URL.Path, "/rest/api/2/")
+	switch {
+	case r.Method == http.MethodGet && path == "search":
+		var out []map[string]any
+		for _, issue := range j.issues {
+			category := "new"
+			if issue.Done {
+				category = "done"
+			}
+			out = append(out, map[string]any{
+				"key": issue.Key,
+				"fields": map[string]any{
+					"labels": issue.Labels,
+					"status": map[string]any{"statusCategory": map[string]string{"key": category}},
+				},
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"total": len(out), "issues": out})
+	case r.Method == http.MethodPost && path == "issue":
+		var labels []string
+		for _, l := range body["fields"].(map[string]any)["labels"].([]any) {
+			labels = append(labels, l.(string))
+		}
+		issue := j.add(labels, false)
+		json.NewEncoder(w).Encode(map[string]string{"key": issue.Key})
+	case strings.HasSuffix(path, "/comment"):
+		key := strings.TrimSuffix(strings.TrimPrefix(path, "issue/"), "/comment")
+		j.comments[key] = append(j.comments[key], body["body"].(string))
+	case strings.HasSuffix(path, "/transitions"):
+		issue := j.find(strings.TrimSuffix(strings.TrimPrefix(path, "issue/"), "/transitions"))
+		if r.Method == http.MethodGet {
+			json.NewEncoder(w).Encode(map[string]any{"transitions": []map[string]string{
+				{"id": "31", "name": "Done"}, {"id": "11", "name": "Reopen"},
+			}})
+			return
+		}
+		issue.Done = body["transition"].(map[string]any)["id"] == "31"
+	case r.Method == http.MethodPut:
+		issue := j.find(strings.TrimPrefix(path, "issue/"))
+		for _, op := range body["update"].(map[string]any)["labels"].([]any) {
+			issue.Labels = append(issue.Labels, op.(map[string]any)["add"].(string))
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestJiraSync(t *testing.T) {
+	const host = "10.0.0.1"
+	open22 := ScanResult{Port: 22, Protocol: "tcp", State: "open"}
+	udp161 := ScanResult{Port: 161, Protocol: "udp", State: "open", Findings: []Finding{{Type: "snmp-public", Source: "snmp"}}}
+	legacyLabels := func(port int, findingType, source string) []string {
+		return []string{jiraLabel, jiraFingerprint + legacyFingerprint(host, port, findingType), jiraHostLabel + host,
+			jiraPortLabel + fmt.Sprint(port), jiraSourceLabel + source}
+	}
+	withFinding := ScanResult{Port: 443, Protocol: "tcp", State: "open", Findings: []Finding{{Type: "sslvpn-exposed", Source: "sslvpn"}}}
+	issueLabels := func(proto string, port int, findingType, source string) []string {
+		labels := []string{jiraLabel, jiraFingerprint + findingFingerprint(host, proto, port, findingType), jiraHostLabel + host,
+			jiraPortLabel + fmt.Sprint(port), jiraProtoLabel + proto}
+		if source != "" {
+			labels = append(labels, jiraSourceLabel+source)
+		}
+		return labels
+	}
+
+	tests := []struct {
+		name     string
+		existing [][]string
+		ports    []ScanResult
+		sources  map[string]bool
+		// wantDone lists the done state of every issue afterwards, in order
+		wantDone   []bool
+		wantIssues int
+		wantLabel  string
+	}{
+		{
+			name:       "new findings get issues",
+			ports:      []ScanResult{withFinding},
+			sources:    map[string]bool{SourceScan: true, "sslvpn": true},
+			wantIssues: 2,
+			wantDone:   []bool{false, false},
+			wantLabel:  jiraSourceLabel + "sslvpn",
+		},
+		{
+			name:       "recurring finding is not duplicated",
+			existing:   [][]string{issueLabels("tcp", 22, FindingOpenPort, SourceScan)},
+			ports:      []ScanResult{open22},
+			sources:    map[string]bool{SourceScan: true},
+			wantIssues: 1,
+			wantDone:   []bool{false},
+		},
+		{
+			name:       "gone finding is resolved when its source ran",
+			existing:   [][]string{issueLabels("tcp", 443, "sslvpn-exposed", "sslvpn")},
+			ports:      []ScanResult{{Port: 443, Protocol: "tcp", State: "open"}},
+			sources:    map[string]bool{SourceScan: true, "sslvpn": true},
+			wantIssues: 2,
+			wantDone:   []bool{true, false},
+		},
+		{
+			name:       "gone finding is kept when its probe did not run",
+			existing:   [][]string{issueLabels("tcp", 443, "sslvpn-exposed", "sslvpn")},
+			ports:      []ScanResult{{Port: 443, Protocol: "tcp", State: "open"}},
+			sources:    map[string]bool{SourceScan: true},
+			wantIssues: 2,
+			wantDone:   []bool{false, false},
+		},
+		{
+			name:       "issue without source label is kept",
+			existing:   [][]string{issueLabels("tcp", 443, "sslvpn-exposed", "")},
+			ports:      []ScanResult{{Port: 443, Protocol: "tcp", State: "open"}},
+			sources:    map[string]bool{SourceScan: true, "sslvpn": true},
+			wantIssues: 2,
+			wantDone:   []bool{false, false},
+		},
+		{
+			name:       "issue without source label gets one when observed",
+			existing:   [][]string{issueLabels("tcp", 22, FindingOpenPort, "")},
+			ports:      []ScanResult{open22},
+			sources:    map[string]bool{SourceScan: true},
+			wantIssues: 1,
+			wantDone:   []bool{false},
+			wantLabel:  jiraSourceLabel + SourceScan,
+		},
+		{
+			name:       "legacy fingerprint is found and relabelled",
+			existing:   [][]string{legacyLabels(22, FindingOpenPort, SourceScan)},
+			ports:      []ScanResult{open22},
+			sources:    map[string]bool{SourceScan: true},
+			wantIssues: 1,
+			wantDone:   []bool{false},
+			wantLabel:  jiraFingerprint + findingFingerprint(host, "tcp", 22, FindingOpenPort),
+		},
+		{
+			name:       "udp port does not match the tcp issue",
+			existing:   [][]string{legacyLabels(161, FindingOpenPort, SourceScan)},
+			ports:      []ScanResult{udp161},
+			sources:    map[string]bool{SourceScan: true, "snmp": true},
+			wantIssues: 3,
+			wantDone:   []bool{false, false, false},
+			wantLabel:  jiraProtoLabel + "udp",
+		},
+		{
+			name:       "gone udp finding is resolved when its probe ran",
+			existing:   [][]string{issueLabels("udp", 161, "snmp-public", "snmp")},
+			ports:      []ScanResult{open22},
+			sources:    map[string]bool{SourceScan: true, "snmp": true},
+			wantIssues: 2,
+			wantDone:   []bool{true, false},
+		},
+		{
+			name:       "unscanned port is kept",
+			existing:   [][]string{issueLabels("tcp", 8080, FindingOpenPort, SourceScan)},
+			ports:      []ScanResult{open22},
+			sources:    map[string]bool{SourceScan: true},
+			wantIssues: 2,
+			wantDone:   []bool{false, false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeJira{comments: map[string][]string{}}
+			for _, labels := range tt.existing {
+				fake.add(labels, false)
+			}
+			srv := httptest.NewServer(fake)
+			defer srv.Close()
+
+			client := newJiraClient(srv.URL, "PS", "", "token")
+			summary := ScanSummary{Target: host, Ports: tt.ports}
+			if err := client.Sync(summary, []int{22, 443}, tt.sources); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(fake.issues) != tt.wantIssues {
+				t.Fatalf("got %d issues, want %d", len(fake.issues), tt.wantIssues)
+			}
+			for i, want := range tt.wantDone {
+				if fake.issues[i].Done != want {
+					t.Errorf("issue %s done = %v, want %v", fake.issues[i].Key, fake.issues[i].Done, want)
+				}
+			}
+			if tt.wantLabel != "" {
+				found := false
+				for _, issue := range fake.issues {
+					for _, l := range issue.Labels {
+						found = found || l == tt.wantLabel
+					}
+				}
+				if !found {
+					t.Errorf("no issue has label %s", tt.wantLabel)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// LabelExposure says whether a target is reachable from the internet
+// ("external") or only from inside the network ("internal")
+const LabelExposure = "exposure"
+
+// parseLabels reads comma-separated key=value pairs
+func parseLabels(list string) (map[string]string, error) {
+	labels := map[string]string{}
+	if list == "" {
+		return labels, nil
+	}
+	for _, pair := range strings.Split(list, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid label %q, expected key=value", pair)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// hostLabels combines the labels given on the command line with ones derived
+// from the target itself. Exposure defaults to internal for private,
+// loopback and link-local addresses and external otherwise.
+func hostLabels(host string, given map[string]string) map[string]string {
+	labels := make(map[string]string, len(given)+1)
+	for k, v := range given {
+		labels[k] = v
+	}
+	if _, ok := labels[LabelExposure]; !ok {
+		labels[LabelExposure] = guessExposure(host)
+	}
+	return labels
+}
+
+func guessExposure(host string) string {
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		addrs, err := net.LookupIP(host)
+		if err != nil || len(addrs) == 0 {
+			return "external"
+		}
+		ips = addrs
+	}
+	for _, ip := range ips {
+		if !ip.IsPrivate() && !ip.IsLoopback() && !ip.IsLinkLocalUnicast() {
+			return "external"
+		}
+	}
+	return "internal"
+}
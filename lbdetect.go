@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// BackendEstimate records how many distinct servers appear to answer on one
+// port, with the number of distinct values seen for each signal compared
+type BackendEstimate struct {
+	Samples  int            `json:"samples"`
+	Backends int            `json:"backends"`
+	Evidence map[string]int `json:"evidence,omitempty"`
+}
+
+// FindingLoadBalanced marks ports answered by more than one backend
+const FindingLoadBalanced = "load-balanced"
+
+// backendSample is what one connection revealed about the server behind it
+type backendSample struct {
+	Banner  string
+	Server  string
+	Cert    string
+	HostKey string
+	Skew    time.Duration
+	HasDate bool
+}
+
+// Ways of talking to a port, picked once from the first connection
+const (
+	sampleBanner = "banner"
+	sampleSSH    = "ssh"
+	sampleHTTP   = "http"
+	sampleTLS    = "tls"
+)
+
+var errHostKeySeen = errors.New("host key recorded")
+
+// Timestamps in banners would make every sample look like a different backend
+var bannerVolatile = regexp.MustCompile(`\d{1,2}:\d{2}:\d{2}(\.\d+)?|\b\d{9,}\b`)
+
+// detectBackends samples every open port repeatedly and estimates how many
+// backends share it
+func detectBackends(summary *ScanSummary, workers, samples int, timeout time.Duration) {
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i := range summary.Ports {
+		wg.Add(1)
+		go func(res *ScanResult) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			est := estimateBackends(summary.Target, res.Port, samples, timeout)
+			if est == nil {
+				return
+			}
+			res.Backends = est
+			if est.Backends > 1 {
+				res.Findings = append(res.Findings, Finding{
+					Type:   FindingLoadBalanced,
+					Source: SourceLBDetect,
+					Detail: fmt.Sprintf("%d distinct backends across %d connections", est.Backends, est.Samples),
+				})
+			}
+		}(&summary.Ports[i])
+	}
+	wg.Wait()
+}
+
+func estimateBackends(host string, port, samples int, timeout time.Duration) *BackendEstimate {
+	kind := sampleKind(host, port, timeout)
+	if kind == "" {
+		return nil
+	}
+
+	var collected []backendSample
+	for i := 0; i < samples; i++ {
+		if s, ok := takeSample(kind, host, port, timeout); ok {
+			collected = append(collected, s)
+		}
+	}
+	if len(collected) < 2 {
+		return nil
+	}
+
+	distinct := map[string]map[string]bool{}
+	add := func(signal, value string) {
+		if value == "" {
+			return
+		}
+		if distinct[signal] == nil {
+			distinct[signal] = map[string]bool{}
+		}
+		distinct[signal][value] = true
+	}
+
+	var skews []time.Duration
+	for _, s := range collected {
+		add("banner", s.Banner)
+		add("server-header", s.Server)
+		add("tls-cert", s.Cert)
+		add("ssh-host-key", s.HostKey)
+		if s.HasDate {
+			skews = append(skews, s.Skew)
+		}
+	}
+
+	est := &BackendEstimate{Samples: len(collected), Backends: 1, Evidence: map[string]int{}}
+	for signal, values := range distinct {
+		est.Evidence[signal] = len(values)
+	}
+	if len(skews) > 1 {
+		est.Evidence["date-skew"] = skewClusters(skews)
+	}
+	for _, n := range est.Evidence {
+		if n > est.Backends {
+			est.Backends = n
+		}
+	}
+	return est
+}
+
+// skewClusters counts groups of clock offsets more than two seconds apart.
+// HTTP dates have one second resolution, so closer offsets are noise.
+func skewClusters(skews []time.Duration) int {
+	sort.Slice(skews, func(i, j int) bool { return skews[i] < skews[j] })
+	clusters := 1
+	for i := 1; i < len(skews); i++ {
+		if skews[i]-skews[i-1] > 2*time.Second {
+			clusters++
+		}
+	}
+	return clusters
+}
+
+// sampleKind decides how to sample a port: by its banner, SSH host key,
+// plain HTTP headers, or TLS certificate
+func sampleKind(host string, port int, timeout time.Duration) string {
+	conn, err := dialPort(host, port, timeout)
+	if err != nil {
+		return ""
+	}
+	banner := readBanner(conn, time.Second)
+	if banner != "" {
+		conn.Close()
+		if strings.HasPrefix(banner, "SSH-") {
+			return sampleSSH
+		}
+		return sampleBanner
+	}
+
+	_, _, ok := httpHead(conn, host, port, timeout)
+	conn.Close()
+	if ok {
+		return sampleHTTP
+	}
+
+	if s, ok := takeSample(sampleTLS, host, port, timeout); ok && s.Cert != "" {
+		return sampleTLS
+	}
+	return ""
+}
+
+func takeSample(kind, host string, port int, timeout time.Duration) (backendSample, bool) {
+	var s backendSample
+	conn, err := dialPort(host, port, timeout)
+	if err != nil {
+		return s, false
+	}
+	defer conn.Close()
+
+	switch kind {
+	case sampleBanner:
+		s.Banner = bannerVolatile.ReplaceAllString(readBanner(conn, 2*time.Second), "")
+		return s, s.Banner != ""
+
+	case sampleSSH:
+		config := &ssh.ClientConfig{
+			User: "probe",
+			HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+				s.HostKey = ssh.FingerprintSHA256(key)
+				return errHostKeySeen
+			},
+			Timeout: timeout,
+		}
+		conn.SetDeadline(time.Now().Add(timeout))
+		ssh.NewClientConn(conn, conn.RemoteAddr().String(), config)
+		return s, s.HostKey != ""
+
+	case sampleHTTP:
+		resp, received, ok := httpHead(conn, host, port, timeout)
+		if ok {
+			s.Server = resp.Header.Get("Server")
+			s.Skew, s.HasDate = dateSkew(resp, received)
+		}
+		return s, ok
+
+	case sampleTLS:
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: host, InsecureSkipVerify: true})
+		tlsConn.SetDeadline(time.Now().Add(timeout))
+		if err := tlsConn.Handshake(); err != nil {
+			return s, false
+		}
+		if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 {
+			sum := sha256.Sum256(certs[0].Raw)
+			s.Cert = hex.EncodeToString(sum[:])
+		}
+		if resp, received, ok := httpHead(transcripts.recordDecrypted(tlsConn, host, port), host, port, timeout); ok {
+			s.Server = resp.Header.Get("Server")
+			s.Skew, s.HasDate = dateSkew(resp, received)
+		}
+		return s, true
+	}
+	return s, false
+}
+
+func readBanner(conn net.Conn, wait time.Duration) string {
+	conn.SetReadDeadline(time.Now().Add(wait))
+	buf := make([]byte, 256)
+	n, _ := conn.Read(buf)
+	return strings.TrimSpace(string(buf[:n]))
+}
+
+// httpHead sends a HEAD request and returns the response with the time it arrived
+func httpHead(conn net.Conn, host string, port int, timeout time.Duration) (*http.Response, time.Time, bool) {
+	conn.SetDeadline(time.Now().Add(timeout))
+	authority := net.JoinHostPort(host, strconv.Itoa(port))
+	if _, err := fmt.Fprintf(conn, "HEAD / HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", authority); err != nil {
+		return nil, time.Time{}, false
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return nil, time.Time{}, false
+	}
+	resp.Body.Close()
+	return resp, time.Now(), true
+}
+
+func dateSkew(resp *http.Response, received time.Time) (time.Duration, bool) {
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, false
+	}
+	return date.Sub(received), true
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSkewClusters(t *testing.T) {
+	s := time.Second
+	tests := []struct {
+		name  string
+		skews []time.Duration
+		want  int
+	}{
+		{"one clock", []time.Duration{0, 0, 0}, 1},
+		{"date resolution noise", []time.Duration{-s, 0, s, 2 * s}, 1},
+		{"two clocks", []time.Duration{0, time.Hour, s, time.Hour - s}, 2},
+		{"chained within tolerance", []time.Duration{0, 2 * s, 4 * s, 6 * s}, 1},
+		{"three clocks unsorted", []time.Duration{10 * s, -10 * s, 0, 10 * s}, 3},
+		{"single sample", []time.Duration{5 * time.Minute}, 1},
+	}
+	for _, tt := range tests {
+		if got := skewClusters(tt.skews); got != tt.want {
+			t.Errorf("%s: skewClusters(%v) = %d, want %d", tt.name, tt.skews, got, tt.want)
+		}
+	}
+}
+
+func TestBannerVolatile(t *testing.T) {
+	tests := []struct {
+		banner string
+		want   string
+	}{
+		{"220 mail.example.com ESMTP Postfix", "220 mail.example.com ESMTP Postfix"},
+		{"220 ftp ready at 09:41:07", "220 ftp ready at "},
+		{"* OK Dovecot ready 12:00:00.123456", "* OK Dovecot ready "},
+		{"+OK POP3 <1234.1700000000@mail>", "+OK POP3 <1234.@mail>"},
+		// Version numbers and short counters are not timestamps
+		{"SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13.5", "SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13.5"},
+		{"200 Welcome, 12345678 users served", "200 Welcome, 12345678 users served"},
+	}
+	for _, tt := range tests {
+		if got := bannerVolatile.ReplaceAllString(tt.banner, ""); got != tt.want {
+			t.Errorf("normalised %q = %q, want %q", tt.banner, got, tt.want)
+		}
+	}
+}
+
+func TestHTTPHeadHost(t *testing.T) {
+	tests := []struct {
+		host string
+		port int
+		want string
+	}{
+		{"10.0.0.1", 80, "10.0.0.1:80"},
+		{"fd00::1", 8080, "[fd00::1]:8080"},
+		{"www.example.com", 443, "www.example.com:443"},
+	}
+	for _, tt := range tests {
+		client, server := net.Pipe()
+		got := make(chan string, 1)
+		go func() {
+			defer server.Close()
+			req, err := http.ReadRequest(bufio.NewReader(server))
+			if err != nil {
+				got <- err.Error()
+				return
+			}
+			got <- req.Host
+			fmt.Fprint(server, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+		}()
+		_, _, ok := httpHead(client, tt.host, tt.port, time.Second)
+		client.Close()
+		if host := <-got; !ok || host != tt.want {
+			t.Errorf("httpHead(%s, %d) sent Host %q (ok %v), want %q", tt.host, tt.port, host, ok, tt.want)
+		}
+	}
+}
+
+func TestEstimateBackends(t *testing.T) {
+	// Each connection gets the next backend in turn
+	banners := func(backends ...string) func(net.Conn) {
+		var n atomic.Int32
+		return func(c net.Conn) {
+			defer c.Close()
+			backend := backends[int(n.Add(1)-1)%len(backends)]
+			fmt.Fprintf(c, "220 %s ready %s\r\n", backend, time.Now().Format("15:04:05.000000"))
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	heads := func(servers []string, skews []time.Duration) func(net.Conn) {
+		var n atomic.Int32
+		return func(c net.Conn) {
+			defer c.Close()
+			if _, err := http.ReadRequest(bufio.NewReader(c)); err != nil {
+				return
+			}
+			i := int(n.Add(1) - 1)
+			date := time.Now().Add(skews[i%len(skews)]).UTC().Format(http.TimeFormat)
+			fmt.Fprintf(c, "HTTP/1.1 200 OK\r\nServer: %s\r\nDate: %s\r\nContent-Length: 0\r\n\r\n", servers[i%len(servers)], date)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		handle func(net.Conn)
+		want   *BackendEstimate
+	}{
+		{
+			name:   "one banner backend",
+			handle: banners("mail-a"),
+			want:   &BackendEstimate{Samples: 4, Backends: 1, Evidence: map[string]int{"banner": 1}},
+		},
+		{
+			name:   "two banner backends",
+			handle: banners("mail-a", "mail-b"),
+			want:   &BackendEstimate{Samples: 4, Backends: 2, Evidence: map[string]int{"banner": 2}},
+		},
+		{
+			name:   "one http backend",
+			handle: heads([]string{"nginx"}, []time.Duration{0}),
+			want:   &BackendEstimate{Samples: 4, Backends: 1, Evidence: map[string]int{"server-header": 1, "date-skew": 1}},
+		},
+		{
+			name:   "http backends told apart by clock",
+			handle: heads([]string{"nginx"}, []time.Duration{0, time.Hour}),
+			want:   &BackendEstimate{Samples: 4, Backends: 2, Evidence: map[string]int{"server-header": 1, "date-skew": 2}},
+		},
+		{
+			name:   "http backends told apart by server header",
+			handle: heads([]string{"nginx", "Apache", "IIS"}, []time.Duration{0}),
+			want:   &BackendEstimate{Samples: 4, Backends: 3, Evidence: map[string]int{"server-header": 3, "date-skew": 1}},
+		},
+		{
+			name:   "silent service",
+			handle: func(c net.Conn) { time.Sleep(2 * time.Second); c.Close() },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			port := listen(t, tt.handle)
+			got := estimateBackends("127.0.0.1", port, 4, 500*time.Millisecond)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("estimateBackends = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
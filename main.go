@@ -4,22 +4,73 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"net"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
 // ScanResult stores individual port scan results
 type ScanResult struct {
-	Port   int    `json:"port"`
-	State  string `json:"state"`
-	Banner string `json:"banner,omitempty"`
+	Port     int         `json:"port"`
+	Protocol string      `json:"protocol,omitempty"`
+	State    string      `json:"state"`
+	SubState string      `json:"sub_state,omitempty"`
+	Banner   string      `json:"banner,omitempty"`
+	Findings []Finding   `json:"findings,omitempty"`
+	Score    float64     `json:"score,omitempty"`
+	Severity string      `json:"severity,omitempty"`
+	Guidance *Guidance   `json:"guidance,omitempty"`
+	Accepted *Acceptance `json:"accepted,omitempty"`
+
+	// Fingerprint identifies the port's open-port finding for suppressions
+	Fingerprint string `json:"fingerprint,omitempty"`
+
+	Backends *BackendEstimate  `json:"backends,omitempty"`
+	HTTP2    *HTTP2Fingerprint `json:"http2,omitempty"`
+	Probes   []ProbeResult     `json:"probes,omitempty"`
+
+	// Transcript is the ID of the port's saved transcript (-save-transcripts)
+	Transcript string `json:"transcript,omitempty"`
+}
+
+// proto returns the transport of a result; results saved before UDP probes
+// existed have no protocol and are TCP
+func (r ScanResult) proto() string {
+	if r.Protocol == "" {
+		return "tcp"
+	}
+	return r.Protocol
+}
+
+// status is the state of a result with its sub-state, if any
+func (r ScanResult) status() string {
+	if r.SubState != "" {
+		return r.State + " (" + r.SubState + ")"
+	}
+	return r.State
+}
+
+// Finding is a notable observation about a port beyond it being open
+type Finding struct {
+	Type     string      `json:"type" xml:"type,attr"`
+	Detail   string      `json:"detail,omitempty" xml:"detail,omitempty"`
+	Source   string      `json:"source,omitempty" xml:"source,attr,omitempty"`
+	CVEs     []string    `json:"cves,omitempty" xml:"cve,omitempty"`
+	Score    float64     `json:"score,omitempty" xml:"score,attr,omitempty"`
+	Severity string      `json:"severity,omitempty" xml:"severity,attr,omitempty"`
+	Guidance *Guidance   `json:"guidance,omitempty" xml:"guidance,omitempty"`
+	Accepted *Acceptance `json:"accepted,omitempty" xml:"accepted,omitempty"`
+
+	Fingerprint string `json:"fingerprint,omitempty" xml:"fingerprint,attr,omitempty"`
 }
 
 // ScanSummary contains scan metadata and results
@@ -29,10 +80,47 @@ type ScanSummary struct {
 	ScannedPorts int           `json:"scanned_ports"`
 	TimeTaken    time.Duration `json:"time_taken_ms"`
 	Ports        []ScanResult  `json:"ports,omitempty"`
+
+	Device       *DeviceIdentity   `json:"device,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Owner        *Owner            `json:"owner,omitempty"`
+	RiskScore    float64           `json:"risk_score,omitempty"`
+	RiskSeverity string            `json:"risk_severity,omitempty"`
+
+	Identifiers []HostIdentifier `json:"identifiers,omitempty"`
+	Identity    *HostIdentity    `json:"identity,omitempty"`
+
+	Container *ContainerInfo `json:"container,omitempty"`
+	Drift     PortDrifts     `json:"drift,omitempty"`
+
+	Traffic *TrafficStats `json:"traffic,omitempty"`
 }
 
 func main() {
 
+	// Subcommands are dispatched before the scan flags are parsed. The enrich
+	// mode takes the scan flags too, followed by discovery output files.
+	enrichMode := false
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "report":
+			if err := runReport(os.Args[2:]); err != nil {
+				fmt.Println("Report failed:", err)
+				os.Exit(1)
+			}
+			return
+		case "transcript":
+			if err := runTranscript(os.Args[2:]); err != nil {
+				fmt.Println("Transcript failed:", err)
+				os.Exit(1)
+			}
+			return
+		case "enrich":
+			enrichMode = true
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+		}
+	}
+
 	// Custom Target Flag (-target)
 	target := flag.String("target", "scanme.nmap.org", "Target hostname or IP to scan")
 
@@ -47,7 +135,7 @@ func main() {
 	timeoutSec := flag.Int("timeout", 5, "Connection timeout in seconds")
 
 	// Banner Grabbing (-banner)
-	banner := flag.Bool("banner", false, "Attempt to grab service banners")
+	banner := flag.Bool("banner", false, "Attempt to grab service banners for 2s per open port, also detecting tcpwrapped services")
 
 	// Multiple Targets (-targets)
 	targets := flag.String("targets", "", "Comma-separated target list")
@@ -55,26 +143,523 @@ func main() {
 	// JSON Output (-json)
 	jsonOut := flag.Bool("json", false, "Output results in JSON format")
 
+	// Output Format (-format, -template)
+	format := flag.String("format", "text", "Output format: "+strings.Join(outputFormatNames(), ", "))
+	templateFile := flag.String("template", "", "Go template file used with -format template")
+
 	// Specific Ports (-ports)
 	portsList := flag.String("ports", "", "Comma-separated port list")
 
+	// Jira Issue Tracking (-jira-*)
+	jiraURL := flag.String("jira-url", "", "Jira base URL; enables issue creation for findings")
+	jiraProject := flag.String("jira-project", "", "Jira project key for new issues")
+	jiraUser := flag.String("jira-user", "", "Jira username (token is read from JIRA_TOKEN)")
+	jiraIssueType := flag.String("jira-issue-type", "Task", "Jira issue type for new issues")
+	jiraResolve := flag.String("jira-resolve-transition", "Done", "Jira transition applied when a finding is gone")
+	jiraReopen := flag.String("jira-reopen-transition", "", "Jira transition applied when a resolved finding recurs")
+
+	// Message Bus Sinks (-sink, -sink-*)
+	sinkURLs := flag.String("sink", "", "Comma-separated sink URLs (nats://host/subject, kafka://brokers/topic, mqtt://host/topic)")
+	sinkFormat := flag.String("sink-format", "json", "Sink message serialization: json or protobuf")
+	sinkBatch := flag.Int("sink-batch", 1, "Number of events published to a sink at once")
+	sinkDelivery := flag.String("sink-delivery", DeliveryAtMostOnce, "Sink delivery guarantee: at-most-once or at-least-once")
+
+	// Load Balancer Detection (-lb-detect, -lb-samples)
+	lbDetect := flag.Bool("lb-detect", false, "Estimate the number of backends behind each open port")
+	lbSamples := flag.Int("lb-samples", 8, "Connections made per open port for -lb-detect")
+
+	// HTTP/2 Fingerprinting (-http2)
+	http2FP := flag.Bool("http2", false, "Fingerprint the HTTP/2 stack of each open port from its SETTINGS, window updates, HPACK use and frame order")
+
+	// Docker Targets (-docker)
+	dockerSocket := flag.String("docker", "", "Scan the running containers of the Docker Engine API at this Unix socket (e.g. /var/run/docker.sock)")
+
+	// Terraform Targets (-tfstate, -tfstate-private)
+	tfStateFiles := flag.String("tfstate", "", "Comma-separated terraform.tfstate files to take instances and load balancers from, compared with their security groups")
+	tfPrivate := flag.Bool("tfstate-private", false, "Scan the private addresses of Terraform instances instead of their public ones")
+
+	// SNMP Discovery (-snmp-devices, -snmp-*)
+	snmpDevices := flag.String("snmp-devices", "", "Comma-separated routers and switches whose ARP/neighbor and interface address tables supply targets (community is read from SNMP_COMMUNITY)")
+	snmpVersion := flag.String("snmp-version", "2c", "SNMP version for -snmp-devices: 2c or 3")
+	snmpUser := flag.String("snmp-user", "", "SNMPv3 user name; authentication and privacy are used when SNMP_AUTH_PASS and SNMP_PRIV_PASS are set")
+	snmpAuth := flag.String("snmp-auth", "SHA", "SNMPv3 authentication protocol: MD5, SHA or SHA256")
+	snmpPriv := flag.String("snmp-priv", "AES", "SNMPv3 privacy protocol: DES or AES")
+
+	// Bandwidth Limit (-max-bandwidth)
+	maxBandwidth := flag.String("max-bandwidth", "", "Limit the bytes written and read by the scanner, in bits per second (e.g. 512k, 10M)")
+
+	// Probe Transcripts (-save-transcripts, -transcript-limit)
+	transcriptDir := flag.String("save-transcripts", "", "Save the bytes sent to and received from each open port, with timestamps, into this directory")
+	transcriptLimit := flag.Int("transcript-limit", 65536, "Maximum bytes recorded per port transcript")
+
+	// Host Correlation (-correlate)
+	correlate := flag.Bool("correlate", false, "Group addresses sharing SSH host keys, TLS certificates, SMB GUIDs, NetBIOS names or SNMP engine IDs into one host")
+
+	// Service Probes (-probes)
+	probeGroups := flag.String("probes", "", "Comma-separated probe groups to run, or \"all\" ("+strings.Join(probeGroupNames(), ", ")+")")
+
+	// Labels and Risk Scoring (-labels, -risk, -risk-config, -fail-severity)
+	labelList := flag.String("labels", "", "Comma-separated key=value labels for all targets (e.g. exposure=external)")
+	riskScoring := flag.Bool("risk", false, "Score findings and hosts by risk and sort reports by it")
+	riskConfig := flag.String("risk-config", "", "JSON file with risk weights (implies -risk)")
+	failSeverity := flag.String("fail-severity", "", "Exit with status 2 if any finding reaches this severity (implies -risk)")
+
+	// Remediation Guidance (-guidance)
+	guidanceFile := flag.String("guidance", "", "JSON file overriding or extending the built-in remediation guidance")
+
+	// Asset Ownership (-owners, -owner-reports)
+	ownersFile := flag.String("owners", "", "JSON file mapping CIDRs, hostname patterns and labels to owning teams")
+	ownerReports := flag.String("owner-reports", "", "Also write one report per owner into this directory")
+
+	// Finding Suppression (-suppressions)
+	suppressionsFile := flag.String("suppressions", "", "JSON file of accepted findings excluded from alerts and -fail-severity until they expire")
+
+	// IPv6 Target Generation (-ipv6-*)
+	ipv6Prefixes := flag.String("ipv6-prefix", "", "Comma-separated IPv6 prefixes (/64 or shorter) to generate likely host addresses in")
+	ipv6StrategyList := flag.String("ipv6-strategies", "all", "IPv6 generators to use: "+strings.Join(ipv6Strategies, ", ")+" or all")
+	ipv6SeedFile := flag.String("ipv6-seeds", "", "File of known IPv6 addresses to derive neighbours and prefixes from")
+	ipv6Vendors := flag.String("ipv6-vendors", "", "MAC vendors (e.g. vmware,qemu) or OUIs used for EUI-64 addresses, or all")
+	ipv6Max := flag.Int("ipv6-max", 4096, "Maximum number of generated IPv6 targets")
+	ipv6PerStrategy := flag.Int("ipv6-max-per-strategy", 256, "Maximum generated addresses per strategy and /64")
+
+	// SSH Jump Host (-ssh-jump, -ssh-*)
+	sshJumpDest := flag.String("ssh-jump", "", "Scan through an SSH bastion, user@host[:port]")
+	sshKey := flag.String("ssh-key", "", "Private key for -ssh-jump (default: SSH agent and ~/.ssh/id_*)")
+	sshKnownHosts := flag.String("ssh-known-hosts", filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts"), "known_hosts file used to verify the bastion")
+	sshInsecure := flag.Bool("ssh-insecure", false, "Do not verify the bastion's host key")
+	sshChannels := flag.Int("ssh-channels", 10, "Maximum concurrent channels through the bastion")
+
 	flag.Parse()
 
+	if *jsonOut {
+		*format = "json"
+	}
+	out, err := newOutputWriter(*format, *templateFile)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
 	// Validate port ranges
 	if *startPort < 1 || *endPort > 65535 || *startPort > *endPort {
 		fmt.Println("Invalid port range")
 		os.Exit(1)
 	}
 
+	timeout := time.Duration(*timeoutSec) * time.Second
+
+	selectedProbes, err := selectProbes(*probeGroups)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	labels, err := parseLabels(*labelList)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	var riskModel *RiskModel
+	if *riskScoring || *riskConfig != "" || *failSeverity != "" {
+		if *failSeverity != "" && severityRank(*failSeverity) < 0 {
+			fmt.Printf("Unknown severity %q\n", *failSeverity)
+			os.Exit(1)
+		}
+		if riskModel, err = loadRiskModel(*riskConfig); err != nil {
+			fmt.Println("Error loading risk config:", err)
+			os.Exit(1)
+		}
+		// Hosts can only be ranked once all of them are scanned
+		out.Streaming = false
+	}
+	if *correlate {
+		// Nor grouped
+		out.Streaming = false
+	}
+
+	knowledgeBase, err := loadKnowledgeBase(*guidanceFile)
+	if err != nil {
+		fmt.Println("Error loading guidance:", err)
+		os.Exit(1)
+	}
+
+	var owners *ownerMap
+	if *ownersFile != "" {
+		if owners, err = loadOwners(*ownersFile); err != nil {
+			fmt.Println("Error loading owners:", err)
+			os.Exit(1)
+		}
+		out.Owners = true
+	}
+
+	var suppressions *suppressionList
+	if *suppressionsFile != "" {
+		if suppressions, err = loadSuppressions(*suppressionsFile); err != nil {
+			fmt.Println("Error loading suppressions:", err)
+			os.Exit(1)
+		}
+	}
+
+	if *maxBandwidth != "" {
+		bps, err := parseBandwidth(*maxBandwidth)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		bandwidth = newBandwidthLimiter(bps)
+	}
+
+	if *transcriptDir != "" {
+		if transcripts, err = newTranscriptRecorder(*transcriptDir, *transcriptLimit); err != nil {
+			fmt.Println("Error creating transcript directory:", err)
+			os.Exit(1)
+		}
+	}
+
+	if *sshJumpDest != "" {
+		jumpHost, err = newSSHJump(*sshJumpDest, sshJumpOptions{
+			KeyFile:        *sshKey,
+			KnownHostsFile: *sshKnownHosts,
+			Insecure:       *sshInsecure,
+			Channels:       *sshChannels,
+			Timeout:        timeout,
+		})
+		if err != nil {
+			fmt.Println("Error connecting to SSH jump host:", err)
+			os.Exit(1)
+		}
+		defer jumpHost.Close()
+	}
+	if jumpHost != nil {
+		// Only TCP can be carried by direct-tcpip channels
+		var tcpProbes []probe
+		for _, p := range selectedProbes {
+			if p.Proto == "tcp" {
+				tcpProbes = append(tcpProbes, p)
+			}
+		}
+		if len(tcpProbes) < len(selectedProbes) {
+			fmt.Fprintln(os.Stderr, "UDP probes are skipped when scanning through -ssh-jump")
+		}
+		selectedProbes = tcpProbes
+	}
+
 	// Process targets
 	scanTargets := parseTargets(*target, *targets)
 
 	// Process ports
 	portsToScan := parsePorts(*portsList, *startPort, *endPort)
 
-	for _, host := range scanTargets {
-		results := scanHost(host, portsToScan, *workers, time.Duration(*timeoutSec)*time.Second, *banner)
-		generateOutput(results, *jsonOut)
+	// Generated and discovered targets replace the default target unless
+	// one was given
+	explicitTargets := *targets != ""
+	flag.Visit(func(f *flag.Flag) { explicitTargets = explicitTargets || f.Name == "target" })
+
+	var ipv6Gen *ipv6Generator
+	if *ipv6Prefixes != "" || *ipv6SeedFile != "" {
+		if ipv6Gen, err = newIPv6Generator(*ipv6Prefixes, *ipv6StrategyList, *ipv6SeedFile, *ipv6Vendors); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		ipv6Gen.Max = *ipv6Max
+		ipv6Gen.PerStrategy = *ipv6PerStrategy
+		if !explicitTargets {
+			scanTargets = nil
+		}
+	}
+
+	// Container and Terraform addresses are scanned on their declared ports
+	// too, and the open ports compared with them
+	var containers map[string]*dockerTarget
+	if *dockerSocket != "" {
+		found, order, err := dockerTargets(*dockerSocket, 10*time.Second)
+		if err != nil {
+			fmt.Println("Error listing Docker containers:", err)
+			os.Exit(1)
+		}
+		containers = found
+		if !explicitTargets {
+			scanTargets = nil
+		}
+		scanTargets = append(scanTargets, order...)
+	}
+	var tfTargets map[string]*tfTarget
+	if *tfStateFiles != "" {
+		found, order, err := loadTerraformTargets(strings.Split(*tfStateFiles, ","), *tfPrivate)
+		if err != nil {
+			fmt.Println("Error reading Terraform state:", err)
+			os.Exit(1)
+		}
+		tfTargets = found
+		if !explicitTargets && containers == nil {
+			scanTargets = nil
+		}
+		scanTargets = append(scanTargets, order...)
+	}
+
+	// Hosts learned from network devices are scanned like given targets
+	snmpNeighbors := map[string]snmpNeighbor{}
+	if *snmpDevices != "" {
+		// Guessing "public" would hide a forgotten community behind an
+		// empty neighbor list
+		community := os.Getenv("SNMP_COMMUNITY")
+		if *snmpVersion == "2c" && community == "" {
+			fmt.Println("Error: -snmp-devices with -snmp-version 2c needs the community in SNMP_COMMUNITY")
+			os.Exit(1)
+		}
+		creds := snmpCredentials{
+			Version:   *snmpVersion,
+			Community: community,
+			User:      *snmpUser,
+			AuthProto: *snmpAuth,
+			AuthPass:  os.Getenv("SNMP_AUTH_PASS"),
+			PrivProto: *snmpPriv,
+			PrivPass:  os.Getenv("SNMP_PRIV_PASS"),
+		}
+		// The SNMPv3 security level follows the passphrases given
+		if creds.AuthPass == "" {
+			creds.AuthProto = ""
+		}
+		if creds.PrivPass == "" {
+			creds.PrivProto = ""
+		}
+		if !explicitTargets && containers == nil && tfTargets == nil {
+			scanTargets = nil
+		}
+		for _, n := range snmpDiscoverHosts(strings.Split(*snmpDevices, ","), creds, timeout) {
+			snmpNeighbors[n.Addr] = n
+			scanTargets = append(scanTargets, n.Addr)
+		}
+	}
+
+	// In enrich mode targets and their open ports come from discovery output
+	discovered := map[string]discoveredHost{}
+	if enrichMode {
+		files := flag.Args()
+		if len(files) == 0 {
+			files = []string{"-"}
+		}
+		var defaultPorts []int
+		if *portsList != "" {
+			defaultPorts = portsToScan
+		}
+		hosts, err := readDiscoveryFiles(files, defaultPorts)
+		if err != nil {
+			fmt.Println("Error reading discovery output:", err)
+			os.Exit(1)
+		}
+		scanTargets = nil
+		for _, h := range hosts {
+			scanTargets = append(scanTargets, h.Host)
+			discovered[h.Host] = h
+		}
+	}
+
+	var jira *jiraClient
+	if *jiraURL != "" {
+		if *jiraProject == "" {
+			fmt.Println("-jira-project is required with -jira-url")
+			os.Exit(1)
+		}
+		jira = newJiraClient(*jiraURL, *jiraProject, *jiraUser, os.Getenv("JIRA_TOKEN"))
+		jira.IssueType = *jiraIssueType
+		jira.ResolveTransition = *jiraResolve
+		jira.ReopenTransition = *jiraReopen
+	}
+	// findingSources lists what could produce findings on a host in this run,
+	// so that Jira only resolves issues whose source ran
+	findingSources := func(host string) map[string]bool {
+		sources := map[string]bool{
+			SourceScan:     true,
+			SourceLBDetect: *lbDetect,
+			SourceDrift:    containers[host] != nil || (tfTargets[host] != nil && tfTargets[host].HasGroups),
+		}
+		for _, p := range selectedProbes {
+			sources[p.Name] = true
+		}
+		return sources
+	}
+
+	busOpts := sinkOptions{
+		Format:    *sinkFormat,
+		BatchSize: *sinkBatch,
+		Delivery:  *sinkDelivery,
+		Timeout:   10 * time.Second,
+	}
+	var bus *eventBus
+	if *sinkURLs != "" {
+		bus, err = newEventBus(*sinkURLs, busOpts)
+		if err != nil {
+			fmt.Println("Error opening sinks:", err)
+			os.Exit(1)
+		}
+		bus.Emit(ScanEvent{Type: EventScanStarted})
+	}
+
+	// Owners with their own sinks get a bus opened on first use
+	ownerBuses := map[*ownerRule]*eventBus{}
+
+	// Targets are fed one at a time so generated ones are only produced as
+	// fast as they are scanned
+	targetStream := make(chan string)
+	go func() {
+		defer close(targetStream)
+		for _, host := range scanTargets {
+			targetStream <- host
+		}
+		if ipv6Gen != nil {
+			ipv6Gen.Run(targetStream)
+		}
+	}()
+
+	var scanTraffic trafficMark
+	if bandwidth != nil {
+		scanTraffic = bandwidth.mark()
+	}
+	var summaries []ScanSummary
+	for host := range targetStream {
+		if bus != nil {
+			bus.Emit(ScanEvent{Type: EventHostStarted, Target: host})
+		}
+
+		var traffic trafficMark
+		if bandwidth != nil {
+			traffic = bandwidth.mark()
+		}
+		transcripts.setStage("scan")
+		var results ScanSummary
+		hostPorts := portsToScan
+		if enrichMode {
+			results = enrichHost(discovered[host], *workers, timeout)
+			hostPorts = nil
+			for _, res := range results.Ports {
+				hostPorts = append(hostPorts, res.Port)
+			}
+		} else {
+			if c := containers[host]; c != nil {
+				hostPorts = mergePorts(portsToScan, declaredTCPPorts(c.Declared))
+			}
+			if t := tfTargets[host]; t != nil {
+				hostPorts = mergePorts(hostPorts, declaredTCPPorts(t.Declared))
+			}
+			results = scanHost(host, hostPorts, *workers, timeout, *banner)
+		}
+		if *lbDetect {
+			transcripts.setStage("lb-detect")
+			detectBackends(&results, *workers, *lbSamples, timeout)
+		}
+		if *http2FP {
+			transcripts.setStage("http2")
+			fingerprintHTTP2(&results, *workers, timeout)
+		}
+		if len(selectedProbes) > 0 {
+			transcripts.setStage("probes")
+			runProbes(&results, selectedProbes, *workers, timeout)
+		}
+		if *correlate {
+			transcripts.setStage("correlate")
+			collectIdentifiers(&results, *workers, timeout)
+		}
+		if c := containers[host]; c != nil {
+			info := c.Info
+			results.Container = &info
+			compareDeclared(&results, "Docker ExposedPorts", c.Declared)
+		}
+		if t := tfTargets[host]; t != nil && t.HasGroups {
+			compareDeclared(&results, "security groups of "+t.Resource, t.Declared)
+		}
+		if n, ok := snmpNeighbors[host]; ok && n.MAC != "" {
+			if results.Device == nil {
+				results.Device = &DeviceIdentity{}
+			}
+			results.Device.merge(&DeviceIdentity{MAC: n.MAC}, "snmp "+n.Device)
+		}
+		if bandwidth != nil {
+			results.Traffic = bandwidth.since(traffic)
+		}
+		transcripts.save(&results)
+		results.Labels = hostLabels(host, labels)
+		var rule *ownerRule
+		if owners != nil {
+			if rule = owners.match(results); rule != nil {
+				owner := rule.Owner
+				results.Owner = &owner
+			}
+		}
+		if riskModel != nil {
+			riskModel.Score(&results)
+		}
+		knowledgeBase.Annotate(&results)
+		fingerprintFindings(&results)
+		if suppressions != nil {
+			warnExpired(suppressions.Apply(&results, time.Now()))
+		}
+		summaries = append(summaries, results)
+		if out.Streaming {
+			writeOutput(out, []ScanSummary{results})
+		}
+
+		if bus != nil {
+			emitHost(bus, &results)
+		}
+		if rule != nil && len(rule.Sinks) > 0 {
+			ownerBus, ok := ownerBuses[rule]
+			if !ok {
+				if ownerBus, err = newEventBus(strings.Join(rule.Sinks, ","), busOpts); err != nil {
+					fmt.Fprintf(os.Stderr, "Error opening sinks of owner %s: %v\n", rule.Team, err)
+				} else {
+					ownerBus.Emit(ScanEvent{Type: EventScanStarted})
+				}
+				ownerBuses[rule] = ownerBus
+			}
+			if ownerBus != nil {
+				emitHost(ownerBus, &results)
+			}
+		}
+
+		if jira != nil {
+			client := jira
+			if rule != nil && rule.JiraProject != "" {
+				client = jira.forProject(rule.JiraProject)
+			}
+			if err := client.Sync(results, hostPorts, findingSources(host)); err != nil {
+				fmt.Fprintln(os.Stderr, "Jira sync failed:", err)
+			}
+		}
+	}
+
+	if bandwidth != nil {
+		fmt.Fprintf(os.Stderr, "\nTraffic: %s\n", bandwidth.since(scanTraffic))
+	}
+	if *correlate {
+		correlateHosts(summaries)
+	}
+	if riskModel != nil {
+		sortByRisk(summaries)
+	}
+	if !out.Streaming {
+		writeOutput(out, summaries)
+	}
+	if *ownerReports != "" {
+		if err := writeOwnerReports(out, summaries, *ownerReports); err != nil {
+			fmt.Println("Error writing owner reports:", err)
+		}
+	}
+
+	if bus != nil {
+		bus.Emit(ScanEvent{Type: EventScanCompleted})
+		bus.Close()
+	}
+	for _, ownerBus := range ownerBuses {
+		if ownerBus != nil {
+			ownerBus.Emit(ScanEvent{Type: EventScanCompleted})
+			ownerBus.Close()
+		}
+	}
+
+	if *failSeverity != "" && exceedsSeverity(summaries, *failSeverity) {
+		os.Exit(2)
 	}
 }
 
@@ -112,7 +697,7 @@ func scanHost(host string, ports []int, workers int, timeout time.Duration, grab
 	// Progress monitor
 	go func() {
 		for range progress {
-			fmt.Printf("\rScanning: %d/%d ports", len(results), len(ports))
+			fmt.Fprintf(os.Stderr, "\rScanning: %d/%d ports", len(results), len(ports))
 		}
 	}()
 
@@ -139,35 +724,63 @@ func scanHost(host string, ports []int, workers int, timeout time.Duration, grab
 	}
 }
 
+// SubStateTCPWrapped marks an open port whose service closes or resets the
+// connection right after the handshake, as TCP wrappers, connection limits
+// and address allowlists do
+const SubStateTCPWrapped = "tcpwrapped"
+
 func scanPort(host string, port int, timeout time.Duration, grabBanner bool) ScanResult {
-	addr := fmt.Sprintf("%s:%d", host, port)
-	conn, err := net.DialTimeout("tcp", addr, timeout)
+	conn, err := dialPort(host, port, timeout)
 
 	result := ScanResult{
-		Port:  port,
-		State: "closed",
+		Port:     port,
+		Protocol: "tcp",
+		State:    "closed",
 	}
 
+	if errors.Is(err, errJumpBusy) {
+		// The port was never tried, which is not the same as closed
+		fmt.Fprintf(os.Stderr, "\nPort %d of %s not scanned: %v\n", port, host, err)
+	}
 	if err != nil {
 		return result
 	}
 	defer conn.Close()
 
 	result.State = "open"
+	if !grabBanner {
+		return result
+	}
 
-	if grabBanner {
-		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
-		buf := make([]byte, 256)
-		n, _ := conn.Read(buf)
-		if n > 0 {
-			result.Banner = strings.TrimSpace(string(buf[:n]))
-		}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 256)
+	n, err := conn.Read(buf)
+	if n > 0 {
+		result.Banner = strings.TrimSpace(string(buf[:n]))
+	}
+	// A service that closes or resets the connection before sending anything
+	if n == 0 && (errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET)) {
+		result.SubState = SubStateTCPWrapped
 	}
 
 	return result
 }
 
 /* Helper Functions */
+func dialPort(host string, port int, timeout time.Duration) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	if jumpHost != nil {
+		conn, err = jumpHost.Dial(host, port, timeout)
+	} else {
+		conn, err = net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return limitConn(transcripts.record(conn, host, port)), nil
+}
+
 func parseTargets(defaultTarget, targetList string) []string {
 	if targetList == "" {
 		return []string{defaultTarget}
@@ -193,31 +806,3 @@ func parsePorts(portList string, start, end int) []int {
 	}
 	return ports
 }
-
-func generateOutput(summary ScanSummary, jsonFormat bool) {
-	if jsonFormat {
-		data, err := json.MarshalIndent(summary, "", "  ")
-		if err != nil {
-			fmt.Println("Error generating JSON:", err)
-			return
-		}
-		fmt.Println(string(data))
-		return
-	}
-
-	fmt.Printf("\n\n=== Scan Results for %s ===\n", summary.Target)
-	fmt.Printf("Scanned ports: %d\n", summary.ScannedPorts)
-	fmt.Printf("Open ports: %d\n", summary.OpenPorts)
-	fmt.Printf("Scan duration: %v\n\n", summary.TimeTaken.Round(time.Millisecond))
-
-	if len(summary.Ports) > 0 {
-		fmt.Println("OPEN PORTS:")
-		for _, port := range summary.Ports {
-			output := fmt.Sprintf("%d/tcp %s", port.Port, port.State)
-			if port.Banner != "" {
-				output += fmt.Sprintf(" | %s", port.Banner)
-			}
-			fmt.Println(output)
-		}
-	}
-}
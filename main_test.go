@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// listen serves every connection to a local port with handle
+func listen(t *testing.T, handle func(net.Conn)) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestScanPort(t *testing.T) {
+	banner := listen(t, func(c net.Conn) {
+		c.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+		time.Sleep(time.Second)
+		c.Close()
+	})
+	silent := listen(t, func(c net.Conn) {
+		time.Sleep(time.Second)
+		c.Close()
+	})
+	wrapped := listen(t, func(c net.Conn) { c.Close() })
+	reset := listen(t, func(c net.Conn) {
+		c.(*net.TCPConn).SetLinger(0)
+		c.Close()
+	})
+
+	// A port that was open a moment ago and is closed now
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	tests := []struct {
+		name         string
+		port         int
+		grabBanner   bool
+		wantState    string
+		wantSubState string
+		wantBanner   string
+	}{
+		{"banner", banner, true, "open", "", "SSH-2.0-OpenSSH_9.6"},
+		{"banner not wanted", banner, false, "open", "", ""},
+		{"silent service", silent, false, "open", "", ""},
+		{"closed after accept", wrapped, true, "open", SubStateTCPWrapped, ""},
+		{"reset after accept", reset, true, "open", SubStateTCPWrapped, ""},
+		// Without -banner nothing is read, so open ports cost no wait
+		{"closed after accept, banner not wanted", wrapped, false, "open", "", ""},
+		{"closed", closed, false, "closed", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			res := scanPort("127.0.0.1", tt.port, time.Second, tt.grabBanner)
+			if res.State != tt.wantState || res.SubState != tt.wantSubState || res.Banner != tt.wantBanner {
+				t.Errorf("scanPort = %s (%s) %q, want %s (%s) %q",
+					res.State, res.SubState, res.Banner, tt.wantState, tt.wantSubState, tt.wantBanner)
+			}
+			if elapsed := time.Since(start); !tt.grabBanner && elapsed > 100*time.Millisecond {
+				t.Errorf("scanPort without -banner took %v", elapsed)
+			}
+		})
+	}
+}
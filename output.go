@@ -0,0 +1,533 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// outputWriter renders scan summaries in one format. Streaming writers can be
+// called once per host as scans finish; the others need every summary at once.
+// Ext is the file extension used when reports are written to files. Owners is
+// set once owner rules are loaded, so hosts no rule matched are reported as
+// unowned rather than left blank.
+type outputWriter struct {
+	Format    string
+	Ext       string
+	Streaming bool
+	Owners    bool
+	render    func(w io.Writer, summaries []ScanSummary, owners bool) error
+}
+
+var outputFormats = map[string]outputWriter{
+	"text":     {Format: "text", Ext: "txt", Streaming: true, render: ownerless(writeText)},
+	"json":     {Format: "json", Ext: "json", Streaming: true, render: ownerless(writeJSON)},
+	"csv":      {Format: "csv", Ext: "csv", render: writeCSV},
+	"xml":      {Format: "xml", Ext: "xml", render: ownerless(writeXML)},
+	"html":     {Format: "html", Ext: "html", render: ownerless(writeHTML)},
+	"markdown": {Format: "markdown", Ext: "md", render: ownerless(writeMarkdown)},
+	"template": {Format: "template", Ext: "txt"},
+}
+
+// ownerless adapts a writer that only shows owners hosts actually have
+func ownerless(write func(w io.Writer, summaries []ScanSummary) error) func(io.Writer, []ScanSummary, bool) error {
+	return func(w io.Writer, summaries []ScanSummary, _ bool) error {
+		return write(w, summaries)
+	}
+}
+
+// Write renders summaries to w
+func (o outputWriter) Write(w io.Writer, summaries []ScanSummary) error {
+	return o.render(w, summaries, o.Owners)
+}
+
+func outputFormatNames() []string {
+	names := make([]string, 0, len(outputFormats))
+	for name := range outputFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newOutputWriter(format, templateFile string) (outputWriter, error) {
+	out, ok := outputFormats[format]
+	if !ok {
+		return out, fmt.Errorf("unknown output format %q", format)
+	}
+	if format != "template" {
+		return out, nil
+	}
+
+	if templateFile == "" {
+		return out, fmt.Errorf("-format template requires -template")
+	}
+	tmpl, err := template.New("").Funcs(templateFuncs).ParseFiles(templateFile)
+	if err != nil {
+		return out, err
+	}
+	tmpl = tmpl.Lookup(templateName(templateFile))
+	out.render = ownerless(func(w io.Writer, summaries []ScanSummary) error {
+		return tmpl.Execute(w, summaries)
+	})
+	return out, nil
+}
+
+func templateName(path string) string {
+	if i := strings.LastIndexAny(path, `/\`); i >= 0 {
+		return path[i+1:]
+	}
+	return path
+}
+
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+	"ms":   func(d time.Duration) int64 { return d.Milliseconds() },
+}
+
+func writeOutput(out outputWriter, summaries []ScanSummary) {
+	if err := out.Write(os.Stdout, summaries); err != nil {
+		fmt.Printf("Error generating %s output: %v\n", out.Format, err)
+	}
+}
+
+/* Output Formats */
+func writeText(w io.Writer, summaries []ScanSummary) error {
+	for _, summary := range summaries {
+		fmt.Fprintf(w, "\n\n=== Scan Results for %s ===\n", summary.Target)
+		fmt.Fprintf(w, "Scanned ports: %d\n", summary.ScannedPorts)
+		fmt.Fprintf(w, "Open ports: %d\n", summary.OpenPorts)
+		fmt.Fprintf(w, "Scan duration: %v\n", summary.TimeTaken.Round(time.Millisecond))
+		if summary.Owner != nil {
+			fmt.Fprintf(w, "Owner: %s\n", summary.Owner)
+		}
+		if summary.Device != nil {
+			fmt.Fprintf(w, "Device: %s\n", summary.Device)
+		}
+		if summary.Identity != nil {
+			fmt.Fprintf(w, "Same host: %s\n", summary.Identity)
+		}
+		if summary.Container != nil {
+			fmt.Fprintf(w, "Container: %s\n", summary.Container)
+		}
+		for _, drift := range summary.Drift {
+			fmt.Fprintf(w, "Declared ports: %s\n", drift)
+		}
+		if summary.Traffic != nil {
+			fmt.Fprintf(w, "Traffic: %s\n", summary.Traffic)
+		}
+		if summary.RiskSeverity != "" {
+			fmt.Fprintf(w, "Risk score: %.1f (%s)\n", summary.RiskScore, summary.RiskSeverity)
+		}
+		fmt.Fprintln(w)
+
+		if len(summary.Ports) > 0 {
+			fmt.Fprintln(w, "OPEN PORTS:")
+			for _, port := range summary.Ports {
+				output := fmt.Sprintf("%d/%s %s", port.Port, port.proto(), port.status())
+				if port.Severity != "" {
+					output += fmt.Sprintf(" [%s %.1f]", port.Severity, port.Score)
+				}
+				if port.Accepted != nil {
+					output += " (accepted)"
+				}
+				if port.Banner != "" {
+					output += fmt.Sprintf(" | %s", port.Banner)
+				}
+				fmt.Fprintln(w, output)
+				if port.Backends != nil && port.Backends.Backends > 1 {
+					fmt.Fprintf(w, "    backends: %d (%s)\n", port.Backends.Backends, formatEvidence(port.Backends.Evidence))
+				}
+				if port.HTTP2 != nil {
+					fmt.Fprintf(w, "    http2: %s\n", port.HTTP2)
+				}
+				for _, p := range port.Probes {
+					fmt.Fprintf(w, "    %s\n", describeProbe(p))
+				}
+				if port.Transcript != "" {
+					fmt.Fprintf(w, "    transcript: %s\n", port.Transcript)
+				}
+				if port.Fingerprint != "" {
+					fmt.Fprintf(w, "    fingerprint: %s\n", port.Fingerprint)
+				}
+				for _, f := range port.Findings {
+					if f.Accepted != nil {
+						continue
+					}
+					severity := ""
+					if f.Severity != "" {
+						severity = fmt.Sprintf(" [%s %.1f]", f.Severity, f.Score)
+					}
+					cves := ""
+					if len(f.CVEs) > 0 {
+						cves = " (" + strings.Join(f.CVEs, ", ") + ")"
+					}
+					fmt.Fprintf(w, "    ! %s%s: %s%s\n", f.Type, severity, f.Detail, cves)
+					if f.Fingerprint != "" {
+						fmt.Fprintf(w, "      fingerprint: %s\n", f.Fingerprint)
+					}
+				}
+			}
+		}
+
+		if accepted := acceptedFindings(summary); len(accepted) > 0 {
+			fmt.Fprintln(w, "\nSUPPRESSED (risk accepted):")
+			for _, f := range accepted {
+				a := f.Finding.Accepted
+				fmt.Fprintf(w, "%d/%s %s: %s (approved by %s, expires %s)\n", f.Port, f.Proto, f.Finding.Type, a.Justification, a.Approver, a.Expires)
+			}
+		}
+
+		if guidance := hostGuidance(summary); len(guidance) > 0 {
+			fmt.Fprintln(w, "\nREMEDIATION:")
+			for _, g := range guidance {
+				fmt.Fprintf(w, "* %s\n\n", strings.ReplaceAll(g.Text(), "\n", "\n  "))
+			}
+		}
+	}
+	return nil
+}
+
+func writeJSON(w io.Writer, summaries []ScanSummary) error {
+	for _, summary := range summaries {
+		data, err := json.MarshalIndent(summary, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(data))
+	}
+	return nil
+}
+
+func writeCSV(w io.Writer, summaries []ScanSummary, owners bool) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"target", "owner", "port", "protocol", "state", "sub_state", "severity", "score", "banner", "findings", "accepted", "guidance", "fingerprint", "finding_fingerprints"})
+	for _, summary := range summaries {
+		for _, port := range summary.Ports {
+			cw.Write([]string{
+				summary.Target,
+				ownerTeam(summary, owners),
+				strconv.Itoa(port.Port),
+				port.proto(),
+				port.State,
+				port.SubState,
+				port.Severity,
+				formatScore(port),
+				port.Banner,
+				strings.Join(findingTypes(port.Findings), ";"),
+				strings.Join(acceptedTypes(port), ";"),
+				guidanceText(port),
+				port.Fingerprint,
+				strings.Join(findingFingerprints(port.Findings), ";"),
+			})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// xmlReport is the document layout of -format xml
+type xmlReport struct {
+	XMLName xml.Name  `xml:"scanreport"`
+	Hosts   []xmlHost `xml:"host"`
+}
+
+type xmlHost struct {
+	Target       string         `xml:"target,attr"`
+	Owner        string         `xml:"owner,attr,omitempty"`
+	OpenPorts    int            `xml:"open_ports,attr"`
+	ScannedPorts int            `xml:"scanned_ports,attr"`
+	TimeTakenMS  int64          `xml:"time_taken_ms,attr"`
+	RiskScore    float64        `xml:"risk_score,attr,omitempty"`
+	RiskSeverity string         `xml:"risk_severity,attr,omitempty"`
+	Identity     *HostIdentity  `xml:"identity,omitempty"`
+	Container    *ContainerInfo `xml:"container,omitempty"`
+	Drift        []*PortDrift   `xml:"drift"`
+	Traffic      *TrafficStats  `xml:"traffic,omitempty"`
+	Ports        []xmlPort      `xml:"port"`
+}
+
+type xmlPort struct {
+	Port        int         `xml:"number,attr"`
+	Protocol    string      `xml:"protocol,attr"`
+	State       string      `xml:"state,attr"`
+	SubState    string      `xml:"sub_state,attr,omitempty"`
+	Severity    string      `xml:"severity,attr,omitempty"`
+	Score       float64     `xml:"score,attr,omitempty"`
+	Transcript  string      `xml:"transcript,attr,omitempty"`
+	Fingerprint string      `xml:"fingerprint,attr,omitempty"`
+	Banner      string      `xml:"banner,omitempty"`
+	Guidance    *Guidance   `xml:"guidance,omitempty"`
+	Accepted    *Acceptance `xml:"accepted,omitempty"`
+	Findings    []Finding   `xml:"finding"`
+}
+
+func writeXML(w io.Writer, summaries []ScanSummary) error {
+	var report xmlReport
+	for _, summary := range summaries {
+		host := xmlHost{
+			Target:       summary.Target,
+			OpenPorts:    summary.OpenPorts,
+			ScannedPorts: summary.ScannedPorts,
+			TimeTakenMS:  summary.TimeTaken.Milliseconds(),
+			RiskScore:    summary.RiskScore,
+			RiskSeverity: summary.RiskSeverity,
+			Identity:     summary.Identity,
+			Container:    summary.Container,
+			Drift:        summary.Drift,
+			Traffic:      summary.Traffic,
+		}
+		if summary.Owner != nil {
+			host.Owner = summary.Owner.Team
+		}
+		for _, port := range summary.Ports {
+			host.Ports = append(host.Ports, xmlPort{
+				Port:        port.Port,
+				Protocol:    port.proto(),
+				State:       port.State,
+				SubState:    port.SubState,
+				Severity:    port.Severity,
+				Score:       port.Score,
+				Transcript:  port.Transcript,
+				Fingerprint: port.Fingerprint,
+				Banner:      port.Banner,
+				Guidance:    port.Guidance,
+				Accepted:    port.Accepted,
+				Findings:    port.Findings,
+			})
+		}
+		report.Hosts = append(report.Hosts, host)
+	}
+
+	io.WriteString(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func writeMarkdown(w io.Writer, summaries []ScanSummary) error {
+	fmt.Fprintln(w, "# Port Scan Report")
+	for _, summary := range summaries {
+		fmt.Fprintf(w, "\n## %s\n\n", markdownEscape(summary.Target))
+		fmt.Fprintf(w, "- Scanned ports: %d\n", summary.ScannedPorts)
+		fmt.Fprintf(w, "- Open ports: %d\n", summary.OpenPorts)
+		fmt.Fprintf(w, "- Scan duration: %v\n", summary.TimeTaken.Round(time.Millisecond))
+		if summary.Owner != nil {
+			fmt.Fprintf(w, "- Owner: %s\n", markdownEscape(summary.Owner.String()))
+		}
+		if summary.Device != nil {
+			fmt.Fprintf(w, "- Device: %s\n", markdownEscape(summary.Device.String()))
+		}
+		if summary.Identity != nil {
+			fmt.Fprintf(w, "- Same host: %s\n", markdownEscape(summary.Identity.String()))
+		}
+		if summary.Container != nil {
+			fmt.Fprintf(w, "- Container: %s\n", markdownEscape(summary.Container.String()))
+		}
+		for _, drift := range summary.Drift {
+			fmt.Fprintf(w, "- Declared ports: %s\n", markdownEscape(drift.String()))
+		}
+		if summary.Traffic != nil {
+			fmt.Fprintf(w, "- Traffic: %s\n", markdownEscape(summary.Traffic.String()))
+		}
+		if summary.RiskSeverity != "" {
+			fmt.Fprintf(w, "- Risk score: %.1f (%s)\n", summary.RiskScore, summary.RiskSeverity)
+		}
+
+		if len(summary.Ports) == 0 {
+			continue
+		}
+		fmt.Fprintln(w, "\n| Port | State | Banner | Findings |")
+		fmt.Fprintln(w, "|------|-------|--------|----------|")
+		for _, port := range summary.Ports {
+			fmt.Fprintf(w, "| %d/%s | %s | %s | %s |\n",
+				port.Port, port.proto(), port.status(), markdownEscape(port.Banner),
+				markdownEscape(strings.Join(findingTypes(port.Findings), ", ")))
+		}
+
+		if accepted := acceptedFindings(summary); len(accepted) > 0 {
+			fmt.Fprintln(w, "\n### Suppressed (risk accepted)")
+			fmt.Fprintln(w, "\n| Port | Finding | Justification | Approver | Expires |")
+			fmt.Fprintln(w, "|------|---------|---------------|----------|---------|")
+			for _, f := range accepted {
+				a := f.Finding.Accepted
+				fmt.Fprintf(w, "| %d/%s | %s | %s | %s | %s |\n", f.Port, f.Proto, markdownEscape(f.Finding.Type),
+					markdownEscape(a.Justification), markdownEscape(a.Approver), markdownEscape(a.Expires))
+			}
+		}
+
+		if guidance := hostGuidance(summary); len(guidance) > 0 {
+			fmt.Fprintln(w, "\n### Remediation")
+			for _, g := range guidance {
+				fmt.Fprintf(w, "\n#### %s\n\n", markdownEscape(g.Title))
+				if g.Description != "" {
+					fmt.Fprintf(w, "%s\n\n", markdownEscape(g.Description))
+				}
+				if g.Impact != "" {
+					fmt.Fprintf(w, "**Impact:** %s\n\n", markdownEscape(g.Impact))
+				}
+				for _, step := range g.Remediation {
+					fmt.Fprintf(w, "1. %s\n", markdownEscape(step))
+				}
+				if len(g.Remediation) > 0 && len(g.References) > 0 {
+					fmt.Fprintln(w)
+				}
+				for _, ref := range g.References {
+					fmt.Fprintf(w, "- <%s>\n", ref)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func markdownEscape(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	return strings.NewReplacer("|", `\|`, "*", `\*`, "_", `\_`, "`", "\\`").Replace(s)
+}
+
+var htmlReport = htmltemplate.Must(htmltemplate.New("report").Funcs(htmltemplate.FuncMap{
+	"findings": func(fs []Finding) string { return strings.Join(findingTypes(fs), ", ") },
+	"round":    func(d time.Duration) time.Duration { return d.Round(time.Millisecond) },
+	"proto":    func(r ScanResult) string { return r.proto() },
+	"guidance": hostGuidance,
+	"accepted": acceptedFindings,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Port Scan Report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+th { background: #eee; }
+</style>
+</head>
+<body>
+<h1>Port Scan Report</h1>
+{{range .}}
+<h2>{{.Target}}</h2>
+<p>Scanned ports: {{.ScannedPorts}} &middot; Open ports: {{.OpenPorts}} &middot; Scan duration: {{round .TimeTaken}}</p>
+{{with .Owner}}<p>Owner: {{.String}}</p>{{end}}
+{{with .Device}}<p>Device: {{.String}}</p>{{end}}
+{{with .Identity}}<p>Same host: {{.String}}</p>{{end}}
+{{with .Container}}<p>Container: {{.String}}</p>{{end}}
+{{range .Drift}}<p>Declared ports: {{.String}}</p>{{end}}
+{{with .Traffic}}<p>Traffic: {{.String}}</p>{{end}}
+{{if .RiskSeverity}}<p>Risk score: {{printf "%.1f" .RiskScore}} ({{.RiskSeverity}})</p>{{end}}
+{{if .Ports}}<table>
+<tr><th>Port</th><th>State</th><th>Banner</th><th>Findings</th></tr>
+{{range .Ports}}<tr><td>{{.Port}}/{{proto .}}</td><td>{{.State}}{{with .SubState}} ({{.}}){{end}}</td><td>{{.Banner}}</td><td>{{findings .Findings}}</td></tr>
+{{end}}</table>{{end}}
+{{with accepted .}}<h3>Suppressed (risk accepted)</h3>
+<table>
+<tr><th>Port</th><th>Finding</th><th>Justification</th><th>Approver</th><th>Expires</th></tr>
+{{range .}}<tr><td>{{.Port}}/{{.Proto}}</td><td>{{.Finding.Type}}</td><td>{{.Finding.Accepted.Justification}}</td><td>{{.Finding.Accepted.Approver}}</td><td>{{.Finding.Accepted.Expires}}</td></tr>
+{{end}}</table>{{end}}
+{{with guidance .}}<h3>Remediation</h3>
+{{range .}}<h4>{{.Title}}</h4>
+{{with .Description}}<p>{{.}}</p>{{end}}
+{{with .Impact}}<p><strong>Impact:</strong> {{.}}</p>{{end}}
+{{with .Remediation}}<ol>{{range .}}<li>{{.}}</li>{{end}}</ol>{{end}}
+{{with .References}}<ul>{{range .}}<li><a href="{{.}}">{{.}}</a></li>{{end}}</ul>{{end}}
+{{end}}{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+func writeHTML(w io.Writer, summaries []ScanSummary) error {
+	return htmlReport.Execute(w, summaries)
+}
+
+func formatEvidence(evidence map[string]int) string {
+	var parts []string
+	for signal, n := range evidence {
+		parts = append(parts, fmt.Sprintf("%s=%d", signal, n))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
+
+func formatScore(port ScanResult) string {
+	if port.Severity == "" {
+		return ""
+	}
+	return strconv.FormatFloat(port.Score, 'f', 1, 64)
+}
+
+// hostGuidance lists the distinct guidance attached to a host's ports and
+// findings, in port order
+func hostGuidance(summary ScanSummary) []*Guidance {
+	var list []*Guidance
+	seen := map[string]bool{}
+	add := func(g *Guidance) {
+		if g != nil && !seen[g.Title] {
+			seen[g.Title] = true
+			list = append(list, g)
+		}
+	}
+	for _, port := range summary.Ports {
+		for _, f := range port.Findings {
+			add(f.Guidance)
+		}
+		add(port.Guidance)
+	}
+	return list
+}
+
+// guidanceText joins the guidance of a port and its findings for one CSV cell
+func guidanceText(port ScanResult) string {
+	var parts []string
+	for _, g := range hostGuidance(ScanSummary{Ports: []ScanResult{port}}) {
+		parts = append(parts, g.Text())
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// findingFingerprints lists the fingerprints of the findings findingTypes
+// lists, in the same order
+func findingFingerprints(findings []Finding) []string {
+	var fingerprints []string
+	for _, f := range findings {
+		if f.Accepted == nil {
+			fingerprints = append(fingerprints, f.Fingerprint)
+		}
+	}
+	return fingerprints
+}
+
+// findingTypes lists the types of findings that are not accepted
+func findingTypes(findings []Finding) []string {
+	var types []string
+	for _, f := range findings {
+		if f.Accepted == nil {
+			types = append(types, f.Type)
+		}
+	}
+	return types
+}
+
+// acceptedTypes lists the accepted finding types of a port, including the
+// open port itself
+func acceptedTypes(port ScanResult) []string {
+	var types []string
+	for _, f := range acceptedFindings(ScanSummary{Ports: []ScanResult{port}}) {
+		types = append(types, f.Finding.Type)
+	}
+	return types
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// reportFixture is a small scan of two hosts touching every part of a report
+func reportFixture() []ScanSummary {
+	sshGuidance := &Guidance{
+		Title:       "SSH exposed",
+		Description: "An SSH server accepts connections.",
+		Remediation: []string{"Allow only administrative networks."},
+	}
+	vpnGuidance := &Guidance{
+		Title:       "SSL-VPN portal exposed",
+		Impact:      "VPN portals are a frequent initial access vector.",
+		Remediation: []string{"Patch the appliance.", "Require MFA."},
+		References:  []string{"https://example.com/vpn"},
+	}
+	summaries := []ScanSummary{
+		{
+			Target:       "10.0.0.2",
+			OpenPorts:    3,
+			ScannedPorts: 1024,
+			TimeTaken:    1500 * time.Millisecond,
+			Owner:        &Owner{Team: "web", Contacts: []string{"web@example.com"}},
+			RiskScore:    8.2,
+			RiskSeverity: "high",
+			Ports: []ScanResult{
+				{
+					Port: 22, Protocol: "tcp", State: "open", Banner: "SSH-2.0-OpenSSH_9.6",
+					Score: 3, Severity: "medium", Guidance: sshGuidance,
+					Accepted: &Acceptance{Justification: "Bastion host", Approver: "alice", Expires: "2099-01-01"},
+				},
+				{
+					Port: 443, Protocol: "tcp", State: "open", Score: 2, Severity: "low", Transcript: "0123456789abcdef",
+					Findings: []Finding{
+						{Type: "sslvpn-exposed", Detail: "Fortinet FortiGate SSL-VPN", Source: "sslvpn", Score: 6, Severity: "medium", Guidance: vpnGuidance},
+					},
+				},
+				{
+					Port: 161, Protocol: "udp", State: "open", Score: 5, Severity: "medium",
+					Findings: []Finding{{Type: "snmp-public", Detail: `community "public" | read`, Source: "snmp", Score: 5, Severity: "medium"}},
+				},
+			},
+		},
+		{
+			Target:       "10.0.0.1",
+			OpenPorts:    1,
+			ScannedPorts: 1024,
+			TimeTaken:    900 * time.Millisecond,
+			Ports:        []ScanResult{{Port: 111, State: "open", SubState: SubStateTCPWrapped}},
+		},
+	}
+	for i := range summaries {
+		fingerprintFindings(&summaries[i])
+	}
+	return summaries
+}
+
+// checkGolden compares output with testdata/name, rewriting it under -update
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	file := filepath.Join("testdata", name)
+	if *update {
+		if err := os.MkdirAll("testdata", 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %s:\n%s", file, got)
+	}
+}
+
+func TestOutputWriters(t *testing.T) {
+	for _, format := range outputFormatNames() {
+		if format == "template" {
+			continue
+		}
+		t.Run(format, func(t *testing.T) {
+			out, err := newOutputWriter(format, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if err := out.Write(&buf, reportFixture()); err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, "report."+out.Ext, buf.Bytes())
+		})
+	}
+}
+
+func TestTemplateOutput(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ports.tmpl")
+	tmpl := "{{range .}}{{.Target}} {{ms .TimeTaken}}ms:{{range .Ports}} {{.Port}}={{.State}}{{end}}\n{{end}}"
+	if err := os.WriteFile(file, []byte(tmpl), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := newOutputWriter("template", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := out.Write(&buf, reportFixture()); err != nil {
+		t.Fatal(err)
+	}
+	want := "10.0.0.2 1500ms: 22=open 443=open 161=open\n10.0.0.1 900ms: 111=open\n"
+	if buf.String() != want {
+		t.Errorf("template output = %q, want %q", buf.String(), want)
+	}
+
+	if _, err := newOutputWriter("template", ""); err == nil {
+		t.Error("template format without -template accepted")
+	}
+	if _, err := newOutputWriter("pdf", ""); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Owner is the team responsible for a host and how to reach it
+type Owner struct {
+	Team     string   `json:"team"`
+	Contacts []string `json:"contacts,omitempty"`
+}
+
+func (o *Owner) String() string {
+	if len(o.Contacts) == 0 {
+		return o.Team
+	}
+	return o.Team + " (" + strings.Join(o.Contacts, ", ") + ")"
+}
+
+// ownerRule assigns an owner to the hosts it matches. A host matches when it
+// is inside one of the CIDRs or matches one of the hostname globs (if any are
+// given) and carries all of the labels. Jira issues and events for matched
+// hosts go to the rule's own Jira project and sinks as well.
+type ownerRule struct {
+	Owner
+	CIDRs       []string          `json:"cidrs,omitempty"`
+	Hosts       []string          `json:"hosts,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	JiraProject string            `json:"jira_project,omitempty"`
+	Sinks       []string          `json:"sinks,omitempty"`
+
+	nets []*net.IPNet
+}
+
+// ownerMap is the layout of the -owners file. Rules are tried in order and
+// hosts matching none fall back to the default owner, if one is set.
+type ownerMap struct {
+	Owners  []*ownerRule `json:"owners"`
+	Default *ownerRule   `json:"default,omitempty"`
+}
+
+func loadOwners(file string) (*ownerMap, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var m ownerMap
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	for i, rule := range m.Owners {
+		if rule.Team == "" {
+			return nil, fmt.Errorf("%s: owner %d has no team", file, i+1)
+		}
+		if len(rule.CIDRs) == 0 && len(rule.Hosts) == 0 && len(rule.Labels) == 0 {
+			return nil, fmt.Errorf("%s: owner %q matches nothing", file, rule.Team)
+		}
+		for _, cidr := range rule.CIDRs {
+			_, n, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("%s: owner %q: %w", file, rule.Team, err)
+			}
+			rule.nets = append(rule.nets, n)
+		}
+		for _, glob := range rule.Hosts {
+			if _, err := path.Match(glob, ""); err != nil {
+				return nil, fmt.Errorf("%s: owner %q: bad host pattern %q", file, rule.Team, glob)
+			}
+		}
+	}
+	if m.Default != nil && m.Default.Team == "" {
+		return nil, fmt.Errorf("%s: default owner has no team", file)
+	}
+	return &m, nil
+}
+
+// match finds the rule owning a host, or nil
+func (m *ownerMap) match(summary ScanSummary) *ownerRule {
+	var ips []net.IP
+	if ip := net.ParseIP(summary.Target); ip != nil {
+		ips = []net.IP{ip}
+	}
+	resolved := false
+
+	for _, rule := range m.Owners {
+		if !rule.matchLabels(summary.Labels) {
+			continue
+		}
+		if len(rule.nets) == 0 && len(rule.Hosts) == 0 {
+			return rule
+		}
+		for _, glob := range rule.Hosts {
+			if ok, _ := path.Match(glob, summary.Target); ok {
+				return rule
+			}
+		}
+		if len(rule.nets) > 0 && ips == nil && !resolved {
+			ips, _ = net.LookupIP(summary.Target)
+			resolved = true
+		}
+		for _, n := range rule.nets {
+			for _, ip := range ips {
+				if n.Contains(ip) {
+					return rule
+				}
+			}
+		}
+	}
+	return m.Default
+}
+
+func (r *ownerRule) matchLabels(labels map[string]string) bool {
+	for k, v := range r.Labels {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// ownerTeam names the owner of a host, "unowned" if the owner rules matched
+// none, or "" if no owner rules are loaded, so hosts no rule matched can be
+// told apart from a scan that assigns no owners at all
+func ownerTeam(summary ScanSummary, ownersLoaded bool) string {
+	if summary.Owner == nil && !ownersLoaded {
+		return ""
+	}
+	return ownerGroup(summary)
+}
+
+// ownerGroup is the team a host is filed under by -owner and per-owner
+// reports, "unowned" for hosts without an owner
+func ownerGroup(summary ScanSummary) string {
+	if summary.Owner == nil {
+		return "unowned"
+	}
+	return summary.Owner.Team
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// writeOwnerReports writes one report per owning team into dir, named after
+// the team
+func writeOwnerReports(out outputWriter, summaries []ScanSummary, dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	byTeam := map[string][]ScanSummary{}
+	for _, summary := range summaries {
+		team := ownerGroup(summary)
+		byTeam[team] = append(byTeam[team], summary)
+	}
+	teams := make([]string, 0, len(byTeam))
+	for team := range byTeam {
+		teams = append(teams, team)
+	}
+	sort.Strings(teams)
+
+	for _, team := range teams {
+		name := unsafeFileChars.ReplaceAllString(team, "_") + "." + out.Ext
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		err = out.Write(f, byTeam[team])
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"testing"
+)
+
+func TestOwnerTeam(t *testing.T) {
+	owned := ScanSummary{Target: "10.0.0.1", Owner: &Owner{Team: "payments"}}
+	unowned := ScanSummary{Target: "10.0.0.2"}
+	tests := []struct {
+		name      string
+		loaded    bool
+		summary   ScanSummary
+		wantTeam  string
+		wantGroup string
+	}{
+		{"no owner rules", false, unowned, "", "unowned"},
+		{"no rule matched", true, unowned, "unowned", "unowned"},
+		{"owned", true, owned, "payments", "payments"},
+		{"owned in saved results", false, owned, "payments", "payments"},
+	}
+	for _, tt := range tests {
+		if got := ownerTeam(tt.summary, tt.loaded); got != tt.wantTeam {
+			t.Errorf("%s: ownerTeam = %q, want %q", tt.name, got, tt.wantTeam)
+		}
+		if got := ownerGroup(tt.summary); got != tt.wantGroup {
+			t.Errorf("%s: ownerGroup = %q, want %q", tt.name, got, tt.wantGroup)
+		}
+	}
+}
+
+func TestCSVOwnerColumn(t *testing.T) {
+	summaries := []ScanSummary{
+		{Target: "10.0.0.1", Owner: &Owner{Team: "payments"}, Ports: []ScanResult{{Port: 22, Protocol: "tcp", State: "open"}}},
+		{Target: "10.0.0.2", Ports: []ScanResult{{Port: 22, Protocol: "tcp", State: "open"}}},
+	}
+	tests := []struct {
+		owners bool
+		want   []string
+	}{
+		{false, []string{"payments", ""}},
+		{true, []string{"payments", "unowned"}},
+	}
+	for _, tt := range tests {
+		out := outputFormats["csv"]
+		out.Owners = tt.owners
+		var buf bytes.Buffer
+		if err := out.Write(&buf, summaries); err != nil {
+			t.Fatal(err)
+		}
+		records, err := csv.NewReader(&buf).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, r := range records[1:] {
+			got = append(got, r[1])
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("owners loaded %v: owner column = %q, want %q", tt.owners, got, tt.want)
+		}
+	}
+	// The outputFormats entry itself is left alone
+	if outputFormats["csv"].Owners {
+		t.Error("setting Owners changed the shared csv writer")
+	}
+}
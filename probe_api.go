@@ -0,0 +1,480 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Ports HTTP APIs are commonly served on
+var apiPorts = []int{80, 443, 3000, 5000, 8000, 8080, 8081, 8443, 8888, 9000, 9090}
+
+func init() {
+	registerProbes(
+		probe{Name: "openapi", Group: "api", Proto: "tcp", Ports: apiPorts, Run: probeOpenAPI},
+		probe{Name: "graphql", Group: "api", Proto: "tcp", Ports: apiPorts, Run: probeGraphQL},
+		probe{Name: "grpc-web", Group: "api", Proto: "tcp", Ports: apiPorts, Run: probeGRPCWeb},
+		probe{Name: "actuator", Group: "api", Proto: "tcp", Ports: apiPorts, Run: probeActuator},
+	)
+}
+
+// API finding types
+const (
+	FindingGraphQLIntrospection = "graphql-introspection-enabled"
+	FindingActuatorSensitive    = "actuator-sensitive-endpoints"
+)
+
+// maxOperations caps how many operations a probe lists
+const maxOperations = 200
+
+// speaksTLS reports whether a port completes a TLS handshake, which decides
+// the URL scheme API probes use
+func speaksTLS(host string, port int, timeout time.Duration) bool {
+	conn, err := dialPort(host, port, timeout)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	return tls.Client(conn, &tls.Config{InsecureSkipVerify: true}).Handshake() == nil
+}
+
+// capItems truncates a sorted operation list, noting how many were left out
+func capItems(items []string) []string {
+	sort.Strings(items)
+	if len(items) > maxOperations {
+		items = append(items[:maxOperations], fmt.Sprintf("... %d more", len(items)-maxOperations))
+	}
+	return items
+}
+
+/* OpenAPI / Swagger */
+
+var openAPIPaths = []string{
+	"/openapi.json", "/swagger.json", "/v3/api-docs", "/v2/api-docs", "/api-docs",
+	"/swagger/v1/swagger.json", "/api/swagger.json", "/api/openapi.json",
+	"/openapi.yaml", "/swagger.yaml",
+}
+
+var httpMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// probeOpenAPI fetches API descriptions from common locations and lists
+// their operations
+func probeOpenAPI(host string, port int, timeout time.Duration) *ProbeResult {
+	useTLS := speaksTLS(host, port, timeout)
+	client := probeHTTPClient(timeout)
+	for _, path := range openAPIPaths {
+		resp, body, err := fetch(client, probeURL(host, port, path, useTLS), 4<<20)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			continue
+		}
+
+		var result *ProbeResult
+		if strings.HasSuffix(path, ".yaml") {
+			result = parseOpenAPIYAML(body)
+		} else {
+			result = parseOpenAPIJSON(body)
+		}
+		if result != nil {
+			result.Info["path"] = path
+			return result
+		}
+	}
+	return nil
+}
+
+func parseOpenAPIJSON(body []byte) *ProbeResult {
+	var doc struct {
+		OpenAPI string `json:"openapi"`
+		Swagger string `json:"swagger"`
+		Info    struct {
+			Title   string `json:"title"`
+			Version string `json:"version"`
+		} `json:"info"`
+		BasePath string                                `json:"basePath"`
+		Paths    map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil || (doc.OpenAPI == "" && doc.Swagger == "") {
+		return nil
+	}
+
+	result := &ProbeResult{Service: "OpenAPI " + doc.OpenAPI, Version: doc.Info.Version, Info: map[string]string{}}
+	if doc.Swagger != "" {
+		result.Service = "Swagger " + doc.Swagger
+	}
+	if doc.Info.Title != "" {
+		result.Info["title"] = doc.Info.Title
+	}
+	var ops []string
+	for path, item := range doc.Paths {
+		for _, method := range httpMethods {
+			if _, ok := item[method]; ok {
+				ops = append(ops, strings.ToUpper(method)+" "+doc.BasePath+path)
+			}
+		}
+	}
+	result.Info["operations"] = fmt.Sprint(len(ops))
+	result.Items = capItems(ops)
+	return result
+}
+
+// parseOpenAPIYAML reads just enough YAML to list operations: path keys
+// directly under the top-level "paths" mapping and the methods under them
+func parseOpenAPIYAML(body []byte) *ProbeResult {
+	var version, title, path string
+	var ops []string
+	inPaths := false
+	pathIndent, methodIndent := -1, -1
+	for _, line := range strings.Split(string(body), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		key, value, _ := strings.Cut(trimmed, ":")
+		key = strings.Trim(key, `"'`)
+		value = strings.Trim(strings.TrimSpace(value), `"'`)
+
+		if indent == 0 {
+			inPaths = key == "paths"
+			if key == "openapi" || key == "swagger" {
+				version = key + " " + value
+			}
+			continue
+		}
+		if key == "title" && title == "" && !inPaths {
+			title = value
+		}
+		if !inPaths {
+			continue
+		}
+		if pathIndent < 0 {
+			pathIndent = indent
+		}
+		switch {
+		case indent == pathIndent:
+			path, methodIndent = key, -1
+		case path != "" && indent > pathIndent:
+			if methodIndent < 0 {
+				methodIndent = indent
+			}
+			if indent == methodIndent {
+				for _, m := range httpMethods {
+					if key == m {
+						ops = append(ops, strings.ToUpper(m)+" "+path)
+					}
+				}
+			}
+		}
+	}
+	if version == "" {
+		return nil
+	}
+
+	service := "OpenAPI"
+	if strings.HasPrefix(version, "swagger") {
+		service = "Swagger"
+	}
+	result := &ProbeResult{
+		Service: service + " " + strings.TrimPrefix(strings.TrimPrefix(version, "openapi "), "swagger "),
+		Info:    map[string]string{"operations": fmt.Sprint(len(ops))},
+		Items:   capItems(ops),
+	}
+	if title != "" {
+		result.Info["title"] = title
+	}
+	return result
+}
+
+/* GraphQL */
+
+var graphQLPaths = []string{"/graphql", "/api/graphql", "/v1/graphql", "/graphql/v1", "/query", "/gql"}
+
+const graphQLIntrospection = `{"query":"query{__schema{queryType{name} mutationType{name} subscriptionType{name} types{name fields{name}}}}"}`
+
+// probeGraphQL looks for GraphQL endpoints and, when introspection is
+// enabled, lists the query, mutation and subscription fields of the schema
+func probeGraphQL(host string, port int, timeout time.Duration) *ProbeResult {
+	useTLS := speaksTLS(host, port, timeout)
+	client := probeHTTPClient(timeout)
+	for _, path := range graphQLPaths {
+		resp, err := client.Post(probeURL(host, port, path, useTLS), "application/json", strings.NewReader(graphQLIntrospection))
+		if err != nil {
+			continue
+		}
+		var reply struct {
+			Data *struct {
+				Schema struct {
+					QueryType        *struct{ Name string } `json:"queryType"`
+					MutationType     *struct{ Name string } `json:"mutationType"`
+					SubscriptionType *struct{ Name string } `json:"subscriptionType"`
+					Types            []struct {
+						Name   string
+						Fields []struct{ Name string }
+					} `json:"types"`
+				} `json:"__schema"`
+			} `json:"data"`
+			Errors []struct {
+				Message string `json:"message"`
+			} `json:"errors"`
+		}
+		err = json.NewDecoder(io.LimitReader(resp.Body, 8<<20)).Decode(&reply)
+		resp.Body.Close()
+		if err != nil || (reply.Data == nil && len(reply.Errors) == 0) {
+			continue
+		}
+
+		result := &ProbeResult{Service: "GraphQL", Info: map[string]string{"path": path, "introspection": "false"}}
+		if reply.Data == nil || reply.Data.Schema.QueryType == nil {
+			if len(reply.Errors) > 0 {
+				result.Info["error"] = reply.Errors[0].Message
+			}
+			return result
+		}
+
+		schema := reply.Data.Schema
+		roots := map[string]string{schema.QueryType.Name: "query"}
+		if schema.MutationType != nil {
+			roots[schema.MutationType.Name] = "mutation"
+		}
+		if schema.SubscriptionType != nil {
+			roots[schema.SubscriptionType.Name] = "subscription"
+		}
+		var ops []string
+		for _, t := range schema.Types {
+			if kind, ok := roots[t.Name]; ok {
+				for _, f := range t.Fields {
+					ops = append(ops, kind+" "+f.Name)
+				}
+			}
+		}
+		result.Info["introspection"] = "true"
+		result.Info["types"] = fmt.Sprint(len(schema.Types))
+		result.Items = capItems(ops)
+		result.Findings = append(result.Findings, Finding{
+			Type:   FindingGraphQLIntrospection,
+			Detail: fmt.Sprintf("Introspection at %s exposes %d operations", path, len(ops)),
+		})
+		return result
+	}
+	return nil
+}
+
+/* gRPC-web */
+
+const grpcReflectionPath = "/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo"
+
+// probeGRPCWeb sends a gRPC-web request for the reflection service's service
+// list. Servers without reflection still answer with a grpc-status, which
+// identifies them as gRPC-web.
+func probeGRPCWeb(host string, port int, timeout time.Duration) *ProbeResult {
+	// ServerReflectionRequest{list_services: ""} in a single data frame
+	msg := protowire.AppendTag(nil, 7, protowire.BytesType)
+	msg = protowire.AppendString(msg, "")
+	frame := append([]byte{0}, binary.BigEndian.AppendUint32(nil, uint32(len(msg)))...)
+	frame = append(frame, msg...)
+
+	useTLS := speaksTLS(host, port, timeout)
+	req, err := http.NewRequest(http.MethodPost, probeURL(host, port, grpcReflectionPath, useTLS), bytes.NewReader(frame))
+	if err != nil {
+		return nil
+	}
+	req.Header.Set("Content-Type", "application/grpc-web+proto")
+	req.Header.Set("X-Grpc-Web", "1")
+	req.Header.Set("Accept", "application/grpc-web+proto")
+
+	resp, err := probeHTTPClient(timeout).Do(req)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+
+	trailers := parseGRPCWebFrames(body)
+	status := resp.Header.Get("Grpc-Status")
+	if status == "" {
+		status = trailers.status
+	}
+	if status == "" && !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/grpc-web") {
+		return nil
+	}
+
+	result := &ProbeResult{Service: "gRPC-web", Info: map[string]string{"grpc_status": status}}
+	if len(trailers.services) > 0 {
+		result.Info["reflection"] = "true"
+		result.Items = capItems(trailers.services)
+	}
+	return result
+}
+
+type grpcWebReply struct {
+	status   string
+	services []string
+}
+
+// parseGRPCWebFrames reads data frames holding ServerReflectionResponse
+// messages and the trailer frame carrying grpc-status
+func parseGRPCWebFrames(body []byte) grpcWebReply {
+	var reply grpcWebReply
+	for len(body) >= 5 {
+		flag, size := body[0], int(binary.BigEndian.Uint32(body[1:5]))
+		if 5+size > len(body) {
+			break
+		}
+		payload := body[5 : 5+size]
+		body = body[5+size:]
+
+		if flag&0x80 != 0 {
+			for _, line := range strings.Split(string(payload), "\r\n") {
+				if k, v, ok := strings.Cut(line, ":"); ok && strings.EqualFold(strings.TrimSpace(k), "grpc-status") {
+					reply.status = strings.TrimSpace(v)
+				}
+			}
+			continue
+		}
+		// list_services_response (6) { service (1) { name (1) } }
+		for _, list := range protoFields(payload, 6) {
+			for _, svc := range protoFields(list, 1) {
+				for _, name := range protoFields(svc, 1) {
+					reply.services = append(reply.services, string(name))
+				}
+			}
+		}
+	}
+	return reply
+}
+
+// protoFields returns the values of every length-delimited field num
+func protoFields(b []byte, num protowire.Number) [][]byte {
+	var values [][]byte
+	for len(b) > 0 {
+		n, typ, tagLen := protowire.ConsumeTag(b)
+		if tagLen < 0 {
+			return values
+		}
+		b = b[tagLen:]
+		if typ == protowire.BytesType && n == num {
+			v, vLen := protowire.ConsumeBytes(b)
+			if vLen < 0 {
+				return values
+			}
+			values = append(values, v)
+			b = b[vLen:]
+			continue
+		}
+		skip := protowire.ConsumeFieldValue(n, typ, b)
+		if skip < 0 {
+			return values
+		}
+		b = b[skip:]
+	}
+	return values
+}
+
+/* Spring Boot Actuator */
+
+// Actuator endpoints that leak secrets or allow changing the application
+var sensitiveActuators = map[string]bool{
+	"env": true, "heapdump": true, "threaddump": true, "configprops": true,
+	"loggers": true, "jolokia": true, "shutdown": true, "httptrace": true,
+	"logfile": true, "gateway": true, "trace": true, "dump": true,
+}
+
+// probeActuator lists the Spring Boot actuator endpoints exposed over HTTP
+// and, when the mappings endpoint is readable, the application's routes
+func probeActuator(host string, port int, timeout time.Duration) *ProbeResult {
+	useTLS := speaksTLS(host, port, timeout)
+	client := probeHTTPClient(timeout)
+
+	resp, body, err := fetch(client, probeURL(host, port, "/actuator", useTLS), 256*1024)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	var index struct {
+		Links map[string]json.RawMessage `json:"_links"`
+	}
+	if json.Unmarshal(body, &index) != nil || len(index.Links) == 0 {
+		return nil
+	}
+
+	result := &ProbeResult{Service: "Spring Boot Actuator", Info: map[string]string{}}
+	var endpoints, sensitive []string
+	for name := range index.Links {
+		if name == "self" {
+			continue
+		}
+		endpoints = append(endpoints, name)
+		// Templated links such as caches-cache share the base endpoint name
+		base, _, _ := strings.Cut(name, "-")
+		if sensitiveActuators[base] {
+			sensitive = append(sensitive, name)
+		}
+	}
+	sort.Strings(endpoints)
+	sort.Strings(sensitive)
+	result.Info["endpoints"] = strings.Join(endpoints, ",")
+
+	if resp, body, err := fetch(client, probeURL(host, port, "/actuator/health", useTLS), 64*1024); err == nil && resp.StatusCode == http.StatusOK {
+		var health struct{ Status string }
+		if json.Unmarshal(body, &health) == nil && health.Status != "" {
+			result.Info["health"] = health.Status
+		}
+	}
+	if _, ok := index.Links["mappings"]; ok {
+		result.Items = capItems(actuatorMappings(client, probeURL(host, port, "/actuator/mappings", useTLS)))
+	}
+
+	if len(sensitive) > 0 {
+		result.Findings = append(result.Findings, Finding{
+			Type:   FindingActuatorSensitive,
+			Detail: "Exposed actuator endpoints: " + strings.Join(sensitive, ", "),
+		})
+	}
+	return result
+}
+
+// actuatorMappings lists the request mappings of every dispatcher servlet
+func actuatorMappings(client *http.Client, url string) []string {
+	resp, body, err := fetch(client, url, 8<<20)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	var doc struct {
+		Contexts map[string]struct {
+			Mappings struct {
+				DispatcherServlets map[string][]struct {
+					Predicate string `json:"predicate"`
+				} `json:"dispatcherServlets"`
+				DispatcherHandlers map[string][]struct {
+					Predicate string `json:"predicate"`
+				} `json:"dispatcherHandlers"`
+			} `json:"mappings"`
+		} `json:"contexts"`
+	}
+	if json.Unmarshal(body, &doc) != nil {
+		return nil
+	}
+	var routes []string
+	for _, ctx := range doc.Contexts {
+		for _, list := range ctx.Mappings.DispatcherServlets {
+			for _, m := range list {
+				routes = append(routes, m.Predicate)
+			}
+		}
+		for _, list := range ctx.Mappings.DispatcherHandlers {
+			for _, m := range list {
+				routes = append(routes, m.Predicate)
+			}
+		}
+	}
+	return routes
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseOpenAPIYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want *ProbeResult
+	}{
+		{
+			name: "openapi 3",
+			doc: `openapi: 3.0.3
+info:
+  title: "Pet Store"
+  version: 1.0.0
+# operations
+paths:
+  /pets:
+    get:
+      summary: List pets
+      parameters:
+        - name: limit
+          in: query
+    post:
+      summary: Create a pet
+  "/pets/{id}":
+    parameters:
+      - name: id
+    get:
+      responses:
+        "200":
+          description: A pet
+    delete: {}
+components:
+  schemas:
+    Pet:
+      title: Not the API title
+`,
+			want: &ProbeResult{
+				Service: "OpenAPI 3.0.3",
+				Info:    map[string]string{"operations": "4", "title": "Pet Store"},
+				Items:   []string{"DELETE /pets/{id}", "GET /pets", "GET /pets/{id}", "POST /pets"},
+			},
+		},
+		{
+			name: "swagger 2 with four-space indent",
+			doc: `swagger: '2.0'
+info:
+    title: Legacy API
+paths:
+    /login:
+        post:
+            consumes:
+                - application/json
+    /health:
+        head:
+            description: get not counted here
+`,
+			want: &ProbeResult{
+				Service: "Swagger 2.0",
+				Info:    map[string]string{"operations": "2", "title": "Legacy API"},
+				Items:   []string{"HEAD /health", "POST /login"},
+			},
+		},
+		{
+			name: "no paths",
+			doc:  "openapi: 3.1.0\ninfo:\n  title: Empty\n",
+			want: &ProbeResult{
+				Service: "OpenAPI 3.1.0",
+				Info:    map[string]string{"operations": "0", "title": "Empty"},
+			},
+		},
+		{
+			name: "not an API description",
+			doc:  "apiVersion: v1\nkind: Service\nmetadata:\n  name: web\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseOpenAPIYAML([]byte(tt.doc))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseOpenAPIYAML = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerProbes(
+		probe{Name: "mssql", Group: "database", Proto: "tcp", Ports: []int{1433}, Run: probeMSSQL},
+		probe{Name: "mssql-browser", Group: "database", Proto: "udp", Ports: []int{1434}, Run: probeSQLBrowser},
+		probe{Name: "oracle-tns", Group: "database", Proto: "tcp", Ports: []int{1521}, Run: probeOracleTNS},
+	)
+}
+
+// FindingMSSQLNoEncryption marks SQL Servers that cannot encrypt, so logins
+// and all traffic after them are sent in cleartext
+const FindingMSSQLNoEncryption = "mssql-encryption-not-required"
+
+/* MSSQL */
+
+// TDS PRELOGIN option tokens
+const (
+	tdsVersion    = 0x00
+	tdsEncryption = 0x01
+	tdsInstance   = 0x02
+	tdsThreadID   = 0x03
+	tdsTerminator = 0xff
+)
+
+var tdsEncryptionModes = map[byte]string{
+	0: "off",
+	1: "on",
+	2: "not-supported",
+	3: "required",
+}
+
+// probeMSSQL sends a TDS PRELOGIN and reads the server version and its
+// encryption setting from the reply
+func probeMSSQL(host string, port int, timeout time.Duration) *ProbeResult {
+	options := []struct {
+		token byte
+		data  []byte
+	}{
+		{tdsVersion, []byte{0, 0, 0, 0, 0, 0}},
+		{tdsEncryption, []byte{0}}, // encryption off: the server answers with what it insists on
+		{tdsInstance, []byte{0}},
+		{tdsThreadID, []byte{0, 0, 0, 0}},
+	}
+	headerLen := 5*len(options) + 1
+	var table, data []byte
+	for _, o := range options {
+		table = append(table, o.token)
+		table = binary.BigEndian.AppendUint16(table, uint16(headerLen+len(data)))
+		table = binary.BigEndian.AppendUint16(table, uint16(len(o.data)))
+		data = append(data, o.data...)
+	}
+	payload := append(append(table, tdsTerminator), data...)
+
+	packet := []byte{0x12, 0x01} // PRELOGIN, end of message
+	packet = binary.BigEndian.AppendUint16(packet, uint16(8+len(payload)))
+	packet = append(packet, 0, 0, 1, 0)
+	packet = append(packet, payload...)
+
+	conn, err := dialPort(host, port, timeout)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write(packet); err != nil {
+		return nil
+	}
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(conn, header); err != nil || header[0] != 0x04 {
+		return nil
+	}
+	length := int(binary.BigEndian.Uint16(header[2:]))
+	if length < 8 {
+		return nil
+	}
+	reply := make([]byte, length-8)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return nil
+	}
+	return parsePrelogin(reply)
+}
+
+// parsePrelogin reads the option table of a PRELOGIN reply. With encryption
+// off the login packet is still sent over TLS and only the traffic after it
+// is in cleartext; a server that does not support encryption at all takes
+// the login itself in cleartext.
+func parsePrelogin(reply []byte) *ProbeResult {
+	result := &ProbeResult{Service: "MSSQL", Vendor: "Microsoft", Info: map[string]string{}}
+	for off := 0; off+5 <= len(reply) && reply[off] != tdsTerminator; off += 5 {
+		start := int(binary.BigEndian.Uint16(reply[off+1:]))
+		size := int(binary.BigEndian.Uint16(reply[off+3:]))
+		if start+size > len(reply) {
+			break
+		}
+		value := reply[start : start+size]
+
+		switch reply[off] {
+		case tdsVersion:
+			if len(value) >= 4 {
+				result.Version = fmt.Sprintf("%d.%d.%d", value[0], value[1], binary.BigEndian.Uint16(value[2:]))
+			}
+		case tdsEncryption:
+			if len(value) >= 1 {
+				mode := tdsEncryptionModes[value[0]]
+				if mode == "" {
+					mode = fmt.Sprint(value[0])
+				}
+				result.Info["encryption"] = mode
+				if value[0] == 2 {
+					result.Findings = append(result.Findings, Finding{
+						Type:   FindingMSSQLNoEncryption,
+						Detail: "SQL Server does not support encryption, so logins and queries are sent in cleartext",
+					})
+				}
+			}
+		case tdsInstance:
+			if name := strings.TrimRight(string(value), "\x00"); name != "" {
+				result.Info["instance"] = name
+			}
+		}
+	}
+	return result
+}
+
+// probeSQLBrowser asks the SQL Server Browser service to list every
+// instance on the host with its version and TCP port
+func probeSQLBrowser(host string, port int, timeout time.Duration) *ProbeResult {
+	reply, err := udpExchange(host, port, []byte{0x03}, timeout) // CLNT_UCAST_EX
+	if err != nil || len(reply) < 3 || reply[0] != 0x05 {
+		return nil
+	}
+
+	result := &ProbeResult{Service: "SQL Server Browser", Vendor: "Microsoft"}
+	// Instances are ";;"-terminated lists of key;value pairs
+	for _, instance := range strings.Split(string(reply[3:]), ";;") {
+		fields := strings.Split(instance, ";")
+		attrs := map[string]string{}
+		for i := 0; i+1 < len(fields); i += 2 {
+			attrs[fields[i]] = fields[i+1]
+		}
+		if attrs["InstanceName"] == "" {
+			continue
+		}
+		item := attrs["InstanceName"]
+		if attrs["Version"] != "" {
+			item += " " + attrs["Version"]
+		}
+		if attrs["tcp"] != "" {
+			item += " tcp/" + attrs["tcp"]
+		}
+		result.Items = append(result.Items, item)
+	}
+	return result
+}
+
+/* Oracle TNS */
+
+// Listener refusals carry the version either as text or as VSNNUM, a packed
+// number (186647040 is 0x0B200200, version 11.2.0.2.0), plus the TNS error
+var (
+	tnsVersionText = regexp.MustCompile(`(?i)version\s+(\d+\.\d+\.\d+\.\d+(\.\d+)?)`)
+	tnsVSNNUM      = regexp.MustCompile(`VSNNUM=(\d+)`)
+	tnsErrorCode   = regexp.MustCompile(`ERR=(\d+)`)
+)
+
+// TNS packet types
+const (
+	tnsConnect = 1
+	tnsAccept  = 2
+	tnsRefuse  = 4
+	tnsResend  = 11
+)
+
+// probeOracleTNS sends a TNS CONNECT for a service that does not exist. The
+// listener refuses it, and the refusal carries its version and error code.
+func probeOracleTNS(host string, port int, timeout time.Duration) *ProbeResult {
+	data := "(DESCRIPTION=(CONNECT_DATA=(SERVICE_NAME=PORTSCAN_PROBE)(CID=(PROGRAM=portscanner)(HOST=probe)(USER=probe)))" +
+		fmt.Sprintf("(ADDRESS=(PROTOCOL=tcp)(HOST=%s)(PORT=%d)))", host, port)
+
+	body := make([]byte, 26)
+	binary.BigEndian.PutUint16(body[0:], 0x013a)             // version
+	binary.BigEndian.PutUint16(body[2:], 0x012c)             // lowest compatible version
+	binary.BigEndian.PutUint16(body[4:], 0x0c41)             // service options
+	binary.BigEndian.PutUint16(body[6:], 0x2000)             // SDU size
+	binary.BigEndian.PutUint16(body[8:], 0xffff)             // TDU size
+	binary.BigEndian.PutUint16(body[10:], 0x7f08)            // protocol characteristics
+	binary.BigEndian.PutUint16(body[14:], 0x0001)            // byte order
+	binary.BigEndian.PutUint16(body[16:], uint16(len(data))) // connect data length
+	binary.BigEndian.PutUint16(body[18:], 8+26)              // connect data offset
+	body = append(body, data...)
+
+	packet := make([]byte, 8)
+	binary.BigEndian.PutUint16(packet, uint16(8+len(body)))
+	packet[4] = tnsConnect
+	packet = append(packet, body...)
+
+	conn, err := dialPort(host, port, timeout)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if _, err := conn.Write(packet); err != nil {
+			return nil
+		}
+		header := make([]byte, 8)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return nil
+		}
+		length := int(binary.BigEndian.Uint16(header))
+		if length < 8 {
+			return nil
+		}
+		reply := make([]byte, length-8)
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return nil
+		}
+
+		result := &ProbeResult{Service: "Oracle TNS Listener", Vendor: "Oracle", Info: map[string]string{}}
+		switch header[4] {
+		case tnsResend:
+			// Some listeners ask for the CONNECT to be sent again
+			continue
+		case tnsAccept:
+			result.Info["response"] = "accept"
+		case tnsRefuse:
+			parseTNSRefuse(reply, result)
+		default:
+			result.Info["response"] = fmt.Sprintf("type %d", header[4])
+		}
+		return result
+	}
+	return nil
+}
+
+// parseTNSRefuse reads the version and error code from the body of a REFUSE
+func parseTNSRefuse(reply []byte, result *ProbeResult) {
+	result.Info["response"] = "refuse"
+	text := string(reply)
+	if m := tnsErrorCode.FindStringSubmatch(text); m != nil {
+		result.Info["error"] = "TNS-" + m[1]
+	}
+	if m := tnsVersionText.FindStringSubmatch(text); m != nil {
+		result.Version = m[1]
+	} else if m := tnsVSNNUM.FindStringSubmatch(text); m != nil {
+		result.Version = decodeVSNNUM(m[1])
+	}
+}
+
+func decodeVSNNUM(s string) string {
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%d.%d.%d.%d.%d", n>>24, n>>20&0xf, n>>12&0xff, n>>8&0xf, n&0xff)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// preloginReply is the payload of the PRELOGIN reply of SQL Server 2019
+// (15.0.2000) with Force Encryption off, encryption byte at offset 32
+func preloginReply(t *testing.T, encryption string) []byte {
+	return unhex(t,
+		"00 001a 0006", // VERSION
+		"01 0020 0001", // ENCRYPTION
+		"02 0021 0001", // INSTOPT
+		"03 0022 0000", // THREADID
+		"04 0022 0001", // MARS
+		"ff",
+		"0f0007d0 0000", // 15.0.2000, subbuild 0
+		encryption,
+		"00", // instance name valid
+		"00", // MARS off
+	)
+}
+
+func TestParsePrelogin(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply []byte
+		want  *ProbeResult
+	}{
+		{
+			name:  "encryption off",
+			reply: preloginReply(t, "00"),
+			want:  &ProbeResult{Service: "MSSQL", Vendor: "Microsoft", Version: "15.0.2000", Info: map[string]string{"encryption": "off"}},
+		},
+		{
+			name:  "encryption required",
+			reply: preloginReply(t, "03"),
+			want:  &ProbeResult{Service: "MSSQL", Vendor: "Microsoft", Version: "15.0.2000", Info: map[string]string{"encryption": "required"}},
+		},
+		{
+			name:  "encryption not supported",
+			reply: preloginReply(t, "02"),
+			want: &ProbeResult{
+				Service: "MSSQL", Vendor: "Microsoft", Version: "15.0.2000",
+				Info: map[string]string{"encryption": "not-supported"},
+				Findings: []Finding{{
+					Type:   FindingMSSQLNoEncryption,
+					Detail: "SQL Server does not support encryption, so logins and queries are sent in cleartext",
+				}},
+			},
+		},
+		{
+			name:  "unknown encryption mode",
+			reply: preloginReply(t, "80"),
+			want:  &ProbeResult{Service: "MSSQL", Vendor: "Microsoft", Version: "15.0.2000", Info: map[string]string{"encryption": "128"}},
+		},
+		{
+			name:  "instance name",
+			reply: unhex(t, "02 0006 000c", "ff", "4d5353514c53455256455200"),
+			want:  &ProbeResult{Service: "MSSQL", Vendor: "Microsoft", Info: map[string]string{"instance": "MSSQLSERVER"}},
+		},
+		{
+			// Options pointing past the end are skipped along with the rest
+			name:  "truncated",
+			reply: preloginReply(t, "02")[:32],
+			want:  &ProbeResult{Service: "MSSQL", Vendor: "Microsoft", Version: "15.0.2000", Info: map[string]string{}},
+		},
+		{
+			name:  "short version",
+			reply: unhex(t, "00 0006 0002", "ff", "0f00"),
+			want:  &ProbeResult{Service: "MSSQL", Vendor: "Microsoft", Info: map[string]string{}},
+		},
+		{
+			name:  "no terminator",
+			reply: unhex(t, "01 00"),
+			want:  &ProbeResult{Service: "MSSQL", Vendor: "Microsoft", Info: map[string]string{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parsePrelogin(tt.reply); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePrelogin = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeVSNNUM(t *testing.T) {
+	tests := []struct {
+		vsnnum string
+		want   string
+	}{
+		{"186647040", "11.2.0.2.0"},
+		{"318767104", "19.0.0.0.0"},
+		{"169870336", "10.2.0.4.0"},
+		{"0", "0.0.0.0.0"},
+		{"", ""},
+		{"11g", ""},
+		{"99999999999", ""},
+	}
+	for _, tt := range tests {
+		if got := decodeVSNNUM(tt.vsnnum); got != tt.want {
+			t.Errorf("decodeVSNNUM(%q) = %q, want %q", tt.vsnnum, got, tt.want)
+		}
+	}
+}
+
+func TestParseTNSRefuse(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+		want  *ProbeResult
+	}{
+		{
+			name:  "19c listener, unknown service",
+			reply: "\x22\x00\x00\x5c(DESCRIPTION=(TMP=)(VSNNUM=318767104)(ERR=12514)(ERROR_STACK=(ERROR=(CODE=12514)(EMFI=4))))",
+			want:  &ProbeResult{Version: "19.0.0.0.0", Info: map[string]string{"response": "refuse", "error": "TNS-12514"}},
+		},
+		{
+			name:  "version text wins over VSNNUM",
+			reply: "\x22\x00\x00\x60TNSLSNR for Linux: Version 11.2.0.2.0 - Production (VSNNUM=186647040)(ERR=1189)",
+			want:  &ProbeResult{Version: "11.2.0.2.0", Info: map[string]string{"response": "refuse", "error": "TNS-1189"}},
+		},
+		{
+			name:  "no details",
+			reply: "\x22\x00\x00\x00",
+			want:  &ProbeResult{Info: map[string]string{"response": "refuse"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := &ProbeResult{Info: map[string]string{}}
+			parseTNSRefuse([]byte(tt.reply), got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTNSRefuse = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerProbes(
+		probe{Name: "portmapper", Group: "fileshare", Proto: "tcp", Ports: []int{portmapperPort}, Run: probePortmapper},
+		probe{Name: "nfs", Group: "fileshare", Proto: "tcp", Ports: []int{2049}, Run: probeNFSExports},
+		probe{Name: "rsync", Group: "fileshare", Proto: "tcp", Ports: []int{873}, Run: probeRsync},
+	)
+}
+
+// File sharing finding types
+const (
+	FindingNFSWorldExport       = "nfs-world-export"
+	FindingRsyncAnonymousModule = "rsync-anonymous-module"
+)
+
+/* ONC RPC */
+
+// Well-known ONC RPC program numbers
+var rpcPrograms = map[uint32]string{
+	100000: "portmapper",
+	100003: "nfs",
+	100005: "mountd",
+	100021: "nlockmgr",
+	100024: "status",
+	100011: "rquotad",
+	100227: "nfs_acl",
+	100004: "ypserv",
+	100007: "ypbind",
+}
+
+// portmapperPort is where rpcbind listens; the NFS probe runs on the NFS port
+// and asks it for mountd
+const portmapperPort = 111
+
+const (
+	progPortmapper = 100000
+	progMount      = 100005
+	pmapGetPort    = 3
+	pmapDump       = 4
+	mountExport    = 5
+)
+
+// rpcCall makes one ONC RPC call over TCP with AUTH_NULL credentials and
+// returns the procedure's results
+func rpcCall(host string, port int, prog, vers, proc uint32, args []byte, timeout time.Duration) ([]byte, error) {
+	conn, err := dialPort(host, port, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	xid := make([]byte, 4)
+	rand.Read(xid)
+	call := append([]byte{}, xid...)
+	for _, v := range []uint32{0, 2, prog, vers, proc, 0, 0, 0, 0} { // CALL, RPC v2, AUTH_NULL cred and verifier
+		call = binary.BigEndian.AppendUint32(call, v)
+	}
+	call = append(call, args...)
+
+	record := binary.BigEndian.AppendUint32(nil, 0x80000000|uint32(len(call)))
+	if _, err := conn.Write(append(record, call...)); err != nil {
+		return nil, err
+	}
+
+	// Replies may be split over several record fragments
+	var reply []byte
+	for {
+		var marker uint32
+		if err := binary.Read(conn, binary.BigEndian, &marker); err != nil {
+			return nil, err
+		}
+		size := marker & 0x7fffffff
+		if len(reply)+int(size) > 1<<20 {
+			return nil, errors.New("rpc reply too large")
+		}
+		frag := make([]byte, size)
+		if _, err := io.ReadFull(conn, frag); err != nil {
+			return nil, err
+		}
+		reply = append(reply, frag...)
+		if marker&0x80000000 != 0 {
+			break
+		}
+	}
+
+	r := &xdrReader{buf: reply}
+	r.u32() // xid
+	if r.u32() != 1 || r.u32() != 0 {
+		return nil, errors.New("rpc call denied")
+	}
+	r.u32()    // verifier flavor
+	r.opaque() // verifier body
+	if stat := r.u32(); stat != 0 || !r.ok() {
+		return nil, fmt.Errorf("rpc accept status %d", stat)
+	}
+	return reply[r.off:], nil
+}
+
+// pmapEntry is a program registered with the portmapper
+type pmapEntry struct {
+	Prog, Vers, Proto, Port uint32
+}
+
+func (e pmapEntry) String() string {
+	name := rpcPrograms[e.Prog]
+	if name == "" {
+		name = strconv.Itoa(int(e.Prog))
+	}
+	proto := "tcp"
+	if e.Proto == 17 {
+		proto = "udp"
+	}
+	return fmt.Sprintf("%s v%d %s/%d", name, e.Vers, proto, e.Port)
+}
+
+func portmapDump(host string, port int, timeout time.Duration) ([]pmapEntry, error) {
+	res, err := rpcCall(host, port, progPortmapper, 2, pmapDump, nil, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return parsePmapDump(res), nil
+}
+
+// parsePmapDump decodes the linked list of mappings PMAPPROC_DUMP returns,
+// keeping the entries before any truncation
+func parsePmapDump(res []byte) []pmapEntry {
+	var entries []pmapEntry
+	r := &xdrReader{buf: res}
+	for r.u32() == 1 && r.ok() {
+		e := pmapEntry{Prog: r.u32(), Vers: r.u32(), Proto: r.u32(), Port: r.u32()}
+		if r.ok() {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// probePortmapper lists every program registered with rpcbind
+func probePortmapper(host string, port int, timeout time.Duration) *ProbeResult {
+	entries, err := portmapDump(host, port, timeout)
+	if err != nil {
+		return nil
+	}
+	result := &ProbeResult{Service: "ONC RPC portmapper"}
+	for _, e := range entries {
+		result.Items = append(result.Items, e.String())
+	}
+	return result
+}
+
+// probeNFSExports asks the portmapper where mountd listens and lists its
+// exports. Success means the export list is readable without credentials.
+func probeNFSExports(host string, port int, timeout time.Duration) *ProbeResult {
+	args := binary.BigEndian.AppendUint32(nil, progMount)
+	args = binary.BigEndian.AppendUint32(args, 3) // version
+	args = binary.BigEndian.AppendUint32(args, 6) // TCP
+	args = binary.BigEndian.AppendUint32(args, 0)
+	res, err := rpcCall(host, portmapperPort, progPortmapper, 2, pmapGetPort, args, timeout)
+	if err != nil || len(res) < 4 {
+		return nil
+	}
+	mountPort := int(binary.BigEndian.Uint32(res))
+	if mountPort == 0 {
+		return nil
+	}
+
+	res, err = rpcCall(host, mountPort, progMount, 3, mountExport, nil, timeout)
+	if err != nil {
+		return nil
+	}
+
+	result := &ProbeResult{
+		Service: "NFS",
+		Info:    map[string]string{"mountd": strconv.Itoa(mountPort), "anonymous_listing": "true"},
+	}
+	var world []string
+	result.Items, world = parseExportList(res)
+	if len(world) > 0 {
+		result.Findings = append(result.Findings, Finding{
+			Type:   FindingNFSWorldExport,
+			Detail: "Exported to every host: " + strings.Join(world, ", "),
+		})
+	}
+	return result
+}
+
+// parseExportList decodes the exports MOUNTPROC3_EXPORT returns, each with the
+// groups allowed to mount it, and lists those open to every host separately
+func parseExportList(res []byte) (items, world []string) {
+	r := &xdrReader{buf: res}
+	for r.u32() == 1 && r.ok() {
+		path := r.str()
+		var groups []string
+		for r.u32() == 1 && r.ok() {
+			groups = append(groups, r.str())
+		}
+		if !r.ok() {
+			break
+		}
+		if len(groups) == 0 || (len(groups) == 1 && groups[0] == "*") {
+			world = append(world, path)
+			groups = []string{"everyone"}
+		}
+		items = append(items, path+" ("+strings.Join(groups, ", ")+")")
+	}
+	return items, world
+}
+
+// xdrReader decodes big-endian XDR data, remembering whether it ran short
+type xdrReader struct {
+	buf []byte
+	off int
+	bad bool
+}
+
+func (r *xdrReader) ok() bool { return !r.bad }
+
+func (r *xdrReader) u32() uint32 {
+	if r.bad || r.off+4 > len(r.buf) {
+		r.bad = true
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.buf[r.off:])
+	r.off += 4
+	return v
+}
+
+// opaque reads variable-length data padded to a multiple of four bytes
+func (r *xdrReader) opaque() []byte {
+	n := int(r.u32())
+	padded := (n + 3) &^ 3
+	if r.bad || n < 0 || r.off+padded > len(r.buf) {
+		r.bad = true
+		return nil
+	}
+	b := r.buf[r.off : r.off+n]
+	r.off += padded
+	return b
+}
+
+func (r *xdrReader) str() string {
+	return string(r.opaque())
+}
+
+/* rsync */
+
+// probeRsync performs the daemon greeting, requests the module list and then
+// tries each listed module to see whether it opens without authentication
+func probeRsync(host string, port int, timeout time.Duration) *ProbeResult {
+	version, modules, err := rsyncRequest(host, port, "", timeout)
+	if err != nil {
+		return nil
+	}
+
+	result := &ProbeResult{Service: "rsync", Version: version, Info: map[string]string{}}
+	if len(modules) > 0 {
+		result.Info["anonymous_listing"] = "true"
+	}
+
+	var open []string
+	for i, line := range modules {
+		name, comment, _ := strings.Cut(line, "\t")
+		name = strings.TrimSpace(name)
+		item := name
+		if c := strings.TrimSpace(comment); c != "" {
+			item += " (" + c + ")"
+		}
+		// Only the first few modules are tried to keep the probe short
+		if i < 10 {
+			if _, reply, err := rsyncRequest(host, port, name, timeout); err == nil && len(reply) > 0 && strings.HasPrefix(reply[0], "@RSYNCD: OK") {
+				item += " [anonymous]"
+				open = append(open, name)
+			}
+		}
+		result.Items = append(result.Items, item)
+	}
+
+	if len(open) > 0 {
+		result.Findings = append(result.Findings, Finding{
+			Type:   FindingRsyncAnonymousModule,
+			Detail: "Modules readable without authentication: " + strings.Join(open, ", "),
+		})
+	}
+	return result
+}
+
+// rsyncRequest greets an rsync daemon and sends a module name (empty to list
+// modules), returning the server protocol version and the lines it replies with
+func rsyncRequest(host string, port int, module string, timeout time.Duration) (string, []string, error) {
+	conn, err := dialPort(host, port, timeout)
+	if err != nil {
+		return "", nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	reader := bufio.NewReader(conn)
+	greeting, err := reader.ReadString('\n')
+	if err != nil || !strings.HasPrefix(greeting, "@RSYNCD: ") {
+		return "", nil, errors.New("not an rsync daemon")
+	}
+	// rsync 3.2 daemons follow the protocol version with the digests they
+	// support, which are echoed back but not reported
+	greeting = strings.TrimSpace(strings.TrimPrefix(greeting, "@RSYNCD: "))
+	version, _, _ := strings.Cut(greeting, " ")
+
+	if _, err := fmt.Fprintf(conn, "@RSYNCD: %s\n%s\n", greeting, module); err != nil {
+		return version, nil, err
+	}
+
+	var lines []string
+	for len(lines) < 500 {
+		line, err := reader.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		if strings.HasPrefix(line, "@RSYNCD: EXIT") || (err != nil && line == "") {
+			break
+		}
+		lines = append(lines, line)
+		// A module request is answered by a single status line
+		if module != "" && strings.HasPrefix(line, "@") {
+			break
+		}
+		if err != nil {
+			break
+		}
+	}
+	return version, lines, nil
+}
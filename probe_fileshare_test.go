@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestXDRReader(t *testing.T) {
+	r := &xdrReader{buf: unhex(t,
+		"0000002a",                  // 42
+		"00000005 68656c6c6f000000", // "hello", padded to 8
+		"00000000",                  // empty opaque
+		"00000003 616263",           // "abc" missing its padding byte
+	)}
+	if v := r.u32(); v != 42 {
+		t.Errorf("u32 = %d, want 42", v)
+	}
+	if s := r.str(); s != "hello" {
+		t.Errorf("str = %q, want hello", s)
+	}
+	if b := r.opaque(); len(b) != 0 || !r.ok() {
+		t.Errorf("empty opaque = %q, ok %v", b, r.ok())
+	}
+	if b := r.opaque(); b != nil || r.ok() {
+		t.Errorf("opaque without padding = %q, ok %v, want a short read", b, r.ok())
+	}
+	// Once short, every read fails
+	if v := r.u32(); v != 0 || r.ok() {
+		t.Errorf("u32 after a short read = %d, ok %v", v, r.ok())
+	}
+
+	huge := &xdrReader{buf: unhex(t, "ffffffff 00000000")}
+	if b := huge.opaque(); b != nil || huge.ok() {
+		t.Errorf("opaque of length 2^32-1 = %d bytes, ok %v", len(b), huge.ok())
+	}
+}
+
+// pmapDumpReply is the PMAPPROC_DUMP result of rpcbind on a Linux NFS server
+func pmapDumpReply(t *testing.T) []byte {
+	return unhex(t,
+		"00000001 000186a0 00000004 00000006 0000006f", // portmapper v4 tcp/111
+		"00000001 000186a0 00000003 00000011 0000006f", // portmapper v3 udp/111
+		"00000001 000186a5 00000003 00000006 00004e50", // mountd v3 tcp/20048
+		"00000001 000186a3 00000003 00000006 00000801", // nfs v3 tcp/2049
+		"00000001 000186b5 00000004 00000011 00009b89", // nlockmgr v4 udp/39817
+		"00000000",
+	)
+}
+
+func TestParsePmapDump(t *testing.T) {
+	reply := pmapDumpReply(t)
+	all := []string{
+		"portmapper v4 tcp/111", "portmapper v3 udp/111", "mountd v3 tcp/20048",
+		"nfs v3 tcp/2049", "nlockmgr v4 udp/39817",
+	}
+	tests := []struct {
+		name  string
+		reply []byte
+		want  []string
+	}{
+		{"rpcbind", reply, all},
+		{"cut off in an entry", reply[:50], all[:2]},
+		{"missing end marker", reply[:len(reply)-4], all},
+		{"empty list", unhex(t, "00000000"), nil},
+		{"empty", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, e := range parsePmapDump(tt.reply) {
+				got = append(got, e.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("entries = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// exportReply is the MOUNTPROC3_EXPORT result for an /etc/exports with one
+// share open to *, one restricted and one without a client list
+func exportReply(t *testing.T) []byte {
+	return unhex(t,
+		"00000001 0000000b 2f7372762f7075626c696300", // /srv/public
+		"00000001 00000001 2a000000", "00000000",     // *
+		"00000001 00000005 2f686f6d65000000",                     // /home
+		"00000001 0000000b 31302e302e302e302f323400",             // 10.0.0.0/24
+		"00000001 0000000c 3139322e3136382e312e3130", "00000000", // 192.168.1.10
+		"00000001 00000008 2f73637261746368", "00000000", // /scratch
+		"00000000",
+	)
+}
+
+func TestParseExportList(t *testing.T) {
+	reply := exportReply(t)
+	tests := []struct {
+		name      string
+		reply     []byte
+		wantItems []string
+		wantWorld []string
+	}{
+		{
+			name:      "exports",
+			reply:     reply,
+			wantItems: []string{"/srv/public (everyone)", "/home (10.0.0.0/24, 192.168.1.10)", "/scratch (everyone)"},
+			wantWorld: []string{"/srv/public", "/scratch"},
+		},
+		{
+			// An export whose group list is cut off is dropped
+			name:      "cut off in a group list",
+			reply:     reply[:60],
+			wantItems: []string{"/srv/public (everyone)"},
+			wantWorld: []string{"/srv/public"},
+		},
+		{
+			name:  "path longer than the reply",
+			reply: unhex(t, "00000001 0000ffff 2f7372"),
+		},
+		{name: "no exports", reply: unhex(t, "00000000")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items, world := parseExportList(tt.reply)
+			if !reflect.DeepEqual(items, tt.wantItems) || !reflect.DeepEqual(world, tt.wantWorld) {
+				t.Errorf("parseExportList = %q, %q, want %q, %q", items, world, tt.wantItems, tt.wantWorld)
+			}
+		})
+	}
+}
+
+func TestRsyncRequest(t *testing.T) {
+	// daemon answers like rsync 3.2: the module list for an empty request,
+	// OK for an open module and AUTHREQD for one with secrets
+	daemon := func(greeting string) func(net.Conn) {
+		return func(c net.Conn) {
+			defer c.Close()
+			fmt.Fprint(c, greeting)
+			r := bufio.NewReader(c)
+			if _, err := r.ReadString('\n'); err != nil {
+				return
+			}
+			module, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch strings.TrimSpace(module) {
+			case "":
+				fmt.Fprint(c, "public         \tPublic mirror\nbackup         \tNightly backups\n@RSYNCD: EXIT\n")
+			case "public":
+				fmt.Fprint(c, "@RSYNCD: OK\n")
+			case "backup":
+				fmt.Fprint(c, "@RSYNCD: AUTHREQD 4tOKMn1PL9r0nXZ3nkLkaw\n")
+			default:
+				fmt.Fprint(c, "@ERROR: Unknown module '"+strings.TrimSpace(module)+"'\n")
+			}
+		}
+	}
+	modern := listen(t, daemon("@RSYNCD: 31.0 sha512 sha256 sha1 md5 md4\n"))
+	old := listen(t, daemon("@RSYNCD: 30.0\n"))
+	ssh := listen(t, func(c net.Conn) { fmt.Fprint(c, "SSH-2.0-OpenSSH_9.6\r\n"); c.Close() })
+	// A daemon that closes in the middle of the module list
+	cut := listen(t, func(c net.Conn) {
+		fmt.Fprint(c, "@RSYNCD: 31.0\n")
+		bufio.NewReader(c).ReadString('\n')
+		fmt.Fprint(c, "public\tPublic mirror\nback")
+		c.Close()
+	})
+
+	tests := []struct {
+		name        string
+		port        int
+		module      string
+		wantVersion string
+		wantLines   []string
+		wantErr     bool
+	}{
+		{"module list", modern, "", "31.0", []string{"public         \tPublic mirror", "backup         \tNightly backups"}, false},
+		{"open module", modern, "public", "31.0", []string{"@RSYNCD: OK"}, false},
+		{"module with secrets", modern, "backup", "31.0", []string{"@RSYNCD: AUTHREQD 4tOKMn1PL9r0nXZ3nkLkaw"}, false},
+		{"unknown module", old, "missing", "30.0", []string{"@ERROR: Unknown module 'missing'"}, false},
+		{"cut off list", cut, "", "31.0", []string{"public\tPublic mirror", "back"}, false},
+		{"not rsync", ssh, "", "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, lines, err := rsyncRequest("127.0.0.1", tt.port, tt.module, time.Second)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version != tt.wantVersion || !reflect.DeepEqual(lines, tt.wantLines) {
+				t.Errorf("rsyncRequest = %q, %q, want %q, %q", version, lines, tt.wantVersion, tt.wantLines)
+			}
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerProbes(
+		probe{Name: "coap", Group: "iot", Proto: "udp", Ports: []int{5683}, Run: probeCoAP},
+		probe{Name: "upnp", Group: "iot", Proto: "udp", Ports: []int{1900}, Run: probeUPnP},
+		probe{Name: "ubiquiti", Group: "iot", Proto: "udp", Ports: []int{10001}, Run: probeUbiquiti},
+	)
+}
+
+/* CoAP */
+
+// probeCoAP requests /.well-known/core, which lists the resources a
+// constrained device serves in CoRE link format
+func probeCoAP(host string, port int, timeout time.Duration) *ProbeResult {
+	req := []byte{0x40, 0x01, 0, 0} // version 1, confirmable, GET
+	rand.Read(req[2:4])
+	req = append(req, 0xbb) // Uri-Path (option 11), length 11
+	req = append(req, ".well-known"...)
+	req = append(req, 0x04) // Uri-Path again, length 4
+	req = append(req, "core"...)
+
+	reply, err := udpExchange(host, port, req, timeout)
+	if err != nil || len(reply) < 4 || reply[0]>>6 != 1 {
+		return nil
+	}
+
+	code := reply[1]
+	result := &ProbeResult{
+		Service: "CoAP",
+		Info:    map[string]string{"code": fmt.Sprintf("%d.%02d", code>>5, code&0x1f)},
+	}
+	for _, link := range strings.Split(string(coapPayload(reply)), ",") {
+		if start, end := strings.Index(link, "<"), strings.Index(link, ">"); start >= 0 && end > start {
+			result.Items = append(result.Items, link[start+1:end])
+		}
+	}
+	return result
+}
+
+// coapPayload skips the token and options of a CoAP message
+func coapPayload(msg []byte) []byte {
+	off := 4 + int(msg[0]&0x0f)
+	for off < len(msg) {
+		if msg[off] == 0xff {
+			return msg[off+1:]
+		}
+		delta, length := int(msg[off]>>4), int(msg[off]&0x0f)
+		off++
+		for _, v := range []*int{&delta, &length} {
+			switch *v {
+			case 13:
+				if off >= len(msg) {
+					return nil
+				}
+				*v = int(msg[off]) + 13
+				off++
+			case 14:
+				if off+1 >= len(msg) {
+					return nil
+				}
+				*v = int(binary.BigEndian.Uint16(msg[off:])) + 269
+				off += 2
+			case 15:
+				return nil
+			}
+		}
+		off += length
+	}
+	return nil
+}
+
+/* UPnP */
+
+// upnpDescription is the part of a UPnP device description the probe reads
+type upnpDescription struct {
+	Device struct {
+		DeviceType   string `xml:"deviceType"`
+		FriendlyName string `xml:"friendlyName"`
+		Manufacturer string `xml:"manufacturer"`
+		ModelName    string `xml:"modelName"`
+		ModelNumber  string `xml:"modelNumber"`
+		SerialNumber string `xml:"serialNumber"`
+		UDN          string `xml:"UDN"`
+	} `xml:"device"`
+}
+
+// probeUPnP sends a unicast SSDP search and fetches the device description
+// from the LOCATION the device answers with
+func probeUPnP(host string, port int, timeout time.Duration) *ProbeResult {
+	search := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: 239.255.255.250:1900\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 1\r\n" +
+		"ST: upnp:rootdevice\r\n\r\n"
+	reply, err := udpExchange(host, port, []byte(search), timeout)
+	if err != nil {
+		return nil
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(reply)), nil)
+	if err != nil {
+		return nil
+	}
+	resp.Body.Close()
+
+	result := &ProbeResult{Service: "SSDP", Info: map[string]string{}}
+	if server := resp.Header.Get("Server"); server != "" {
+		result.Info["server"] = server
+	}
+	// Anyone can answer a UDP request, so only a LOCATION on the device that
+	// answered is fetched; others are dropped rather than followed
+	location := resp.Header.Get("Location")
+	if location == "" || !locationOnHost(location, host) {
+		return result
+	}
+	result.Info["location"] = location
+
+	descResp, body, err := fetch(probeHTTPClient(timeout), location, 256*1024)
+	if err != nil || descResp.StatusCode != http.StatusOK {
+		return result
+	}
+	var desc upnpDescription
+	if xml.Unmarshal(body, &desc) != nil {
+		return result
+	}
+
+	d := desc.Device
+	if d.DeviceType != "" {
+		result.Info["device_type"] = d.DeviceType
+	}
+	if d.UDN != "" {
+		result.Info["udn"] = d.UDN
+	}
+	model := strings.TrimSpace(d.ModelName + " " + d.ModelNumber)
+	result.Vendor = d.Manufacturer
+	result.Device = &DeviceIdentity{
+		Manufacturer: d.Manufacturer,
+		Model:        model,
+		Serial:       d.SerialNumber,
+		Name:         d.FriendlyName,
+	}
+	return result
+}
+
+// locationOnHost reports whether an SSDP LOCATION is an HTTP URL whose host
+// is the IP address udpExchange accepted the reply from
+func locationOnHost(location, host string) bool {
+	u, err := url.Parse(location)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	ip := net.ParseIP(u.Hostname())
+	if ip == nil {
+		return false
+	}
+	addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(host, "0"))
+	return err == nil && ip.Equal(addr.IP)
+}
+
+/* Ubiquiti Discovery */
+
+// Ubiquiti discovery reply TLV types
+const (
+	ubntHWAddr      = 0x01
+	ubntHWAddrIPv4  = 0x02
+	ubntFirmware    = 0x03
+	ubntHostname    = 0x0b
+	ubntPlatform    = 0x0c
+	ubntESSID       = 0x0d
+	ubntModelFull   = 0x14
+	ubntDiscoveryV1 = 0x01
+)
+
+// probeUbiquiti sends the version 1 discovery request that UniFi and airOS
+// devices answer with their model, firmware, hostname and MAC
+func probeUbiquiti(host string, port int, timeout time.Duration) *ProbeResult {
+	reply, err := udpExchange(host, port, []byte{ubntDiscoveryV1, 0, 0, 0}, timeout)
+	if err != nil || len(reply) < 4 || reply[0] != ubntDiscoveryV1 {
+		return nil
+	}
+
+	device := &DeviceIdentity{Manufacturer: "Ubiquiti"}
+	result := &ProbeResult{Service: "Ubiquiti Discovery", Vendor: "Ubiquiti", Info: map[string]string{}, Device: device}
+
+	end := 4 + int(binary.BigEndian.Uint16(reply[2:4]))
+	if end > len(reply) {
+		end = len(reply)
+	}
+	for off := 4; off+3 <= end; {
+		typ, length := reply[off], int(binary.BigEndian.Uint16(reply[off+1:]))
+		off += 3
+		if off+length > end {
+			break
+		}
+		value := reply[off : off+length]
+		off += length
+
+		switch typ {
+		case ubntHWAddr, ubntHWAddrIPv4:
+			if len(value) >= 6 && device.MAC == "" {
+				device.MAC = net.HardwareAddr(value[:6]).String()
+			}
+		case ubntFirmware:
+			device.Firmware = string(value)
+			result.Version = device.Firmware
+		case ubntHostname:
+			device.Name = string(value)
+		case ubntPlatform:
+			if device.Model == "" {
+				device.Model = string(value)
+			}
+		case ubntModelFull:
+			device.Model = string(value)
+		case ubntESSID:
+			result.Info["essid"] = string(value)
+		}
+	}
+	return result
+}
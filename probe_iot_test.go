@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestLocationOnHost(t *testing.T) {
+	tests := []struct {
+		location string
+		host     string
+		want     bool
+	}{
+		{"http://192.168.1.20:49152/rootDesc.xml", "192.168.1.20", true},
+		{"https://192.168.1.20/desc.xml", "192.168.1.20", true},
+		{"http://[fe80::1]:1900/desc.xml", "fe80::1", true},
+		{"http://192.168.1.20:49152/rootDesc.xml", "localhost", false},
+		{"http://169.254.169.254/latest/meta-data/", "192.168.1.20", false},
+		{"http://10.0.0.5/desc.xml", "192.168.1.20", false},
+		{"http://router.local/desc.xml", "192.168.1.20", false},
+		{"file:///etc/passwd", "192.168.1.20", false},
+		{"gopher://192.168.1.20/", "192.168.1.20", false},
+		{"::not a url", "192.168.1.20", false},
+	}
+	for _, tt := range tests {
+		if got := locationOnHost(tt.location, tt.host); got != tt.want {
+			t.Errorf("locationOnHost(%q, %q) = %v, want %v", tt.location, tt.host, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerProbes(
+		probe{Name: "ike", Group: "vpn", Proto: "udp", Ports: []int{500, 4500}, Run: probeIKE},
+		probe{Name: "openvpn", Group: "vpn", Proto: "tcp", Ports: []int{1194}, Run: probeOpenVPNTCP},
+		probe{Name: "openvpn", Group: "vpn", Proto: "udp", Ports: []int{1194}, Run: probeOpenVPNUDP},
+		probe{Name: "sstp", Group: "vpn", Proto: "tcp", Ports: []int{443}, Run: probeSSTP},
+		probe{Name: "sslvpn", Group: "vpn", Proto: "tcp", Ports: []int{443, 4433, 8443, 10443}, Run: probeSSLVPN},
+	)
+}
+
+/* IKE */
+
+// ikeVendorIDs maps vendor ID payload prefixes to what they announce
+var ikeVendorIDs = []struct {
+	Prefix string
+	Name   string
+	Vendor string
+}{
+	{"12f5f28c457168a9702d9fe274cc", "Cisco Unity", "Cisco"},
+	{"1e2b516905991c7d7c96fcbfb587e461", "MS NT5 ISAKMPOAKLEY", "Microsoft"},
+	{"882fe56d6fd20dbc2251613b2ebe5beb", "strongSwan", "strongSwan"},
+	{"f4ed19e0c114eb516faaac0ee37daf2807b4381f", "Check Point", "Check Point"},
+	{"1d6e178f6c2c0be284985465450fe9d4", "FortiGate", "Fortinet"},
+	{"404bf439522ca3f6", "SonicWall", "SonicWall"},
+	{"4865617274426561745f4e6f74696679", "HeartBeat_Notify", "Juniper"},
+	{"09002689dfd6b712", "XAUTH", ""},
+	{"afcad71368a1f1c96b8696fc77570100", "Dead Peer Detection", ""},
+	{"4a131c81070358455c5728f20e95452f", "RFC 3947 NAT-T", ""},
+	{"4048b7d56ebce88525e7de7f00d6c2d3", "IKE Fragmentation", ""},
+}
+
+// IKE payload and exchange numbers used by the probe
+const (
+	ikeV1PayloadSA       = 1
+	ikeV1PayloadNotify   = 11
+	ikeV1PayloadVendorID = 13
+	ikeV2PayloadSA       = 33
+	ikeV2PayloadKE       = 34
+	ikeV2PayloadNotify   = 41
+	ikeV2PayloadNonce    = 40
+	ikeV2PayloadVendorID = 43
+)
+
+// probeIKE sends an IKEv2 IKE_SA_INIT and falls back to an IKEv1 Main Mode
+// proposal; any well-formed reply, even a rejection, identifies the gateway
+func probeIKE(host string, port int, timeout time.Duration) *ProbeResult {
+	for _, build := range []func() []byte{ikeV2SAInit, ikeV1MainMode} {
+		packet := build()
+		if port == 4500 {
+			// NAT-T port: a zero non-ESP marker precedes IKE messages
+			packet = append(make([]byte, 4), packet...)
+		}
+		reply, err := udpExchange(host, port, packet, timeout)
+		if err != nil {
+			continue
+		}
+		if port == 4500 && len(reply) >= 4 {
+			reply = reply[4:]
+		}
+		if result := parseIKEReply(reply); result != nil {
+			return result
+		}
+	}
+	return nil
+}
+
+func ikeHeader(nextPayload, version, exchange, flags byte) []byte {
+	h := make([]byte, 28)
+	rand.Read(h[:8])
+	h[16] = nextPayload
+	h[17] = version
+	h[18] = exchange
+	h[19] = flags
+	return h
+}
+
+func ikePayload(next byte, body []byte) []byte {
+	p := make([]byte, 4, 4+len(body))
+	p[0] = next
+	binary.BigEndian.PutUint16(p[2:], uint16(4+len(body)))
+	return append(p, body...)
+}
+
+func ikeFinish(msg []byte) []byte {
+	binary.BigEndian.PutUint32(msg[24:], uint32(len(msg)))
+	return msg
+}
+
+func ikeV2SAInit() []byte {
+	transform := func(last bool, typ byte, id uint16, attrs ...byte) []byte {
+		t := make([]byte, 8, 8+len(attrs))
+		if !last {
+			t[0] = 3
+		}
+		binary.BigEndian.PutUint16(t[2:], uint16(8+len(attrs)))
+		t[4] = typ
+		binary.BigEndian.PutUint16(t[6:], id)
+		return append(t, attrs...)
+	}
+
+	var transforms []byte
+	transforms = append(transforms, transform(false, 1, 12, 0x80, 0x0e, 0x01, 0x00)...) // ENCR_AES_CBC-256
+	transforms = append(transforms, transform(false, 2, 5)...)                          // PRF_HMAC_SHA2_256
+	transforms = append(transforms, transform(false, 3, 12)...)                         // AUTH_HMAC_SHA2_256_128
+	transforms = append(transforms, transform(true, 4, 14)...)                          // DH group 14
+
+	proposal := make([]byte, 8, 8+len(transforms))
+	binary.BigEndian.PutUint16(proposal[2:], uint16(8+len(transforms)))
+	proposal[4] = 1 // proposal number
+	proposal[5] = 1 // protocol IKE
+	proposal[7] = 4 // transform count
+	proposal = append(proposal, transforms...)
+
+	ke := make([]byte, 4+256)
+	binary.BigEndian.PutUint16(ke, 14)
+	rand.Read(ke[4:])
+
+	nonce := make([]byte, 32)
+	rand.Read(nonce)
+
+	msg := ikeHeader(ikeV2PayloadSA, 0x20, 34, 0x08)
+	msg = append(msg, ikePayload(ikeV2PayloadKE, proposal)...)
+	msg = append(msg, ikePayload(ikeV2PayloadNonce, ke)...)
+	msg = append(msg, ikePayload(0, nonce)...)
+	return ikeFinish(msg)
+}
+
+func ikeV1MainMode() []byte {
+	// Common PSK proposals: AES-256 and 3DES with SHA1, DH groups 2 and 14
+	offers := [][2]uint16{{7, 2}, {5, 2}, {7, 14}, {5, 14}}
+
+	var transforms []byte
+	for i, o := range offers {
+		attrs := []uint16{
+			0x8001, o[0], // encryption
+			0x8002, 2, // hash SHA1
+			0x8003, 1, // authentication PSK
+			0x8004, o[1], // DH group
+			0x800b, 1, // life type seconds
+			0x800c, 28800, // life duration
+		}
+		if o[0] == 7 {
+			attrs = append(attrs, 0x800e, 256) // key length
+		}
+		t := make([]byte, 8+2*len(attrs))
+		if i < len(offers)-1 {
+			t[0] = 3
+		}
+		binary.BigEndian.PutUint16(t[2:], uint16(len(t)))
+		t[4] = byte(i + 1)
+		t[5] = 1 // KEY_IKE
+		for j, a := range attrs {
+			binary.BigEndian.PutUint16(t[8+2*j:], a)
+		}
+		transforms = append(transforms, t...)
+	}
+
+	proposal := []byte{1, 1, 0, byte(len(offers))}
+	proposal = ikePayload(0, append(proposal, transforms...))
+
+	sa := make([]byte, 8)
+	binary.BigEndian.PutUint32(sa, 1)     // DOI IPsec
+	binary.BigEndian.PutUint32(sa[4:], 1) // situation identity only
+	sa = append(sa, proposal...)
+
+	msg := ikeHeader(ikeV1PayloadSA, 0x10, 2, 0)
+	msg = append(msg, ikePayload(0, sa)...)
+	return ikeFinish(msg)
+}
+
+func parseIKEReply(reply []byte) *ProbeResult {
+	if len(reply) < 28 {
+		return nil
+	}
+	major := reply[17] >> 4
+	if major != 1 && major != 2 {
+		return nil
+	}
+
+	result := &ProbeResult{Service: fmt.Sprintf("IKEv%d", major), Info: map[string]string{}}
+	vidType, notifyType := byte(ikeV1PayloadVendorID), byte(ikeV1PayloadNotify)
+	if major == 2 {
+		vidType, notifyType = ikeV2PayloadVendorID, ikeV2PayloadNotify
+	}
+
+	next, off := reply[16], 28
+	for next != 0 && off+4 <= len(reply) {
+		length := int(binary.BigEndian.Uint16(reply[off+2:]))
+		if length < 4 || off+length > len(reply) {
+			break
+		}
+		body := reply[off+4 : off+length]
+
+		switch next {
+		case vidType:
+			name, vendor := ikeVendorName(body)
+			result.Items = append(result.Items, name)
+			if vendor != "" && result.Vendor == "" {
+				result.Vendor = vendor
+			}
+		case notifyType:
+			// IKEv1 notifications carry a 4 byte DOI before the common fields
+			if major == 1 && len(body) >= 8 {
+				result.Info["notify"] = fmt.Sprint(binary.BigEndian.Uint16(body[6:]))
+			} else if major == 2 && len(body) >= 4 {
+				result.Info["notify"] = fmt.Sprint(binary.BigEndian.Uint16(body[2:]))
+			}
+		}
+		next, off = reply[off], off+length
+	}
+	return result
+}
+
+func ikeVendorName(vid []byte) (name, vendor string) {
+	h := hex.EncodeToString(vid)
+	for _, known := range ikeVendorIDs {
+		if strings.HasPrefix(h, known.Prefix) {
+			return known.Name, known.Vendor
+		}
+	}
+	// Some implementations send readable strings such as FLEXVPN-SUPPORTED
+	if printable(vid) {
+		s := string(vid)
+		if strings.HasPrefix(s, "CISCO") || strings.HasPrefix(s, "FLEXVPN") {
+			return s, "Cisco"
+		}
+		return s, ""
+	}
+	return h, ""
+}
+
+func printable(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	for _, c := range b {
+		if c < 0x20 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+/* OpenVPN */
+
+// openVPNReset is a P_CONTROL_HARD_RESET_CLIENT_V2 without tls-auth. Servers
+// configured with tls-auth or tls-crypt silently drop it.
+func openVPNReset() []byte {
+	pkt := make([]byte, 1+8+1+4)
+	pkt[0] = 7 << 3
+	rand.Read(pkt[1:9])
+	return pkt
+}
+
+func openVPNServerReset(pkt []byte) bool {
+	return len(pkt) >= 9 && pkt[0]>>3 == 8
+}
+
+func probeOpenVPNTCP(host string, port int, timeout time.Duration) *ProbeResult {
+	reset := openVPNReset()
+	framed := make([]byte, 2, 2+len(reset))
+	binary.BigEndian.PutUint16(framed, uint16(len(reset)))
+	framed = append(framed, reset...)
+
+	reply, err := tcpExchange(host, port, framed, 64, timeout)
+	if err != nil || len(reply) < 2 || !openVPNServerReset(reply[2:]) {
+		return nil
+	}
+	return &ProbeResult{Service: "OpenVPN", Vendor: "OpenVPN"}
+}
+
+func probeOpenVPNUDP(host string, port int, timeout time.Duration) *ProbeResult {
+	reply, err := udpExchange(host, port, openVPNReset(), timeout)
+	if err != nil || !openVPNServerReset(reply) {
+		return nil
+	}
+	return &ProbeResult{Service: "OpenVPN", Vendor: "OpenVPN"}
+}
+
+/* SSTP */
+
+// probeSSTP sends the SSTP_DUPLEX_POST that opens a Microsoft SSTP tunnel;
+// SSTP servers accept it with 200 where ordinary web servers reject the method
+func probeSSTP(host string, port int, timeout time.Duration) *ProbeResult {
+	conn, err := dialPort(host, port, timeout)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+
+	conn = transcripts.recordDecrypted(tls.Client(conn, &tls.Config{ServerName: host, InsecureSkipVerify: true}), host, port)
+	conn.SetDeadline(time.Now().Add(timeout))
+	req := "SSTP_DUPLEX_POST /sra_{BA195980-CD49-458b-9E23-C84EE0ADCD75}/ HTTP/1.1\r\n" +
+		"Host: " + net.JoinHostPort(host, strconv.Itoa(port)) + "\r\n" +
+		"Content-Length: 18446744073709551615\r\n" +
+		"SstpCorrelationID: {2F5E5D36-4A3B-4C8D-9E0F-1A2B3C4D5E6F}\r\n\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		return nil
+	}
+
+	// SSTP servers answer with the same Content-Length of 2^64-1, which
+	// http.ReadResponse rejects, so only the status line and headers are read
+	r := textproto.NewReader(bufio.NewReader(conn))
+	status, err := r.ReadLine()
+	if err != nil {
+		return nil
+	}
+	fields := strings.Fields(status)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "HTTP/1.") || fields[1] != "200" {
+		return nil
+	}
+	header, err := r.ReadMIMEHeader()
+	if err != nil {
+		return nil
+	}
+	result := &ProbeResult{Service: "SSTP", Vendor: "Microsoft"}
+	if server := header.Get("Server"); server != "" {
+		result.Info = map[string]string{"server": server}
+	}
+	return result
+}
+
+/* SSL-VPN Portals */
+
+var sslVPNPortals = []struct {
+	Vendor  string
+	Product string
+	Path    string
+	Markers []string
+}{
+	{"Fortinet", "FortiGate SSL-VPN", "/remote/login", []string{"fgt_lang", "ftnt-fortinet"}},
+	{"Ivanti", "Pulse/Ivanti Connect Secure", "/dana-na/auth/url_default/welcome.cgi", []string{"dana-na", "pulse secure", "ivanti"}},
+	{"Cisco", "ASA AnyConnect", "/+CSCOE+/logon.html", []string{"webvpn", "anyconnect"}},
+	{"Palo Alto Networks", "GlobalProtect", "/global-protect/login.esp", []string{"globalprotect"}},
+	{"Citrix", "Citrix Gateway", "/vpn/index.html", []string{"citrix", "netscaler"}},
+	{"SonicWall", "SonicWall SSL-VPN", "/cgi-bin/welcome", []string{"sonicwall"}},
+	{"Check Point", "Check Point Mobile Access", "/sslvpn/Login/Login", []string{"check point", "checkpoint"}},
+}
+
+// notTLS reports whether a request failed because the server answered the
+// TLS handshake with something else
+func notTLS(err error) bool {
+	var recordErr tls.RecordHeaderError
+	return errors.As(err, &recordErr) || errors.Is(err, http.ErrSchemeMismatch)
+}
+
+func probeSSLVPN(host string, port int, timeout time.Duration) *ProbeResult {
+	client := probeHTTPClient(timeout)
+	for _, portal := range sslVPNPortals {
+		resp, body, err := fetch(client, probeURL(host, port, portal.Path, true), 64*1024)
+		if err != nil {
+			// Not speaking TLS at all; no portal will answer either
+			if resp == nil && notTLS(err) {
+				return nil
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			continue
+		}
+		page := strings.ToLower(string(body))
+		for _, marker := range portal.Markers {
+			if strings.Contains(page, marker) {
+				return &ProbeResult{
+					Service: portal.Product,
+					Vendor:  portal.Vendor,
+					Info:    map[string]string{"path": portal.Path},
+				}
+			}
+		}
+	}
+	return nil
+}
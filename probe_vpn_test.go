@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotTLS(t *testing.T) {
+	plainHTTP := httptest.NewServer(http.NotFoundHandler())
+	defer plainHTTP.Close()
+	tlsHTTP := httptest.NewTLSServer(http.NotFoundHandler())
+	defer tlsHTTP.Close()
+
+	// A service that greets with a banner, like SSH
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+			time.Sleep(100 * time.Millisecond)
+			conn.Close()
+		}
+	}()
+
+	tests := []struct {
+		name string
+		addr string
+		want bool
+	}{
+		{"plain http", strings.TrimPrefix(plainHTTP.URL, "http://"), true},
+		{"banner", ln.Addr().String(), true},
+		{"tls", strings.TrimPrefix(tlsHTTP.URL, "https://"), false},
+	}
+	client := probeHTTPClient(time.Second)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.Get("https://" + tt.addr + "/")
+			if tt.want && err == nil {
+				t.Fatal("request succeeded")
+			}
+			if got := err != nil && notTLS(err); got != tt.want {
+				t.Errorf("notTLS(%v) = %v, want %v", err, got, tt.want)
+			}
+		})
+	}
+}
+
+// listenTLS serves every TLS connection to a local port with handle, using a
+// throwaway self-signed certificate
+func listenTLS(t *testing.T, handle func(net.Conn)) int {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "vpn.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestProbeSSTP(t *testing.T) {
+	// reply reads the request headers, then sends the reply and holds the
+	// connection open the way a tunnel would
+	reply := func(response string) func(net.Conn) {
+		return func(c net.Conn) {
+			defer c.Close()
+			r := textproto.NewReader(bufio.NewReader(c))
+			if _, err := r.ReadLine(); err != nil {
+				return
+			}
+			if _, err := r.ReadMIMEHeader(); err != nil {
+				return
+			}
+			c.Write([]byte(response))
+			time.Sleep(200 * time.Millisecond)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		handle func(net.Conn)
+		want   *ProbeResult
+	}{
+		{
+			// The reply of Windows RRAS, as given in MS-SSTP
+			name: "sstp server",
+			handle: reply("HTTP/1.1 200\r\n" +
+				"Content-Length: 18446744073709551615\r\n" +
+				"Server: Microsoft-HTTPAPI/2.0\r\n" +
+				"Date: Thu, 09 Nov 2006 00:51:09 GMT\r\n\r\n"),
+			want: &ProbeResult{Service: "SSTP", Vendor: "Microsoft", Info: map[string]string{"server": "Microsoft-HTTPAPI/2.0"}},
+		},
+		{
+			name:   "sstp server without server header",
+			handle: reply("HTTP/1.1 200 OK\r\nContent-Length: 18446744073709551615\r\n\r\n"),
+			want:   &ProbeResult{Service: "SSTP", Vendor: "Microsoft"},
+		},
+		{
+			name:   "web server",
+			handle: reply("HTTP/1.1 405 Method Not Allowed\r\nServer: nginx\r\nContent-Length: 0\r\n\r\n"),
+		},
+		{
+			name:   "not http",
+			handle: reply("SSH-2.0-OpenSSH_9.6\r\n"),
+		},
+		{
+			name:   "headers cut off",
+			handle: reply("HTTP/1.1 200\r\nContent-Length: 18446744073709551615\r\n"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port := listenTLS(t, tt.handle)
+			if got := probeSSTP("127.0.0.1", port, time.Second); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("probeSSTP = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
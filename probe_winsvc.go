@@ -0,0 +1,569 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerProbes(
+		probe{Name: "kerberos", Group: "windows", Proto: "tcp", Ports: []int{88}, Run: probeKerberos},
+		probe{Name: "winrm", Group: "windows", Proto: "tcp", Ports: []int{5985, 5986}, Run: probeWinRM},
+		probe{Name: "msrpc", Group: "windows", Proto: "tcp", Ports: []int{135}, Run: probeEPM},
+	)
+}
+
+// FindingWinRMBasicHTTP marks WinRM accepting Basic credentials without TLS
+const FindingWinRMBasicHTTP = "winrm-basic-auth-over-http"
+
+/* Kerberos */
+
+var kerberosErrors = map[int]string{
+	6:  "KDC_ERR_C_PRINCIPAL_UNKNOWN",
+	14: "KDC_ERR_ETYPE_NOSUPP",
+	24: "KDC_ERR_PREAUTH_FAILED",
+	25: "KDC_ERR_PREAUTH_REQUIRED",
+	37: "KRB_AP_ERR_SKEW",
+	60: "KRB_ERR_GENERIC",
+	68: "KDC_ERR_WRONG_REALM",
+}
+
+// probeKerberos sends an AS-REQ for a made-up user without pre-authentication.
+// The KDC rejects it with a KRB-ERROR that carries its realm and clock.
+func probeKerberos(host string, port int, timeout time.Duration) *ProbeResult {
+	req := kerberosASReq(guessRealm(host))
+	framed := make([]byte, 4, 4+len(req))
+	binary.BigEndian.PutUint32(framed, uint32(len(req)))
+	framed = append(framed, req...)
+
+	conn, err := dialPort(host, port, timeout)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write(framed); err != nil {
+		return nil
+	}
+
+	var length uint32
+	if err := binary.Read(conn, binary.BigEndian, &length); err != nil || length > 1<<16 {
+		return nil
+	}
+	reply := make([]byte, length)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return nil
+	}
+
+	var msg asn1.RawValue
+	if _, err := asn1.Unmarshal(reply, &msg); err != nil || msg.Class != asn1.ClassApplication {
+		return nil
+	}
+
+	result := &ProbeResult{Service: "Kerberos", Info: map[string]string{"role": "kdc"}}
+	switch msg.Tag {
+	case 11: // AS-REP: the KDC issued a ticket without pre-authentication
+		result.Info["reply"] = "AS-REP"
+	case 30: // KRB-ERROR
+		fields := derFields(msg.Bytes)
+		if code, ok := derInt(fields[6]); ok {
+			name := kerberosErrors[code]
+			if name == "" {
+				name = strconv.Itoa(code)
+			}
+			result.Info["error"] = name
+		}
+		if realm := derString(fields[9]); realm != "" {
+			result.Info["realm"] = realm
+		}
+		if stime := derString(fields[4]); stime != "" {
+			result.Info["server_time"] = stime
+		}
+		if text := derString(fields[11]); text != "" {
+			result.Info["e_text"] = text
+		}
+	default:
+		return nil
+	}
+	return result
+}
+
+// guessRealm derives a realm from a DNS name (dc1.corp.example.com becomes
+// CORP.EXAMPLE.COM); the KDC reports its real one if the guess is wrong
+func guessRealm(host string) string {
+	if net.ParseIP(host) != nil {
+		return "WORKGROUP"
+	}
+	if i := strings.Index(host, "."); i >= 0 {
+		return strings.ToUpper(host[i+1:])
+	}
+	return strings.ToUpper(host)
+}
+
+func kerberosASReq(realm string) []byte {
+	user := make([]byte, 4)
+	rand.Read(user)
+	nonce, _ := rand.Int(rand.Reader, big.NewInt(1<<31-1))
+
+	principal := func(nameType int, names ...string) []byte {
+		var parts [][]byte
+		for _, n := range names {
+			parts = append(parts, derTag(0x1b, []byte(n)))
+		}
+		return derSeq(derCtx(0, derInteger(nameType)), derCtx(1, derSeq(parts...)))
+	}
+
+	body := derSeq(
+		derCtx(0, derTag(0x03, []byte{0, 0x40, 0x81, 0x00, 0x10})), // forwardable, renewable, canonicalize, renewable-ok
+		derCtx(1, principal(1, fmt.Sprintf("probe%x", user))),
+		derCtx(2, derTag(0x1b, []byte(realm))),
+		derCtx(3, principal(2, "krbtgt", realm)),
+		derCtx(5, derTag(0x18, []byte("20370913024805Z"))),
+		derCtx(7, derInteger(int(nonce.Int64()))),
+		derCtx(8, derSeq(derInteger(18), derInteger(17), derInteger(23))), // AES256, AES128, RC4
+	)
+	return derTag(0x6a, derSeq(
+		derCtx(1, derInteger(5)),  // pvno
+		derCtx(2, derInteger(10)), // AS-REQ
+		derCtx(4, body),
+	))
+}
+
+/* DER Helpers */
+func derTag(tag byte, content []byte) []byte {
+	out := []byte{tag}
+	switch n := len(content); {
+	case n < 0x80:
+		out = append(out, byte(n))
+	case n < 0x100:
+		out = append(out, 0x81, byte(n))
+	default:
+		out = append(out, 0x82, byte(n>>8), byte(n))
+	}
+	return append(out, content...)
+}
+
+func derSeq(parts ...[]byte) []byte {
+	var content []byte
+	for _, p := range parts {
+		content = append(content, p...)
+	}
+	return derTag(0x30, content)
+}
+
+func derCtx(n int, content []byte) []byte {
+	return derTag(0xa0+byte(n), content)
+}
+
+func derInteger(v int) []byte {
+	b, _ := asn1.Marshal(v)
+	return b
+}
+
+// derFields splits an explicitly tagged SEQUENCE into its fields by tag number
+func derFields(data []byte) map[int]asn1.RawValue {
+	fields := map[int]asn1.RawValue{}
+	var seq asn1.RawValue
+	if _, err := asn1.Unmarshal(data, &seq); err != nil {
+		return fields
+	}
+	rest := seq.Bytes
+	for len(rest) > 0 {
+		var field asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &field); err != nil {
+			break
+		}
+		fields[field.Tag] = field
+	}
+	return fields
+}
+
+func derInt(field asn1.RawValue) (int, bool) {
+	var v int
+	if len(field.Bytes) == 0 {
+		return 0, false
+	}
+	_, err := asn1.Unmarshal(field.Bytes, &v)
+	return v, err == nil
+}
+
+// derString reads the string inside an explicit tag, whatever its string type
+func derString(field asn1.RawValue) string {
+	var inner asn1.RawValue
+	if len(field.Bytes) == 0 {
+		return ""
+	}
+	if _, err := asn1.Unmarshal(field.Bytes, &inner); err != nil {
+		return ""
+	}
+	return string(inner.Bytes)
+}
+
+/* WinRM */
+
+// probeWinRM posts an empty request to /wsman and records the authentication
+// schemes offered in the 401 challenge
+func probeWinRM(host string, port int, timeout time.Duration) *ProbeResult {
+	useTLS := port == 5986
+	req, err := http.NewRequest(http.MethodPost, probeURL(host, port, "/wsman", useTLS), nil)
+	if err != nil {
+		return nil
+	}
+	req.Header.Set("Content-Type", "application/soap+xml;charset=UTF-8")
+
+	resp, err := probeHTTPClient(timeout).Do(req)
+	if err != nil {
+		return nil
+	}
+	resp.Body.Close()
+
+	server := resp.Header.Get("Server")
+	if resp.StatusCode != http.StatusUnauthorized && !strings.Contains(server, "Microsoft-HTTPAPI") {
+		return nil
+	}
+
+	result := &ProbeResult{Service: "WinRM", Vendor: "Microsoft", Info: map[string]string{}}
+	if server != "" {
+		result.Info["server"] = server
+	}
+	for _, challenge := range resp.Header.Values("WWW-Authenticate") {
+		scheme, _, _ := strings.Cut(challenge, " ")
+		result.Items = append(result.Items, scheme)
+		if strings.EqualFold(scheme, "Basic") && !useTLS {
+			result.Findings = append(result.Findings, Finding{
+				Type:   FindingWinRMBasicHTTP,
+				Detail: "WinRM offers Basic authentication over unencrypted HTTP",
+			})
+		}
+	}
+	return result
+}
+
+/* MSRPC Endpoint Mapper */
+
+// Well-known RPC interfaces, keyed by UUID
+var rpcInterfaces = map[string]string{
+	"12345778-1234-abcd-ef00-0123456789ab": "LSARPC",
+	"12345778-1234-abcd-ef00-0123456789ac": "SAMR",
+	"12345678-1234-abcd-ef00-01234567cffb": "NETLOGON",
+	"e3514235-4b06-11d1-ab04-00c04fc2dcd2": "DRSUAPI",
+	"f5cc5a18-4264-101a-8c59-08002b2f8426": "NSPI",
+	"50abc2a4-574d-40b3-9d66-ee4fd5fba076": "DNSSERVER",
+	"897e2e5f-93f3-4376-9c9c-fd2277495c27": "DFSR",
+	"367abb81-9844-35f1-ad32-98f038001003": "SVCCTL",
+	"86d35949-83c9-4044-b424-db363231fd0c": "ITaskSchedulerService",
+	"1ff70682-0a51-30e8-076d-740be8cee98b": "ATSVC",
+	"4b324fc8-1670-01d3-1278-5a47bf6ee188": "SRVSVC",
+	"6bffd098-a112-3610-9833-46c3f87e345a": "WKSSVC",
+	"338cd001-2244-31f1-aaaa-900038001003": "WINREG",
+	"12345678-1234-abcd-ef00-0123456789ab": "SPOOLSS",
+	"76f03f96-cdfd-44fc-a22c-64950a001209": "IRemoteWinspool",
+	"82273fdc-e32a-18c3-3f78-827929dc23ea": "EVENTLOG",
+	"f6beaff7-1e19-4fbb-9f8f-b89e2018337c": "EVEN6",
+}
+
+// DCE/RPC syntaxes used by the endpoint mapper exchange
+var (
+	epmSyntax = rpcUUID("e1af8308-5d1f-11c9-91a4-08002b14a0fa")
+	ndrSyntax = rpcUUID("8a885d04-1ceb-11c9-9fe8-08002b104860")
+)
+
+// probeEPM binds to the endpoint mapper and walks ept_lookup, listing every
+// registered interface with the endpoint it listens on
+func probeEPM(host string, port int, timeout time.Duration) *ProbeResult {
+	conn, err := dialPort(host, port, timeout)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	bind := make([]byte, 12)
+	binary.LittleEndian.PutUint16(bind[0:], 4280) // max xmit
+	binary.LittleEndian.PutUint16(bind[2:], 4280) // max recv
+	bind[8] = 1                                   // one context item
+	ctx := make([]byte, 4)
+	ctx[2] = 1 // one transfer syntax
+	ctx = append(ctx, epmSyntax...)
+	ctx = append(ctx, 3, 0, 0, 0) // version 3.0
+	ctx = append(ctx, ndrSyntax...)
+	ctx = append(ctx, 2, 0, 0, 0) // version 2
+	bind = append(bind, ctx...)
+
+	if _, err := conn.Write(rpcPDU(11, 1, bind)); err != nil {
+		return nil
+	}
+	if ptype, _, err := readRPC(conn); err != nil || ptype != 12 {
+		return nil
+	}
+
+	result := &ProbeResult{Service: "MSRPC", Vendor: "Microsoft", Info: map[string]string{}}
+	ports := map[int]bool{}
+	handle := make([]byte, 20)
+	for call := uint32(2); call < 50; call++ {
+		stub := make([]byte, 16, 16+20+4)
+		// inquiry type 0 (all elements), NULL object and interface, all versions
+		binary.LittleEndian.PutUint32(stub[12:], 1)
+		stub = append(stub, handle...)
+		stub = binary.LittleEndian.AppendUint32(stub, 100)
+
+		req := make([]byte, 8)
+		binary.LittleEndian.PutUint32(req, uint32(len(stub)))
+		binary.LittleEndian.PutUint16(req[6:], 2) // opnum ept_lookup
+		if _, err := conn.Write(rpcPDU(0, call, append(req, stub...))); err != nil {
+			break
+		}
+		ptype, body, err := readRPC(conn)
+		if err != nil || ptype != 2 || len(body) < 8+24 {
+			break
+		}
+
+		entries, next := parseEPTLookup(body[8:])
+		for _, e := range entries {
+			name := rpcInterfaces[e.UUID]
+			if name == "" {
+				name = e.UUID
+			}
+			item := fmt.Sprintf("%s v%d %s", name, e.Version, e.Endpoint)
+			if e.Annotation != "" {
+				item += " (" + e.Annotation + ")"
+			}
+			result.Items = append(result.Items, item)
+			if e.Port > 0 {
+				ports[e.Port] = true
+			}
+			if name == "DRSUAPI" {
+				result.Info["role"] = "domain-controller"
+			}
+		}
+		if len(entries) == 0 || allZero(next) {
+			break
+		}
+		handle = next
+	}
+
+	if len(ports) > 0 {
+		list := make([]int, 0, len(ports))
+		for p := range ports {
+			list = append(list, p)
+		}
+		sort.Ints(list)
+		result.Info["dynamic_ports"] = joinInts(list, ",")
+	}
+	return result
+}
+
+// rpcPDU frames a connection-oriented DCE/RPC PDU, little-endian, single fragment
+func rpcPDU(ptype byte, callID uint32, body []byte) []byte {
+	pdu := []byte{5, 0, ptype, 0x03, 0x10, 0, 0, 0}
+	pdu = binary.LittleEndian.AppendUint16(pdu, uint16(16+len(body)))
+	pdu = binary.LittleEndian.AppendUint16(pdu, 0)
+	pdu = binary.LittleEndian.AppendUint32(pdu, callID)
+	return append(pdu, body...)
+}
+
+// readRPC reads a PDU, reassembling fragments, and returns its type and body
+func readRPC(conn net.Conn) (byte, []byte, error) {
+	var body []byte
+	for {
+		header := make([]byte, 16)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return 0, nil, err
+		}
+		fragLen := int(binary.LittleEndian.Uint16(header[8:]))
+		if fragLen < 16 {
+			return 0, nil, fmt.Errorf("short rpc fragment")
+		}
+		frag := make([]byte, fragLen-16)
+		if _, err := io.ReadFull(conn, frag); err != nil {
+			return 0, nil, err
+		}
+
+		// Response fragments each repeat the 8 byte request header
+		if len(body) > 0 && len(frag) >= 8 {
+			frag = frag[8:]
+		}
+		body = append(body, frag...)
+		if header[3]&0x02 != 0 {
+			return header[2], body, nil
+		}
+	}
+}
+
+// eptEntry is one endpoint mapper registration
+type eptEntry struct {
+	UUID       string
+	Version    int
+	Endpoint   string
+	Port       int
+	Annotation string
+}
+
+// parseEPTLookup decodes the NDR stub of an ept_lookup response: the context
+// handle, the entry count, the entries, and finally their deferred towers
+func parseEPTLookup(stub []byte) ([]eptEntry, []byte) {
+	r := &ndrReader{buf: stub}
+	handle := r.bytes(20)
+	count := int(r.u32())
+	r.u32() // max count
+	r.u32() // offset
+	actual := int(r.u32())
+	if actual > count {
+		actual = count
+	}
+
+	// Each entry takes at least 28 bytes, which bounds what a bogus count
+	// can allocate
+	capacity := min(actual, len(stub)/28)
+	entries := make([]eptEntry, 0, capacity)
+	towers := make([]bool, 0, capacity)
+	for i := 0; i < actual && r.ok(); i++ {
+		r.bytes(16) // object UUID
+		towers = append(towers, r.u32() != 0)
+		r.u32() // annotation offset
+		n := int(r.u32())
+		annotation := strings.TrimRight(string(r.bytes(n)), "\x00")
+		r.align(4)
+		entries = append(entries, eptEntry{Annotation: annotation})
+	}
+	for i := range entries {
+		if !towers[i] || !r.ok() {
+			continue
+		}
+		r.u32() // max count
+		length := int(r.u32())
+		parseTower(r.bytes(length), &entries[i])
+		r.align(4)
+	}
+	if !r.ok() {
+		return nil, nil
+	}
+	return entries, handle
+}
+
+// parseTower reads the interface from the first floor and the endpoint from
+// the protocol floors that follow
+func parseTower(tower []byte, e *eptEntry) {
+	if len(tower) < 2 {
+		return
+	}
+	floors := int(binary.LittleEndian.Uint16(tower))
+	off := 2
+	for f := 0; f < floors && off+2 <= len(tower); f++ {
+		lhsLen := int(binary.LittleEndian.Uint16(tower[off:]))
+		off += 2
+		if off+lhsLen+2 > len(tower) {
+			return
+		}
+		lhs := tower[off : off+lhsLen]
+		off += lhsLen
+		rhsLen := int(binary.LittleEndian.Uint16(tower[off:]))
+		off += 2
+		if off+rhsLen > len(tower) {
+			return
+		}
+		rhs := tower[off : off+rhsLen]
+		off += rhsLen
+
+		if len(lhs) == 0 {
+			continue
+		}
+		switch {
+		case f == 0 && lhs[0] == 0x0d && len(lhs) >= 19:
+			e.UUID = formatRPCUUID(lhs[1:17])
+			e.Version = int(binary.LittleEndian.Uint16(lhs[17:]))
+		case lhs[0] == 0x07 && len(rhs) == 2:
+			e.Port = int(binary.BigEndian.Uint16(rhs))
+			e.Endpoint = "ncacn_ip_tcp:" + strconv.Itoa(e.Port)
+		case lhs[0] == 0x08 && len(rhs) == 2:
+			e.Port = int(binary.BigEndian.Uint16(rhs))
+			e.Endpoint = "ncadg_ip_udp:" + strconv.Itoa(e.Port)
+		case lhs[0] == 0x1f && len(rhs) == 2:
+			e.Port = int(binary.BigEndian.Uint16(rhs))
+			e.Endpoint = "ncacn_http:" + strconv.Itoa(e.Port)
+		case lhs[0] == 0x0f:
+			e.Endpoint = "ncacn_np:" + strings.TrimRight(string(rhs), "\x00")
+		case lhs[0] == 0x10:
+			e.Endpoint = "ncalrpc:" + strings.TrimRight(string(rhs), "\x00")
+		}
+	}
+}
+
+func rpcUUID(s string) []byte {
+	b, _ := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	// The first three fields are little-endian on the wire
+	b[0], b[1], b[2], b[3] = b[3], b[2], b[1], b[0]
+	b[4], b[5] = b[5], b[4]
+	b[6], b[7] = b[7], b[6]
+	return b
+}
+
+func formatRPCUUID(b []byte) string {
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x",
+		binary.LittleEndian.Uint32(b[0:]),
+		binary.LittleEndian.Uint16(b[4:]),
+		binary.LittleEndian.Uint16(b[6:]),
+		b[8:10], b[10:16])
+}
+
+func joinInts(list []int, sep string) string {
+	parts := make([]string, len(list))
+	for i, v := range list {
+		parts[i] = strconv.Itoa(v)
+	}
+	return strings.Join(parts, sep)
+}
+
+func allZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// ndrReader reads little-endian NDR data, remembering whether it ran short
+type ndrReader struct {
+	buf []byte
+	off int
+	bad bool
+}
+
+func (r *ndrReader) ok() bool { return !r.bad }
+
+func (r *ndrReader) bytes(n int) []byte {
+	if r.bad || n < 0 || r.off+n > len(r.buf) {
+		r.bad = true
+		return nil
+	}
+	b := r.buf[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *ndrReader) u32() uint32 {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (r *ndrReader) align(n int) {
+	if rem := r.off % n; rem != 0 {
+		r.off += n - rem
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// unhex decodes hex fixtures written in commented chunks
+func unhex(t *testing.T, parts ...string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(strings.Join(parts, ""), " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// eptLookupStub is the stub of an ept_lookup response from a Windows domain
+// controller, trimmed to two entries: DRSUAPI on a dynamic TCP port and LSARPC
+// on a named pipe
+func eptLookupStub(t *testing.T) []byte {
+	return unhex(t,
+		"00000000 5c3a8f41 2e9b6d4c 8a01f3c7 b5d2e640", // context handle
+		"02000000",                   // num_ents
+		"f4010000 00000000 02000000", // max count, offset, actual count
+		// entry 1: nil object, tower pointer, annotation
+		"00000000000000000000000000000000", "01000200",
+		"00000000 1e000000 4d53204e54204469726563746f72792044525320496e7465726661636500 0000",
+		// entry 2: empty annotation
+		"00000000000000000000000000000000", "02000200",
+		"00000000 01000000 00 000000",
+		// tower 1: DRSUAPI v4, NDR, RPC-CO, TCP 49667, IP 10.0.0.5
+		"4b000000 4b000000",
+		"0500 1300 0d354251e3064bd111ab0400c04fc2dcd20400 0200 0000",
+		"1300 0d045d888aeb1cc9119fe808002b1048600200 0200 0000",
+		"0100 0b 0200 0000 0100 07 0200 c203 0100 09 0400 0a000005 00",
+		// tower 2: LSARPC v0, NDR, RPC-CO, named pipe \PIPE\lsass, NetBIOS \\DC01
+		"58000000 58000000",
+		"0500 1300 0d785734123412cdabef000123456789ab0000 0200 0000",
+		"1300 0d045d888aeb1cc9119fe808002b1048600200 0200 0000",
+		"0100 0b 0200 0000 0100 0f 0c00 5c504950455c6c7361737300 0100 11 0700 5c5c4443303100",
+		"00000000", // status
+	)
+}
+
+func TestParseEPTLookup(t *testing.T) {
+	stub := eptLookupStub(t)
+	tests := []struct {
+		name       string
+		stub       []byte
+		want       []eptEntry
+		wantHandle string
+	}{
+		{
+			name: "domain controller",
+			stub: stub,
+			want: []eptEntry{
+				{UUID: "e3514235-4b06-11d1-ab04-00c04fc2dcd2", Version: 4, Endpoint: "ncacn_ip_tcp:49667", Port: 49667, Annotation: "MS NT Directory DRS Interface"},
+				{UUID: "12345778-1234-abcd-ef00-0123456789ab", Endpoint: `ncacn_np:\PIPE\lsass`},
+			},
+			wantHandle: "000000005c3a8f412e9b6d4c8a01f3c7b5d2e640",
+		},
+		{
+			name:       "end of the list",
+			stub:       unhex(t, "00000000000000000000000000000000 00000000", "00000000", "f4010000 00000000 00000000", "16c9a0d6"),
+			want:       []eptEntry{},
+			wantHandle: "0000000000000000000000000000000000000000",
+		},
+		{name: "truncated in a tower", stub: stub[:250]},
+		{name: "truncated in an entry", stub: stub[:70]},
+		{name: "count larger than the data", stub: unhex(t, "0000000000000000000000000000000000000000", "ffffffff", "ffffffff 00000000 ffffffff")},
+		{name: "empty", stub: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, handle := parseEPTLookup(tt.stub)
+			if !reflect.DeepEqual(entries, tt.want) {
+				t.Errorf("entries = %+v, want %+v", entries, tt.want)
+			}
+			if got := hex.EncodeToString(handle); got != tt.wantHandle {
+				t.Errorf("handle = %s, want %s", got, tt.wantHandle)
+			}
+		})
+	}
+}
+
+func TestParseTower(t *testing.T) {
+	iface := "1300 0d785734123412cdabef000123456789ab0100 0200 0000"
+	tests := []struct {
+		name  string
+		tower []byte
+		want  eptEntry
+	}{
+		{
+			name:  "udp",
+			tower: unhex(t, "0200", iface, "0100 08 0200 0087"),
+			want:  eptEntry{UUID: "12345778-1234-abcd-ef00-0123456789ab", Version: 1, Endpoint: "ncadg_ip_udp:135", Port: 135},
+		},
+		{
+			name:  "rpc over http",
+			tower: unhex(t, "0200", iface, "0100 1f 0200 c210"),
+			want:  eptEntry{UUID: "12345778-1234-abcd-ef00-0123456789ab", Version: 1, Endpoint: "ncacn_http:49680", Port: 49680},
+		},
+		{
+			name:  "local rpc",
+			tower: unhex(t, "0200", iface, "0100 10 0a00 4c52504335343231000000"),
+			want:  eptEntry{UUID: "12345778-1234-abcd-ef00-0123456789ab", Version: 1, Endpoint: "ncalrpc:LRPC5421"},
+		},
+		{
+			name:  "floor cut off",
+			tower: unhex(t, "0200", iface, "0100 07 0200 c2"),
+			want:  eptEntry{UUID: "12345778-1234-abcd-ef00-0123456789ab", Version: 1},
+		},
+		{
+			name:  "more floors than data",
+			tower: unhex(t, "0900", iface),
+			want:  eptEntry{UUID: "12345778-1234-abcd-ef00-0123456789ab", Version: 1},
+		},
+		{
+			name:  "short interface floor",
+			tower: unhex(t, "0100 0300 0d7857 0000"),
+		},
+		{name: "empty", tower: unhex(t, "00")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got eptEntry
+			parseTower(tt.tower, &got)
+			if got != tt.want {
+				t.Errorf("parseTower = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProbeResult records what an application-level probe learned about a port
+type ProbeResult struct {
+	Probe   string            `json:"probe"`
+	Service string            `json:"service,omitempty"`
+	Vendor  string            `json:"vendor,omitempty"`
+	Version string            `json:"version,omitempty"`
+	Info    map[string]string `json:"info,omitempty"`
+	Items   []string          `json:"items,omitempty"`
+	Device  *DeviceIdentity   `json:"device,omitempty"`
+
+	// Findings are moved onto the port result once the probe has run
+	Findings []Finding `json:"-"`
+}
+
+// DeviceIdentity describes the physical device behind a host, as reported by
+// discovery protocols that announce it
+type DeviceIdentity struct {
+	Manufacturer string   `json:"manufacturer,omitempty"`
+	Model        string   `json:"model,omitempty"`
+	Serial       string   `json:"serial,omitempty"`
+	Name         string   `json:"name,omitempty"`
+	Firmware     string   `json:"firmware,omitempty"`
+	MAC          string   `json:"mac,omitempty"`
+	Sources      []string `json:"sources,omitempty"`
+}
+
+// merge fills fields still unknown from another identity
+func (d *DeviceIdentity) merge(other *DeviceIdentity, source string) {
+	fill := func(dst *string, src string) {
+		if *dst == "" {
+			*dst = src
+		}
+	}
+	fill(&d.Manufacturer, other.Manufacturer)
+	fill(&d.Model, other.Model)
+	fill(&d.Serial, other.Serial)
+	fill(&d.Name, other.Name)
+	fill(&d.Firmware, other.Firmware)
+	fill(&d.MAC, other.MAC)
+	d.Sources = append(d.Sources, source)
+}
+
+func (d *DeviceIdentity) String() string {
+	var parts []string
+	for _, s := range []string{d.Manufacturer, d.Model, d.Name} {
+		if s != "" {
+			parts = append(parts, s)
+		}
+	}
+	if d.Firmware != "" {
+		parts = append(parts, "firmware "+d.Firmware)
+	}
+	if d.Serial != "" {
+		parts = append(parts, "serial "+d.Serial)
+	}
+	if d.MAC != "" {
+		parts = append(parts, "mac "+d.MAC)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// probe is a protocol-specific check run against well-known ports. TCP probes
+// only run on ports found open; UDP probes are sent to their ports directly
+// since UDP has no handshake to find them open first.
+type probe struct {
+	Name  string
+	Group string
+	Proto string
+	Ports []int
+	Run   func(host string, port int, timeout time.Duration) *ProbeResult
+}
+
+var probes []probe
+
+func registerProbes(list ...probe) {
+	probes = append(probes, list...)
+}
+
+func probeGroupNames() []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, p := range probes {
+		if !seen[p.Group] {
+			seen[p.Group] = true
+			names = append(names, p.Group)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// selectProbes returns the probes of the comma-separated groups, or all of them
+func selectProbes(groups string) ([]probe, error) {
+	if groups == "" {
+		return nil, nil
+	}
+	want := map[string]bool{}
+	for _, g := range strings.Split(groups, ",") {
+		want[strings.TrimSpace(g)] = true
+	}
+
+	var selected []probe
+	known := map[string]bool{"all": true}
+	for _, p := range probes {
+		known[p.Group] = true
+		if want["all"] || want[p.Group] {
+			selected = append(selected, p)
+		}
+	}
+	for g := range want {
+		if !known[g] {
+			return nil, fmt.Errorf("unknown probe group %q (available: %s)", g, strings.Join(probeGroupNames(), ", "))
+		}
+	}
+	return selected, nil
+}
+
+// runProbes runs the selected probes against a scanned host, attaching their
+// results to the matching ports and adding any UDP ports that answered
+func runProbes(summary *ScanSummary, selected []probe, workers int, timeout time.Duration) {
+	type job struct {
+		probe probe
+		port  int
+	}
+
+	open := map[int]bool{}
+	for _, res := range summary.Ports {
+		if res.proto() == "tcp" {
+			open[res.Port] = true
+		}
+	}
+
+	var jobs []job
+	for _, p := range selected {
+		for _, port := range p.Ports {
+			if p.Proto == "udp" || open[port] {
+				jobs = append(jobs, job{p, port})
+			}
+		}
+	}
+
+	// Transcripts attribute a connection to the probe running against its
+	// port, so while they are recorded the probes of a port run in turn
+	var batches [][]job
+	if transcripts != nil {
+		batchOf := map[string]int{}
+		for _, j := range jobs {
+			key := fmt.Sprintf("%d/%s", j.port, j.probe.Proto)
+			i, ok := batchOf[key]
+			if !ok {
+				i = len(batches)
+				batchOf[key] = i
+				batches = append(batches, nil)
+			}
+			batches[i] = append(batches[i], j)
+		}
+	} else {
+		for _, j := range jobs {
+			batches = append(batches, []job{j})
+		}
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, workers)
+	record := func(j job, result *ProbeResult) {
+		result.Probe = j.probe.Name
+		for i := range result.Findings {
+			result.Findings[i].Source = j.probe.Name
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		res := summary.port(j.probe.Proto, j.port)
+		res.Findings = append(res.Findings, result.Findings...)
+		result.Findings = nil
+		res.Probes = append(res.Probes, *result)
+		if result.Device != nil {
+			if summary.Device == nil {
+				summary.Device = &DeviceIdentity{}
+			}
+			summary.Device.merge(result.Device, j.probe.Name)
+		}
+	}
+	for _, batch := range batches {
+		wg.Add(1)
+		go func(batch []job) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			for _, j := range batch {
+				transcripts.setProbe(summary.Target, j.probe.Proto, j.port, j.probe.Name)
+				if result := j.probe.Run(summary.Target, j.port, timeout); result != nil {
+					record(j, result)
+				}
+				transcripts.setProbe(summary.Target, j.probe.Proto, j.port, "")
+			}
+		}(batch)
+	}
+	wg.Wait()
+
+	sort.Slice(summary.Ports, func(i, j int) bool {
+		a, b := summary.Ports[i], summary.Ports[j]
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		return a.proto() < b.proto()
+	})
+}
+
+// port returns the result for a port, adding an open entry if there is none
+func (s *ScanSummary) port(proto string, port int) *ScanResult {
+	for i := range s.Ports {
+		if s.Ports[i].Port == port && s.Ports[i].proto() == proto {
+			return &s.Ports[i]
+		}
+	}
+	s.Ports = append(s.Ports, ScanResult{Port: port, Protocol: proto, State: "open"})
+	s.OpenPorts++
+	return &s.Ports[len(s.Ports)-1]
+}
+
+func describeProbe(p ProbeResult) string {
+	parts := []string{"[" + p.Probe + "]"}
+	if p.Service != "" {
+		parts = append(parts, p.Service)
+	}
+	if p.Vendor != "" {
+		parts = append(parts, "vendor="+p.Vendor)
+	}
+	if p.Version != "" {
+		parts = append(parts, "version="+p.Version)
+	}
+	keys := make([]string, 0, len(p.Info))
+	for k := range p.Info {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		parts = append(parts, k+"="+p.Info[k])
+	}
+	if len(p.Items) > 0 {
+		parts = append(parts, "items: "+strings.Join(p.Items, ", "))
+	}
+	return strings.Join(parts, " ")
+}
+
+/* Probe Transport Helpers */
+
+var errUDPOverJump = errors.New("UDP cannot be sent through an SSH jump host")
+
+// udpExchange sends one datagram and waits for a single reply from the host.
+// Replies are accepted from any source port since discovery protocols such as
+// SSDP often answer from an ephemeral one.
+func udpExchange(host string, port int, payload []byte, timeout time.Duration) ([]byte, error) {
+	if jumpHost != nil {
+		return nil, errUDPOverJump
+	}
+	addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if bandwidth != nil {
+		bandwidth.send(len(payload))
+	}
+	deadline := time.Now().Add(timeout)
+	conn.SetDeadline(deadline)
+	if _, err := conn.WriteToUDP(payload, addr); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			transcripts.recordUDP(host, port, payload, nil)
+			return nil, err
+		}
+		if bandwidth != nil {
+			// Waiting for the limiter does not count against the timeout
+			deadline = deadline.Add(bandwidth.receive(n))
+			conn.SetDeadline(deadline)
+		}
+		if from.IP.Equal(addr.IP) {
+			transcripts.recordUDP(host, port, payload, buf[:n])
+			return buf[:n], nil
+		}
+	}
+}
+
+// tcpExchange connects, optionally writes a request, and reads what comes back
+// until the peer stops sending, the buffer limit is hit or the timeout expires
+func tcpExchange(host string, port int, payload []byte, limit int, timeout time.Duration) ([]byte, error) {
+	conn, err := dialPort(host, port, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	if len(payload) > 0 {
+		if _, err := conn.Write(payload); err != nil {
+			return nil, err
+		}
+	}
+	data, err := io.ReadAll(io.LimitReader(conn, int64(limit)))
+	if len(data) > 0 {
+		return data, nil
+	}
+	return nil, err
+}
+
+// probeHTTPClient is an HTTP client whose connections go through dialPort and
+// that accepts any certificate, since scanned services rarely have valid ones
+func probeHTTPClient(timeout time.Duration) *http.Client {
+	dial := func(_ context.Context, _, addr string) (net.Conn, error) {
+		host, portStr, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		port, _ := strconv.Atoi(portStr)
+		conn, err := dialPort(host, port, timeout)
+		if err == nil && bandwidth != nil {
+			conn.SetDeadline(time.Now().Add(timeout))
+		}
+		return conn, err
+	}
+	// TLS is set up here rather than by the transport so that transcripts
+	// record the plaintext of HTTPS requests too
+	dialTLS := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		host, portStr, _ := net.SplitHostPort(addr)
+		port, _ := strconv.Atoi(portStr)
+		cfg := &tls.Config{InsecureSkipVerify: true}
+		if net.ParseIP(host) == nil {
+			cfg.ServerName = host
+		}
+		tc := tls.Client(conn, cfg)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return transcripts.recordDecrypted(tc, host, port), nil
+	}
+	// Under -max-bandwidth the deadline of the connection, which waiting for
+	// the limiter pushes back, bounds a request instead of a fixed timeout
+	clientTimeout := timeout
+	if bandwidth != nil {
+		clientTimeout = 0
+	}
+	return &http.Client{
+		Timeout: clientTimeout,
+		Transport: &http.Transport{
+			DialContext:       dial,
+			DialTLSContext:    dialTLS,
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// probeURL builds the URL of a path on a probed port
+func probeURL(host string, port int, path string, useTLS bool) string {
+	scheme := "http"
+	if useTLS {
+		scheme = "https"
+	}
+	return scheme + "://" + net.JoinHostPort(host, strconv.Itoa(port)) + path
+}
+
+// fetch performs a GET and returns the status, headers and up to limit bytes of body
+func fetch(client *http.Client, url string, limit int64) (*http.Response, []byte, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit))
+	return resp, body, err
+}
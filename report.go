@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// runReport implements the report subcommand, which re-renders saved JSON
+// results in any output format without scanning again
+func runReport(args []string) error {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	format := fs.String("format", "text", "Output format: "+strings.Join(outputFormatNames(), ", "))
+	templateFile := fs.String("template", "", "Go template file used with -format template")
+	hostPattern := fs.String("host", "", "Only include targets matching this glob pattern")
+	portsList := fs.String("ports", "", "Only include these comma-separated ports")
+	grep := fs.String("grep", "", "Only include ports whose banner contains this text")
+	sortBy := fs.String("sort", "target", "Sort hosts by: target, open-ports, duration, risk")
+	guidanceFile := fs.String("guidance", "", "Re-attach remediation guidance using this override file")
+	ownersFile := fs.String("owners", "", "Re-assign owners using this ownership file")
+	ownerFilter := fs.String("owner", "", "Only include hosts owned by this team")
+	suppressionsFile := fs.String("suppressions", "", "Re-apply finding suppressions from this file")
+	splitOwners := fs.String("split-owners", "", "Write one report per owner into this directory instead of stdout")
+	correlate := fs.Bool("correlate", false, "Group hosts across all result files by their saved host identifiers")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: portscanner report [flags] results.json...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no result files given")
+	}
+
+	out, err := newOutputWriter(*format, *templateFile)
+	if err != nil {
+		return err
+	}
+
+	var summaries []ScanSummary
+	for _, file := range fs.Args() {
+		loaded, err := loadResults(file)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		summaries = append(summaries, loaded...)
+	}
+	// Results saved before fingerprints were recorded get them too
+	for i := range summaries {
+		fingerprintFindings(&summaries[i])
+	}
+
+	if *guidanceFile != "" {
+		kb, err := loadKnowledgeBase(*guidanceFile)
+		if err != nil {
+			return err
+		}
+		for i := range summaries {
+			kb.Annotate(&summaries[i])
+		}
+	}
+
+	if *ownersFile != "" {
+		owners, err := loadOwners(*ownersFile)
+		if err != nil {
+			return err
+		}
+		out.Owners = true
+		for i := range summaries {
+			summaries[i].Owner = nil
+			if rule := owners.match(summaries[i]); rule != nil {
+				owner := rule.Owner
+				summaries[i].Owner = &owner
+			}
+		}
+	}
+	if *suppressionsFile != "" {
+		suppressions, err := loadSuppressions(*suppressionsFile)
+		if err != nil {
+			return err
+		}
+		for i := range summaries {
+			warnExpired(suppressions.Apply(&summaries[i], time.Now()))
+		}
+	}
+	if *correlate {
+		correlateHosts(summaries)
+	}
+	if *ownerFilter != "" {
+		var owned []ScanSummary
+		for _, summary := range summaries {
+			if ownerGroup(summary) == *ownerFilter {
+				owned = append(owned, summary)
+			}
+		}
+		summaries = owned
+	}
+
+	summaries, err = filterSummaries(summaries, *hostPattern, *portsList, *grep)
+	if err != nil {
+		return err
+	}
+	if err := sortSummaries(summaries, *sortBy); err != nil {
+		return err
+	}
+	if *splitOwners != "" {
+		return writeOwnerReports(out, summaries, *splitOwners)
+	}
+	return out.Write(os.Stdout, summaries)
+}
+
+// loadResults reads a file written by -json: one or more summaries, either
+// concatenated or wrapped in an array
+func loadResults(file string) ([]ScanSummary, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var summaries []ScanSummary
+	dec := json.NewDecoder(f)
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err == io.EOF {
+			return summaries, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		if bytes.HasPrefix(bytes.TrimSpace(raw), []byte("[")) {
+			var list []ScanSummary
+			if err := json.Unmarshal(raw, &list); err != nil {
+				return nil, err
+			}
+			summaries = append(summaries, list...)
+			continue
+		}
+		var summary ScanSummary
+		if err := json.Unmarshal(raw, &summary); err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, summary)
+	}
+}
+
+func filterSummaries(summaries []ScanSummary, hostPattern, portList, grep string) ([]ScanSummary, error) {
+	var wantPorts map[int]bool
+	if portList != "" {
+		wantPorts = make(map[int]bool)
+		for _, p := range parsePorts(portList, 0, 0) {
+			wantPorts[p] = true
+		}
+	}
+
+	var filtered []ScanSummary
+	for _, summary := range summaries {
+		if hostPattern != "" {
+			ok, err := path.Match(hostPattern, summary.Target)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		if wantPorts != nil || grep != "" {
+			var ports []ScanResult
+			for _, res := range summary.Ports {
+				if wantPorts != nil && !wantPorts[res.Port] {
+					continue
+				}
+				if grep != "" && !strings.Contains(res.Banner, grep) {
+					continue
+				}
+				ports = append(ports, res)
+			}
+			summary.Ports = ports
+			summary.OpenPorts = len(ports)
+		}
+		filtered = append(filtered, summary)
+	}
+	return filtered, nil
+}
+
+func sortSummaries(summaries []ScanSummary, key string) error {
+	var less func(a, b ScanSummary) bool
+	switch key {
+	case "target":
+		less = func(a, b ScanSummary) bool { return a.Target < b.Target }
+	case "open-ports":
+		less = func(a, b ScanSummary) bool { return a.OpenPorts > b.OpenPorts }
+	case "duration":
+		less = func(a, b ScanSummary) bool { return a.TimeTaken > b.TimeTaken }
+	case "risk":
+		less = func(a, b ScanSummary) bool { return a.RiskScore > b.RiskScore }
+	default:
+		return fmt.Errorf("unknown sort key %q", key)
+	}
+
+	sort.SliceStable(summaries, func(i, j int) bool { return less(summaries[i], summaries[j]) })
+	for _, summary := range summaries {
+		sort.Slice(summary.Ports, func(i, j int) bool { return summary.Ports[i].Port < summary.Ports[j].Port })
+	}
+	return nil
+}
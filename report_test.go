@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeResults saves summaries the way -json does, one object after another
+func writeResults(t *testing.T, summaries []ScanSummary) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "results.json")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := writeJSON(f, summaries); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestLoadResults(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []string
+		wantErr bool
+	}{
+		{"concatenated", `{"target":"a"}` + "\n" + `{"target":"b"}`, []string{"a", "b"}, false},
+		{"array", `[{"target":"a"},{"target":"b"}]`, []string{"a", "b"}, false},
+		{"mixed", `[{"target":"a"}] {"target":"b"}`, []string{"a", "b"}, false},
+		{"empty", "", nil, false},
+		{"truncated", `{"target":"a"}{"target":`, nil, true},
+		{"wrong type", `{"target":1}`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "results.json")
+			if err := os.WriteFile(file, []byte(tt.data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			summaries, err := loadResults(file)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var targets []string
+			for _, s := range summaries {
+				targets = append(targets, s.Target)
+			}
+			if !reflect.DeepEqual(targets, tt.want) {
+				t.Errorf("targets = %q, want %q", targets, tt.want)
+			}
+		})
+	}
+
+	// What -json writes loads back unchanged
+	want := reportFixture()
+	got, err := loadResults(writeResults(t, want))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		gotJSON, _ := json.Marshal(got)
+		t.Errorf("round trip changed the results: %s", gotJSON)
+	}
+}
+
+func TestFilterSummaries(t *testing.T) {
+	tests := []struct {
+		name    string
+		host    string
+		ports   string
+		grep    string
+		want    map[string][]int
+		wantErr bool
+	}{
+		{name: "no filters", want: map[string][]int{"10.0.0.2": {22, 443, 161}, "10.0.0.1": {111}}},
+		{name: "host glob", host: "10.0.0.1", want: map[string][]int{"10.0.0.1": {111}}},
+		{name: "ports", ports: "22,111", want: map[string][]int{"10.0.0.2": {22}, "10.0.0.1": {111}}},
+		{name: "invalid ports ignored", ports: "ssh, 161,70000", want: map[string][]int{"10.0.0.2": {161}, "10.0.0.1": nil}},
+		{name: "banner", grep: "OpenSSH", want: map[string][]int{"10.0.0.2": {22}, "10.0.0.1": nil}},
+		{name: "host and ports", host: "10.0.0.[2-9]", ports: "443", want: map[string][]int{"10.0.0.2": {443}}},
+		{name: "bad glob", host: "10.0.0.[", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filtered, err := filterSummaries(reportFixture(), tt.host, tt.ports, tt.grep)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string][]int{}
+			for _, s := range filtered {
+				var ports []int
+				for _, res := range s.Ports {
+					ports = append(ports, res.Port)
+				}
+				got[s.Target] = ports
+				if s.OpenPorts != len(s.Ports) && (tt.ports != "" || tt.grep != "") {
+					t.Errorf("%s: open ports = %d after filtering to %d", s.Target, s.OpenPorts, len(s.Ports))
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("filtered = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortSummaries(t *testing.T) {
+	hosts := func() []ScanSummary {
+		return []ScanSummary{
+			{Target: "10.0.0.3", OpenPorts: 1, TimeTaken: 3, RiskScore: 2},
+			{Target: "10.0.0.1", OpenPorts: 5, TimeTaken: 1, RiskScore: 9.5},
+			{Target: "10.0.0.2", OpenPorts: 2, TimeTaken: 2, RiskScore: 2, Ports: []ScanResult{{Port: 443}, {Port: 22}, {Port: 80}}},
+		}
+	}
+	tests := []struct {
+		key  string
+		want []string
+	}{
+		{"target", []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+		{"open-ports", []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+		{"duration", []string{"10.0.0.3", "10.0.0.2", "10.0.0.1"}},
+		// Ties keep their input order
+		{"risk", []string{"10.0.0.1", "10.0.0.3", "10.0.0.2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			summaries := hosts()
+			if err := sortSummaries(summaries, tt.key); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			var ports []int
+			for _, s := range summaries {
+				got = append(got, s.Target)
+				for _, res := range s.Ports {
+					ports = append(ports, res.Port)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("order = %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(ports, []int{22, 80, 443}) {
+				t.Errorf("ports = %v, want them sorted", ports)
+			}
+		})
+	}
+	if err := sortSummaries(hosts(), "owner"); err == nil {
+		t.Error("unknown sort key accepted")
+	}
+}
+
+func TestReportSplitOwners(t *testing.T) {
+	results := writeResults(t, reportFixture())
+	dir := t.TempDir()
+	err := runReport([]string{"-format", "csv", "-ports", "22,111,161", "-sort", "risk", "-split-owners", dir, results})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"web.csv", "unowned.csv"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkGolden(t, "report-"+name, data)
+	}
+}
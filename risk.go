@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Severity levels, from least to most severe
+var severityOrder = []string{"info", "low", "medium", "high", "critical"}
+
+// RiskModel holds the weights used to score findings, on a 0-10 scale. Port
+// keys are "22" or "22/tcp", service keys are probe names, and exposure
+// values multiply every score of a host carrying that exposure label.
+type RiskModel struct {
+	DefaultPort    float64            `json:"default_port"`
+	Ports          map[string]float64 `json:"ports"`
+	Services       map[string]float64 `json:"services"`
+	DefaultFinding float64            `json:"default_finding"`
+	Findings       map[string]float64 `json:"findings"`
+	DefaultCVE     float64            `json:"default_cve"`
+	CVEs           map[string]float64 `json:"cves"`
+	Exposure       map[string]float64 `json:"exposure"`
+	Severities     map[string]float64 `json:"severity_thresholds"`
+}
+
+var cvePattern = regexp.MustCompile(`^CVE-\d{4}-\d+$`)
+
+func defaultRiskModel() *RiskModel {
+	return &RiskModel{
+		DefaultPort: 2,
+		Ports: map[string]float64{
+			"21": 5, "22": 3, "23": 8, "25": 3, "53": 3, "80": 2, "88": 3,
+			"110": 4, "111": 4, "135": 4, "139": 5, "143": 4, "161/udp": 5,
+			"389": 5, "443": 2, "445": 7, "873": 5, "1433": 5, "1521": 5,
+			"2049": 5, "2375": 9, "3306": 5, "3389": 7, "5432": 5, "5900": 7,
+			"5985": 5, "5986": 4, "6379": 8, "9200": 7, "11211": 6, "27017": 7,
+		},
+		Services: map[string]float64{
+			"ike": 4, "openvpn": 4, "sstp": 4, "sslvpn": 6,
+			"coap": 5, "upnp": 5, "ubiquiti": 5,
+			"kerberos": 4, "winrm": 6, "msrpc": 4,
+			"mssql": 5, "mssql-browser": 4, "oracle-tns": 5,
+			"portmapper": 4, "nfs": 6, "rsync": 5,
+			"openapi": 3, "graphql": 4, "grpc-web": 3, "actuator": 5,
+		},
+		DefaultFinding: 5,
+		Findings: map[string]float64{
+			FindingLoadBalanced:         1,
+			FindingWinRMBasicHTTP:       7,
+			FindingMSSQLNoEncryption:    5,
+			FindingNFSWorldExport:       9,
+			FindingRsyncAnonymousModule: 8,
+			FindingGraphQLIntrospection: 5,
+			FindingActuatorSensitive:    8,
+			FindingUndeclaredPort:       4,
+		},
+		DefaultCVE: 7,
+		CVEs:       map[string]float64{},
+		Exposure:   map[string]float64{"external": 1.3, "internal": 1.0},
+		Severities: map[string]float64{"critical": 9, "high": 7, "medium": 4, "low": 1},
+	}
+}
+
+// loadRiskModel reads a JSON weights file over the defaults, so a file only
+// needs the weights it changes
+func loadRiskModel(path string) (*RiskModel, error) {
+	model := defaultRiskModel()
+	if path == "" {
+		return model, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, model); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for name := range model.Severities {
+		if severityRank(name) < 0 {
+			return nil, fmt.Errorf("%s: unknown severity %q", path, name)
+		}
+	}
+	return model, nil
+}
+
+// Score assigns a severity to every open port and finding of a host and
+// combines them into the host's risk score
+func (m *RiskModel) Score(summary *ScanSummary) {
+	multiplier := 1.0
+	if v, ok := m.Exposure[summary.Labels[LabelExposure]]; ok {
+		multiplier = v
+	}
+
+	var scores []float64
+	for i := range summary.Ports {
+		res := &summary.Ports[i]
+		res.Score = m.clamp(m.portWeight(*res) * multiplier)
+		res.Severity = m.severity(res.Score)
+		scores = append(scores, res.Score)
+
+		for j := range res.Findings {
+			f := &res.Findings[j]
+			f.Score = m.clamp(m.findingWeight(*f) * multiplier)
+			f.Severity = m.severity(f.Score)
+			scores = append(scores, f.Score)
+		}
+	}
+
+	summary.RiskScore = combineScores(scores)
+	summary.RiskSeverity = m.severity(summary.RiskScore)
+}
+
+func (m *RiskModel) portWeight(res ScanResult) float64 {
+	weight, ok := m.Ports[strconv.Itoa(res.Port)+"/"+res.proto()]
+	if !ok {
+		weight, ok = m.Ports[strconv.Itoa(res.Port)]
+	}
+	if !ok {
+		weight = m.DefaultPort
+	}
+	for _, p := range res.Probes {
+		if w, ok := m.Services[p.Probe]; ok && w > weight {
+			weight = w
+		}
+	}
+	return weight
+}
+
+// findingWeight weighs a finding by its type, or as a CVE when its type is a
+// CVE id. CVEs attached to the finding raise the weight to the worst of them.
+func (m *RiskModel) findingWeight(f Finding) float64 {
+	var weight float64
+	if cvePattern.MatchString(f.Type) {
+		weight = m.cveWeight(f.Type)
+	} else if w, ok := m.Findings[f.Type]; ok {
+		weight = w
+	} else {
+		weight = m.DefaultFinding
+	}
+	for _, id := range f.CVEs {
+		if w := m.cveWeight(id); w > weight {
+			weight = w
+		}
+	}
+	return weight
+}
+
+func (m *RiskModel) cveWeight(id string) float64 {
+	if w, ok := m.CVEs[strings.ToUpper(id)]; ok {
+		return w
+	}
+	return m.DefaultCVE
+}
+
+func (m *RiskModel) clamp(score float64) float64 {
+	return math.Round(math.Min(math.Max(score, 0), 10)*10) / 10
+}
+
+// severity maps a score to the highest severity whose threshold it reaches
+func (m *RiskModel) severity(score float64) string {
+	best, bestRank := "info", 0
+	for name, threshold := range m.Severities {
+		if rank := severityRank(name); score >= threshold && rank > bestRank {
+			best, bestRank = name, rank
+		}
+	}
+	return best
+}
+
+// combineScores treats each score as an independent chance of compromise out
+// of ten, so many medium findings add up while a single critical dominates
+func combineScores(scores []float64) float64 {
+	safe := 1.0
+	for _, s := range scores {
+		safe *= 1 - s/10
+	}
+	return math.Round((1-safe)*100) / 10
+}
+
+func severityRank(name string) int {
+	for i, s := range severityOrder {
+		if s == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// sortByRisk orders hosts from the riskiest down
+func sortByRisk(summaries []ScanSummary) {
+	sort.SliceStable(summaries, func(i, j int) bool {
+		return summaries[i].RiskScore > summaries[j].RiskScore
+	})
+}
+
+// exceedsSeverity reports whether any port or finding that is not accepted
+// reaches the severity
+func exceedsSeverity(summaries []ScanSummary, severity string) bool {
+	limit := severityRank(severity)
+	for _, summary := range summaries {
+		for _, f := range collectFindings(summary) {
+			if f.Finding.Accepted == nil && severityRank(f.Finding.Severity) >= limit {
+				return true
+			}
+		}
+	}
+	return false
+}
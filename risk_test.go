@@ -0,0 +1,214 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRiskScore(t *testing.T) {
+	model := defaultRiskModel()
+	model.CVEs["CVE-2022-0543"] = 10
+
+	summary := ScanSummary{
+		Target: "10.0.0.5",
+		Labels: map[string]string{LabelExposure: "internal"},
+		Ports: []ScanResult{
+			{Port: 22, Protocol: "tcp", State: "open"},
+			{Port: 161, Protocol: "udp", State: "open"},
+			{
+				Port: 8080, Protocol: "tcp", State: "open",
+				Probes: []ProbeResult{{Probe: "actuator"}},
+				Findings: []Finding{
+					{Type: FindingActuatorSensitive, CVEs: []string{"cve-2022-22965"}},
+					{Type: FindingLoadBalanced, CVEs: []string{"CVE-2021-44228"}},
+					{Type: "made-up"},
+				},
+			},
+			{
+				Port: 6379, Protocol: "tcp", State: "open",
+				Findings: []Finding{{Type: "CVE-2022-0543"}, {Type: "CVE-2023-28856"}},
+			},
+		},
+	}
+	model.Score(&summary)
+
+	type scored struct {
+		Score    float64
+		Severity string
+	}
+	var ports, findings []scored
+	for _, res := range summary.Ports {
+		ports = append(ports, scored{res.Score, res.Severity})
+		for _, f := range res.Findings {
+			findings = append(findings, scored{f.Score, f.Severity})
+		}
+	}
+	wantPorts := []scored{
+		{3, "low"},    // 22
+		{5, "medium"}, // 161/udp
+		{5, "medium"}, // default port raised by the actuator probe
+		{8, "high"},   // 6379
+	}
+	wantFindings := []scored{
+		{8, "high"},      // finding weight above the default CVE weight
+		{7, "high"},      // attached CVE above the finding weight
+		{5, "medium"},    // default finding weight
+		{10, "critical"}, // configured CVE
+		{7, "high"},      // default CVE weight
+	}
+	if !reflect.DeepEqual(ports, wantPorts) {
+		t.Errorf("port scores = %v, want %v", ports, wantPorts)
+	}
+	if !reflect.DeepEqual(findings, wantFindings) {
+		t.Errorf("finding scores = %v, want %v", findings, wantFindings)
+	}
+	if summary.RiskScore != 10 || summary.RiskSeverity != "critical" {
+		t.Errorf("host risk = %.1f %s, want 10.0 critical", summary.RiskScore, summary.RiskSeverity)
+	}
+}
+
+func TestRiskScoreExposure(t *testing.T) {
+	tests := []struct {
+		exposure string
+		want     float64
+	}{
+		{"external", 3.9},
+		{"internal", 3},
+		{"", 3},
+		{"dmz", 3},
+	}
+	for _, tt := range tests {
+		summary := ScanSummary{
+			Labels: map[string]string{LabelExposure: tt.exposure},
+			Ports:  []ScanResult{{Port: 22, Protocol: "tcp", State: "open"}},
+		}
+		defaultRiskModel().Score(&summary)
+		if got := summary.Ports[0].Score; got != tt.want || summary.RiskScore != tt.want {
+			t.Errorf("exposure %q: port score %.1f, host %.1f, want %.1f", tt.exposure, got, summary.RiskScore, tt.want)
+		}
+	}
+
+	// Scores stay on the 0-10 scale
+	summary := ScanSummary{
+		Labels: map[string]string{LabelExposure: "external"},
+		Ports:  []ScanResult{{Port: 2375, Protocol: "tcp", State: "open"}},
+	}
+	defaultRiskModel().Score(&summary)
+	if got := summary.Ports[0].Score; got != 10 {
+		t.Errorf("docker API on an external host scored %.1f, want 10", got)
+	}
+}
+
+func TestCombineScores(t *testing.T) {
+	tests := []struct {
+		scores []float64
+		want   float64
+	}{
+		{nil, 0},
+		{[]float64{0}, 0},
+		{[]float64{5}, 5},
+		{[]float64{5, 5}, 7.5},
+		{[]float64{3, 2}, 4.4},
+		{[]float64{4, 4, 4, 4}, 8.7},
+		{[]float64{10, 1}, 10},
+		{[]float64{9, 2, 2}, 9.4},
+	}
+	for _, tt := range tests {
+		if got := combineScores(tt.scores); got != tt.want {
+			t.Errorf("combineScores(%v) = %.1f, want %.1f", tt.scores, got, tt.want)
+		}
+	}
+}
+
+func TestSeverity(t *testing.T) {
+	model := defaultRiskModel()
+	tests := []struct {
+		score float64
+		want  string
+	}{
+		{0, "info"},
+		{0.9, "info"},
+		{1, "low"},
+		{3.9, "low"},
+		{4, "medium"},
+		{6.9, "medium"},
+		{7, "high"},
+		{8.9, "high"},
+		{9, "critical"},
+		{10, "critical"},
+	}
+	for _, tt := range tests {
+		if got := model.severity(tt.score); got != tt.want {
+			t.Errorf("severity(%.1f) = %s, want %s", tt.score, got, tt.want)
+		}
+	}
+
+	// A threshold file may leave severities out; scores fall to the next lower one
+	model.Severities = map[string]float64{"high": 5, "low": 2}
+	for score, want := range map[float64]string{1: "info", 4: "low", 9.5: "high"} {
+		if got := model.severity(score); got != want {
+			t.Errorf("custom thresholds: severity(%.1f) = %s, want %s", score, got, want)
+		}
+	}
+}
+
+func TestLoadRiskModel(t *testing.T) {
+	write := func(data string) string {
+		file := filepath.Join(t.TempDir(), "risk.json")
+		if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+
+	model, err := loadRiskModel(write(`{"ports": {"8080/tcp": 6}, "default_cve": 8, "cves": {"CVE-2024-3400": 10}, "severity_thresholds": {"critical": 9.5}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if model.Ports["8080/tcp"] != 6 || model.Ports["22"] != 3 {
+		t.Errorf("ports = %v, want 8080/tcp added to the defaults", model.Ports)
+	}
+	if model.DefaultCVE != 8 || model.CVEs["CVE-2024-3400"] != 10 {
+		t.Errorf("CVE weights = %.1f, %v", model.DefaultCVE, model.CVEs)
+	}
+	if model.Severities["critical"] != 9.5 || model.Severities["high"] != 7 {
+		t.Errorf("severity thresholds = %v", model.Severities)
+	}
+	if model.DefaultFinding != 5 {
+		t.Errorf("default finding weight = %.1f, want the default 5", model.DefaultFinding)
+	}
+
+	if _, err := loadRiskModel(write(`{"severity_thresholds": {"severe": 8}}`)); err == nil {
+		t.Error("unknown severity accepted")
+	}
+	if _, err := loadRiskModel(write(`{"ports": [22]}`)); err == nil {
+		t.Error("malformed weights accepted")
+	}
+	if _, err := loadRiskModel(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("missing file accepted")
+	}
+	if model, err := loadRiskModel(""); err != nil || !reflect.DeepEqual(model, defaultRiskModel()) {
+		t.Errorf("no file = %+v, %v, want the defaults", model, err)
+	}
+}
+
+func TestSortByRisk(t *testing.T) {
+	summaries := []ScanSummary{
+		{Target: "a", RiskScore: 2},
+		{Target: "b", RiskScore: 9.5},
+		{Target: "c", RiskScore: 0},
+		{Target: "d", RiskScore: 2},
+		{Target: "e", RiskScore: 7},
+	}
+	sortByRisk(summaries)
+	var got []string
+	for _, s := range summaries {
+		got = append(got, s.Target)
+	}
+	// Hosts with equal scores keep their order
+	if want := []string{"b", "e", "a", "d", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("order = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaSink writes every event to a single topic, keyed by target so that a
+// host's events stay ordered within one partition
+type kafkaSink struct {
+	writer *kafka.Writer
+	opts   sinkOptions
+}
+
+func newKafkaSink(u *url.URL, topic string, opts sinkOptions) (*kafkaSink, error) {
+	acks := kafka.RequireNone
+	if opts.Delivery == DeliveryAtLeastOnce {
+		acks = kafka.RequireAll
+	}
+
+	writer := &kafka.Writer{
+		Addr:         kafka.TCP(strings.Split(u.Host, ",")...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		BatchSize:    opts.BatchSize,
+		RequiredAcks: acks,
+		WriteTimeout: opts.Timeout,
+		ReadTimeout:  opts.Timeout,
+	}
+	return &kafkaSink{writer: writer, opts: opts}, nil
+}
+
+func (s *kafkaSink) Publish(msgs []sinkMessage) error {
+	batch := make([]kafka.Message, len(msgs))
+	for i, m := range msgs {
+		batch[i] = kafka.Message{
+			Key:     []byte(m.Key),
+			Value:   m.Payload,
+			Headers: []kafka.Header{{Key: "event", Value: []byte(m.Event)}},
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.opts.Timeout)
+	defer cancel()
+	return s.writer.WriteMessages(ctx, batch...)
+}
+
+func (s *kafkaSink) Close() error {
+	return s.writer.Close()
+}
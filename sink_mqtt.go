@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// mqttSink publishes each event type to "<topic>/<event>", using QoS 1 for
+// at-least-once delivery and QoS 0 otherwise
+type mqttSink struct {
+	client mqtt.Client
+	topic  string
+	qos    byte
+	opts   sinkOptions
+}
+
+// mqttClientID is unique per sink, since a broker drops the older session when
+// a second client connects with the same ID
+func mqttClientID() string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("portscanner-%d-%s", os.Getpid(), hex.EncodeToString(suffix))
+}
+
+func newMQTTSink(u *url.URL, topic string, opts sinkOptions) (*mqttSink, error) {
+	clientOpts := mqtt.NewClientOptions().
+		AddBroker("tcp://" + u.Host).
+		SetClientID(mqttClientID()).
+		SetConnectTimeout(opts.Timeout)
+	if u.User != nil {
+		clientOpts.SetUsername(u.User.Username())
+		if pass, ok := u.User.Password(); ok {
+			clientOpts.SetPassword(pass)
+		}
+	}
+
+	client := mqtt.NewClient(clientOpts)
+	token := client.Connect()
+	if !token.WaitTimeout(opts.Timeout) {
+		return nil, fmt.Errorf("timed out connecting to %s", u.Host)
+	}
+	if err := token.Error(); err != nil {
+		return nil, err
+	}
+
+	sink := &mqttSink{client: client, topic: topic, opts: opts}
+	if opts.Delivery == DeliveryAtLeastOnce {
+		sink.qos = 1
+	}
+	return sink, nil
+}
+
+func (s *mqttSink) Publish(msgs []sinkMessage) error {
+	tokens := make([]mqtt.Token, len(msgs))
+	for i, m := range msgs {
+		tokens[i] = s.client.Publish(s.topic+"/"+strings.ReplaceAll(m.Event, ".", "/"), s.qos, false, m.Payload)
+	}
+	for _, token := range tokens {
+		if !token.WaitTimeout(s.opts.Timeout) {
+			return fmt.Errorf("timed out publishing to %s", s.topic)
+		}
+		if err := token.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *mqttSink) Close() error {
+	s.client.Disconnect(250)
+	return nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMQTTBroker accepts MQTT 3.1.1 clients and acknowledges their QoS 1
+// publishes. Like a real broker, it drops the older session when a client
+// connects with an ID already in use.
+type fakeMQTTBroker struct {
+	mu        sync.Mutex
+	sessions  map[string]net.Conn
+	clientIDs []string
+	published map[string][][]byte
+}
+
+func startMQTTBroker(t *testing.T) (*fakeMQTTBroker, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	b := &fakeMQTTBroker{sessions: map[string]net.Conn{}, published: map[string][][]byte{}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b, ln.Addr().String()
+}
+
+// readMQTTPacket reads one control packet: its type, flags and body
+func readMQTTPacket(r *bufio.Reader) (byte, byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	var length, shift int
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		length |= int(c&0x7f) << shift
+		if c&0x80 == 0 {
+			break
+		}
+		if shift += 7; shift > 21 {
+			return 0, 0, nil, errors.New("bad remaining length")
+		}
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(r, body)
+	return header >> 4, header & 0x0f, body, err
+}
+
+// mqttString splits a length-prefixed string off the front of b
+func mqttString(b []byte) (string, []byte) {
+	if len(b) < 2 || len(b) < 2+int(binary.BigEndian.Uint16(b)) {
+		return "", nil
+	}
+	n := 2 + int(binary.BigEndian.Uint16(b))
+	return string(b[2:n]), b[n:]
+}
+
+func (b *fakeMQTTBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		typ, flags, body, err := readMQTTPacket(r)
+		if err != nil {
+			return
+		}
+		switch typ {
+		case 1: // CONNECT: protocol name, level, flags and keep alive precede the client ID
+			_, rest := mqttString(body)
+			if len(rest) < 4 {
+				return
+			}
+			id, _ := mqttString(rest[4:])
+			b.mu.Lock()
+			if old := b.sessions[id]; old != nil {
+				old.Close()
+			}
+			b.sessions[id] = conn
+			b.clientIDs = append(b.clientIDs, id)
+			b.mu.Unlock()
+			conn.Write([]byte{0x20, 2, 0, 0})
+		case 3: // PUBLISH
+			topic, rest := mqttString(body)
+			qos := flags >> 1 & 3
+			var packetID []byte
+			if qos > 0 && len(rest) >= 2 {
+				packetID, rest = rest[:2], rest[2:]
+			}
+			b.mu.Lock()
+			b.published[topic] = append(b.published[topic], rest)
+			b.mu.Unlock()
+			if packetID != nil {
+				conn.Write(append([]byte{0x40, 2}, packetID...))
+			}
+		case 12: // PINGREQ
+			conn.Write([]byte{0xd0, 0})
+		case 14: // DISCONNECT
+			return
+		}
+	}
+}
+
+func TestMQTTSinkPublish(t *testing.T) {
+	broker, addr := startMQTTBroker(t)
+
+	// Two sinks in one run, as with several -sink URLs or per-owner sinks
+	bus, err := newEventBus("mqtt://"+addr+"/scans,mqtt://"+addr+"/audit", sinkOptions{
+		Format:    "json",
+		BatchSize: 1,
+		Delivery:  DeliveryAtLeastOnce,
+		Timeout:   2 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus.Emit(ScanEvent{Type: EventPortResult, Target: "10.0.0.1", Result: &ScanResult{Port: 22, State: "open"}})
+	bus.Emit(ScanEvent{Type: EventPortResult, Target: "10.0.0.1", Result: &ScanResult{Port: 443, State: "open"}})
+	bus.Close()
+
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	if len(broker.clientIDs) != 2 || broker.clientIDs[0] == broker.clientIDs[1] {
+		t.Errorf("client IDs = %q, want two distinct ones", broker.clientIDs)
+	}
+	for _, topic := range []string{"scans/port/result", "audit/port/result"} {
+		payloads := broker.published[topic]
+		if len(payloads) != 2 {
+			t.Errorf("%s got %d messages, want 2", topic, len(payloads))
+			continue
+		}
+		for i, want := range []int{22, 443} {
+			var ev ScanEvent
+			if err := json.Unmarshal(payloads[i], &ev); err != nil {
+				t.Fatalf("%s: %v", topic, err)
+			}
+			if ev.Type != EventPortResult || ev.Target != "10.0.0.1" || ev.Result == nil || ev.Result.Port != want {
+				t.Errorf("%s message %d = %+v", topic, i, ev)
+			}
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/url"
+
+	"github.com/nats-io/nats.go"
+)
+
+// natsSink publishes each event type to "<subject>.<event>". At-least-once
+// delivery goes through JetStream so every message is acknowledged by a stream.
+type natsSink struct {
+	conn    *nats.Conn
+	js      nats.JetStreamContext
+	subject string
+}
+
+func newNATSSink(u *url.URL, subject string, opts sinkOptions) (*natsSink, error) {
+	server := &url.URL{Scheme: "nats", Host: u.Host, User: u.User}
+	conn, err := nats.Connect(server.String(), nats.Timeout(opts.Timeout), nats.Name("portscanner"))
+	if err != nil {
+		return nil, err
+	}
+
+	sink := &natsSink{conn: conn, subject: subject}
+	if opts.Delivery == DeliveryAtLeastOnce {
+		if sink.js, err = conn.JetStream(nats.MaxWait(opts.Timeout)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return sink, nil
+}
+
+func (s *natsSink) Publish(msgs []sinkMessage) error {
+	for _, m := range msgs {
+		subject := s.subject + "." + m.Event
+		if s.js != nil {
+			if _, err := s.js.Publish(subject, m.Payload); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.conn.Publish(subject, m.Payload); err != nil {
+			return err
+		}
+	}
+	return s.conn.Flush()
+}
+
+func (s *natsSink) Close() error {
+	return s.conn.Drain()
+}
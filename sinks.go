@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Scan lifecycle event types published to message bus sinks
+const (
+	EventScanStarted   = "scan.started"
+	EventHostStarted   = "host.started"
+	EventPortResult    = "port.result"
+	EventHostCompleted = "host.completed"
+	EventScanCompleted = "scan.completed"
+)
+
+// Delivery guarantees a sink can be asked for
+const (
+	DeliveryAtMostOnce  = "at-most-once"
+	DeliveryAtLeastOnce = "at-least-once"
+)
+
+// ScanEvent is a single message published to a sink
+type ScanEvent struct {
+	Type    string       `json:"type"`
+	Time    time.Time    `json:"time"`
+	Target  string       `json:"target,omitempty"`
+	Owner   string       `json:"owner,omitempty"`
+	Result  *ScanResult  `json:"result,omitempty"`
+	Summary *ScanSummary `json:"summary,omitempty"`
+}
+
+// sinkMessage is an encoded event ready to be handed to a broker
+type sinkMessage struct {
+	Event   string
+	Key     string
+	Payload []byte
+}
+
+// busSink publishes batches of messages to one broker
+type busSink interface {
+	Publish(msgs []sinkMessage) error
+	Close() error
+}
+
+// sinkOptions are shared by every sink configured for a run
+type sinkOptions struct {
+	Format    string
+	BatchSize int
+	Delivery  string
+	Timeout   time.Duration
+}
+
+// eventBus fans events out to all configured sinks, batching per sink
+type eventBus struct {
+	sinks   []busSink
+	names   []string
+	pending [][]sinkMessage
+	opts    sinkOptions
+}
+
+func newEventBus(sinkURLs string, opts sinkOptions) (*eventBus, error) {
+	if opts.Format != "json" && opts.Format != "protobuf" {
+		return nil, fmt.Errorf("unknown sink format %q", opts.Format)
+	}
+	if opts.Delivery != DeliveryAtMostOnce && opts.Delivery != DeliveryAtLeastOnce {
+		return nil, fmt.Errorf("unknown delivery guarantee %q", opts.Delivery)
+	}
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 1
+	}
+
+	bus := &eventBus{opts: opts}
+	for _, raw := range splitSinkURLs(sinkURLs) {
+		sink, err := openSink(raw, opts)
+		if err != nil {
+			bus.Close()
+			return nil, fmt.Errorf("sink %s: %w", raw, err)
+		}
+		bus.sinks = append(bus.sinks, sink)
+		bus.names = append(bus.names, raw)
+		bus.pending = append(bus.pending, nil)
+	}
+	return bus, nil
+}
+
+// splitSinkURLs splits a comma-separated sink list at the start of each URL,
+// so that the broker lists of Kafka URLs such as
+// kafka://broker1:9092,broker2:9092/scans stay in one piece
+func splitSinkURLs(list string) []string {
+	var urls []string
+	for _, part := range strings.Split(list, ",") {
+		part = strings.TrimSpace(part)
+		switch {
+		case part == "":
+		case strings.Contains(part, "://") || len(urls) == 0:
+			urls = append(urls, part)
+		default:
+			urls[len(urls)-1] += "," + part
+		}
+	}
+	return urls
+}
+
+func openSink(raw string, opts sinkOptions) (busSink, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	topic := strings.TrimPrefix(u.Path, "/")
+	if topic == "" {
+		return nil, fmt.Errorf("missing subject or topic in URL")
+	}
+
+	switch u.Scheme {
+	case "nats":
+		return newNATSSink(u, topic, opts)
+	case "kafka":
+		return newKafkaSink(u, topic, opts)
+	case "mqtt":
+		return newMQTTSink(u, topic, opts)
+	}
+	return nil, fmt.Errorf("unsupported sink scheme %q", u.Scheme)
+}
+
+// Emit queues an event on every sink, publishing full batches right away
+func (b *eventBus) Emit(ev ScanEvent) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	payload, err := encodeEvent(ev, b.opts.Format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error encoding event:", err)
+		return
+	}
+
+	msg := sinkMessage{Event: ev.Type, Key: ev.Target, Payload: payload}
+	for i := range b.sinks {
+		b.pending[i] = append(b.pending[i], msg)
+		if len(b.pending[i]) >= b.opts.BatchSize {
+			b.flushSink(i)
+		}
+	}
+}
+
+// emitHost publishes the results of one host followed by its summary
+func emitHost(b *eventBus, summary *ScanSummary) {
+	var owner string
+	if summary.Owner != nil {
+		owner = summary.Owner.Team
+	}
+	for i := range summary.Ports {
+		b.Emit(ScanEvent{Type: EventPortResult, Target: summary.Target, Owner: owner, Result: &summary.Ports[i]})
+	}
+	b.Emit(ScanEvent{Type: EventHostCompleted, Target: summary.Target, Owner: owner, Summary: summary})
+	b.Flush()
+}
+
+// Flush publishes any partially filled batches
+func (b *eventBus) Flush() {
+	for i := range b.sinks {
+		b.flushSink(i)
+	}
+}
+
+func (b *eventBus) flushSink(i int) {
+	if len(b.pending[i]) == 0 {
+		return
+	}
+
+	attempts := 1
+	if b.opts.Delivery == DeliveryAtLeastOnce {
+		attempts = 3
+	}
+
+	var err error
+	for a := 0; a < attempts; a++ {
+		if a > 0 {
+			time.Sleep(time.Duration(a) * 500 * time.Millisecond)
+		}
+		if err = b.sinks[i].Publish(b.pending[i]); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Sink %s dropped %d events: %v\n", b.names[i], len(b.pending[i]), err)
+	}
+	b.pending[i] = nil
+}
+
+func (b *eventBus) Close() {
+	b.Flush()
+	for i, sink := range b.sinks {
+		if err := sink.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Sink %s close: %v\n", b.names[i], err)
+		}
+	}
+}
+
+/* Event Serialization */
+func encodeEvent(ev ScanEvent, format string) ([]byte, error) {
+	if format == "protobuf" {
+		return marshalEventProto(ev), nil
+	}
+	return json.Marshal(ev)
+}
+
+// marshalEventProto encodes an event following scanevent.proto
+func marshalEventProto(ev ScanEvent) []byte {
+	var b []byte
+	b = appendProtoString(b, 1, ev.Type)
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(ev.Time.UnixNano()))
+	b = appendProtoString(b, 3, ev.Target)
+	if ev.Result != nil {
+		b = protowire.AppendTag(b, 4, protowire.BytesType)
+		b = protowire.AppendBytes(b, marshalResultProto(*ev.Result))
+	}
+	if ev.Summary != nil {
+		b = protowire.AppendTag(b, 5, protowire.BytesType)
+		b = protowire.AppendBytes(b, marshalSummaryProto(*ev.Summary))
+	}
+	b = appendProtoString(b, 6, ev.Owner)
+	return b
+}
+
+func marshalResultProto(res ScanResult) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(res.Port))
+	b = appendProtoString(b, 2, res.State)
+	b = appendProtoString(b, 3, res.Banner)
+	for _, f := range res.Findings {
+		var fb []byte
+		fb = appendProtoString(fb, 1, f.Type)
+		fb = appendProtoString(fb, 2, f.Detail)
+		b = protowire.AppendTag(b, 4, protowire.BytesType)
+		b = protowire.AppendBytes(b, fb)
+	}
+	b = appendProtoString(b, 5, res.SubState)
+	b = appendProtoString(b, 6, res.proto())
+	return b
+}
+
+func marshalSummaryProto(s ScanSummary) []byte {
+	var b []byte
+	b = appendProtoString(b, 1, s.Target)
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(s.OpenPorts))
+	b = protowire.AppendTag(b, 3, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(s.ScannedPorts))
+	b = protowire.AppendTag(b, 4, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(s.TimeTaken.Milliseconds()))
+	for _, res := range s.Ports {
+		b = protowire.AppendTag(b, 5, protowire.BytesType)
+		b = protowire.AppendBytes(b, marshalResultProto(res))
+	}
+	return b
+}
+
+func appendProtoString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
@@ -0,0 +1,521 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// SNMP PDU tags (RFC 3416)
+const (
+	snmpGetRequest = 0xa0
+	snmpGetBulk    = 0xa5
+	snmpReport     = 0xa8
+)
+
+// SNMPv3 message flags
+const (
+	snmpFlagAuth       = 0x01
+	snmpFlagPriv       = 0x02
+	snmpFlagReportable = 0x04
+)
+
+// snmpEngine is what an SNMPv3 agent reveals about itself before any
+// authentication, in reply to a discovery request (RFC 3414 section 4)
+type snmpEngine struct {
+	ID    []byte
+	Boots int
+	Time  int
+	// At is when Time was read, to advance it for later requests
+	At time.Time
+}
+
+func (e *snmpEngine) now() int {
+	return e.Time + int(time.Since(e.At).Seconds())
+}
+
+// snmpDiscover sends an unauthenticated SNMPv3 GetRequest with an empty
+// engine ID. The agent answers with a Report carrying its engine ID, boots
+// and time.
+func snmpDiscover(host string, timeout time.Duration) (*snmpEngine, error) {
+	msgID := int(rand.Int31())
+	usm := derSeq(
+		derTag(0x04, nil), // msgAuthoritativeEngineID
+		derInteger(0),     // msgAuthoritativeEngineBoots
+		derInteger(0),     // msgAuthoritativeEngineTime
+		derTag(0x04, nil), // msgUserName
+		derTag(0x04, nil), // msgAuthenticationParameters
+		derTag(0x04, nil), // msgPrivacyParameters
+	)
+	msg := derSeq(
+		derInteger(3),
+		derSeq(derInteger(msgID), derInteger(65507), derTag(0x04, []byte{snmpFlagReportable}), derInteger(3)),
+		derTag(0x04, usm),
+		derSeq(
+			derTag(0x04, nil), // contextEngineID
+			derTag(0x04, nil), // contextName
+			snmpPDU(snmpGetRequest, msgID, 0, 0),
+		),
+	)
+
+	resp, err := udpExchange(host, 161, msg, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return parseSNMPEngine(resp)
+}
+
+// parseSNMPEngine reads the authoritative engine from the security
+// parameters of an SNMPv3 message
+func parseSNMPEngine(msg []byte) (*snmpEngine, error) {
+	var parts []asn1.RawValue
+	if _, err := asn1.Unmarshal(msg, &parts); err != nil || len(parts) < 3 {
+		return nil, errors.New("not an SNMP message")
+	}
+	if v, ok := derInt(asn1.RawValue{Bytes: parts[0].FullBytes}); !ok || v != 3 {
+		return nil, errors.New("not an SNMPv3 message")
+	}
+
+	var usm []asn1.RawValue
+	if _, err := asn1.Unmarshal(parts[2].Bytes, &usm); err != nil || len(usm) < 3 {
+		return nil, errors.New("invalid SNMPv3 security parameters")
+	}
+	if len(usm[0].Bytes) == 0 {
+		return nil, errors.New("empty SNMP engine ID")
+	}
+	engine := &snmpEngine{ID: usm[0].Bytes, At: time.Now()}
+	engine.Boots, _ = derInt(asn1.RawValue{Bytes: usm[1].FullBytes})
+	engine.Time, _ = derInt(asn1.RawValue{Bytes: usm[2].FullBytes})
+	return engine, nil
+}
+
+// snmpPDU encodes a PDU. For GetBulk, a and b are non-repeaters and
+// max-repetitions; otherwise error status and index.
+func snmpPDU(tag byte, requestID, a, b int, oids ...asn1.ObjectIdentifier) []byte {
+	var varbinds [][]byte
+	for _, oid := range oids {
+		encoded, _ := asn1.Marshal(oid)
+		varbinds = append(varbinds, derSeq(encoded, []byte{0x05, 0x00}))
+	}
+	return derTag(tag, bytes.Join([][]byte{derInteger(requestID), derInteger(a), derInteger(b), derSeq(varbinds...)}, nil))
+}
+
+/* SNMP Client */
+
+// snmpCredentials are the operator-supplied read-only credentials for
+// SNMPv2c or SNMPv3 (USM)
+type snmpCredentials struct {
+	Version   string // "2c" or "3"
+	Community string
+	User      string
+	AuthProto string // MD5, SHA or SHA256; empty for noAuthNoPriv
+	AuthPass  string
+	PrivProto string // DES or AES; empty for no privacy
+	PrivPass  string
+}
+
+// snmpVarbind is one OID and its value as returned by an agent
+type snmpVarbind struct {
+	OID   asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+// endOfMibView reports whether a walk ran off the end of the agent's MIB
+func (v snmpVarbind) endOfMibView() bool {
+	return v.Value.Class == asn1.ClassContextSpecific
+}
+
+type snmpClient struct {
+	host    string
+	creds   snmpCredentials
+	timeout time.Duration
+
+	engine  *snmpEngine
+	hash    func() hash.Hash
+	macLen  int
+	authKey []byte
+	privKey []byte
+	salt    uint64
+}
+
+// newSNMPClient prepares requests to an agent. For SNMPv3 it discovers the
+// agent's engine and localizes the keys to it (RFC 3414 section 2.6).
+func newSNMPClient(host string, creds snmpCredentials, timeout time.Duration) (*snmpClient, error) {
+	c := &snmpClient{host: host, creds: creds, timeout: timeout, salt: rand.Uint64()}
+	switch creds.Version {
+	case "2c":
+		return c, nil
+	case "3":
+	default:
+		return nil, fmt.Errorf("unsupported SNMP version %q, expected 2c or 3", creds.Version)
+	}
+
+	if creds.User == "" {
+		return nil, errors.New("SNMPv3 needs a user name")
+	}
+	switch strings.ToUpper(creds.AuthProto) {
+	case "":
+		if creds.PrivProto != "" {
+			return nil, errors.New("SNMPv3 privacy requires authentication")
+		}
+	case "MD5":
+		c.hash, c.macLen = md5.New, 12
+	case "SHA", "SHA1":
+		c.hash, c.macLen = sha1.New, 12
+	case "SHA256":
+		c.hash, c.macLen = sha256.New, 24
+	default:
+		return nil, fmt.Errorf("unsupported SNMPv3 authentication protocol %q (MD5, SHA, SHA256)", creds.AuthProto)
+	}
+	switch strings.ToUpper(creds.PrivProto) {
+	case "", "DES", "AES", "AES128":
+	default:
+		return nil, fmt.Errorf("unsupported SNMPv3 privacy protocol %q (DES, AES)", creds.PrivProto)
+	}
+
+	engine, err := snmpDiscover(host, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("SNMPv3 engine discovery: %w", err)
+	}
+	c.engine = engine
+	if c.hash != nil {
+		c.authKey = localizeKey(c.hash, creds.AuthPass, engine.ID)
+	}
+	if creds.PrivProto != "" {
+		c.privKey = localizeKey(c.hash, creds.PrivPass, engine.ID)
+	}
+	return c, nil
+}
+
+// localizeKey derives a user's key for one engine from a password
+// (RFC 3414 appendix A.2)
+func localizeKey(newHash func() hash.Hash, password string, engineID []byte) []byte {
+	h := newHash()
+	if password == "" {
+		password = "\x00"
+	}
+	buf := bytes.Repeat([]byte(password), 64/len(password)+2)
+	for written := 0; written < 1<<20; written += 64 {
+		h.Write(buf[written%len(password) : written%len(password)+64])
+	}
+	ku := h.Sum(nil)
+
+	h.Reset()
+	h.Write(ku)
+	h.Write(engineID)
+	h.Write(ku)
+	return h.Sum(nil)
+}
+
+// walk returns every varbind under root using GetBulk
+func (c *snmpClient) walk(root asn1.ObjectIdentifier) ([]snmpVarbind, error) {
+	var result []snmpVarbind
+	next := root
+	for len(result) < 50000 {
+		varbinds, err := c.request(snmpPDU(snmpGetBulk, 0, 0, 25, next))
+		if err != nil {
+			return result, err
+		}
+		if len(varbinds) == 0 {
+			return result, nil
+		}
+		for _, vb := range varbinds {
+			if vb.endOfMibView() || !oidHasPrefix(vb.OID, root) {
+				return result, nil
+			}
+			if oidCompare(vb.OID, next) <= 0 {
+				return result, errors.New("agent returned OIDs out of order")
+			}
+			result = append(result, vb)
+			next = vb.OID
+		}
+	}
+	return result, nil
+}
+
+// request sends one PDU and returns the response varbinds. An SNMPv3 agent
+// that reports the engine time out of window is retried once with the time
+// it reported.
+func (c *snmpClient) request(pdu []byte) ([]snmpVarbind, error) {
+	for attempt := 0; ; attempt++ {
+		requestID := int(rand.Int31())
+		pdu = setRequestID(pdu, requestID)
+		msg, err := c.encode(requestID, pdu)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := udpExchange(c.host, 161, msg, c.timeout)
+		if err != nil {
+			return nil, err
+		}
+		tag, varbinds, errStatus, err := c.decode(resp)
+		if err != nil {
+			return nil, err
+		}
+		if tag == snmpReport {
+			report := "unknown report"
+			if len(varbinds) > 0 {
+				report = usmReports[varbinds[0].OID.String()]
+			}
+			if report == "notInTimeWindows" && attempt == 0 {
+				if engine, err := parseSNMPEngine(resp); err == nil {
+					c.engine = engine
+					continue
+				}
+			}
+			return nil, fmt.Errorf("SNMPv3 agent reported %s", report)
+		}
+		if errStatus != 0 {
+			return nil, fmt.Errorf("SNMP error status %d", errStatus)
+		}
+		return varbinds, nil
+	}
+}
+
+// usmReports names the usmStats counters an agent reports errors with
+var usmReports = map[string]string{
+	"1.3.6.1.6.3.15.1.1.1.0": "unsupportedSecLevels",
+	"1.3.6.1.6.3.15.1.1.2.0": "notInTimeWindows",
+	"1.3.6.1.6.3.15.1.1.3.0": "unknownUserNames",
+	"1.3.6.1.6.3.15.1.1.4.0": "unknownEngineIDs",
+	"1.3.6.1.6.3.15.1.1.5.0": "wrongDigests",
+	"1.3.6.1.6.3.15.1.1.6.0": "decryptionErrors",
+}
+
+// setRequestID re-encodes a PDU with a new request ID
+func setRequestID(pdu []byte, requestID int) []byte {
+	var raw asn1.RawValue
+	if _, err := asn1.Unmarshal(pdu, &raw); err != nil {
+		return pdu
+	}
+	var old asn1.RawValue
+	rest, err := asn1.Unmarshal(raw.Bytes, &old)
+	if err != nil {
+		return pdu
+	}
+	return derTag(pdu[0], append(derInteger(requestID), rest...))
+}
+
+func (c *snmpClient) encode(msgID int, pdu []byte) ([]byte, error) {
+	if c.creds.Version == "2c" {
+		return derSeq(derInteger(1), derTag(0x04, []byte(c.creds.Community)), pdu), nil
+	}
+
+	e := c.engine
+	boots, now := e.Boots, e.now()
+	flags := byte(snmpFlagReportable)
+	scoped := derSeq(derTag(0x04, e.ID), derTag(0x04, nil), pdu)
+	var privParams []byte
+	if c.privKey != nil {
+		flags |= snmpFlagPriv
+		var err error
+		if privParams, scoped, err = c.encrypt(scoped, boots, now); err != nil {
+			return nil, err
+		}
+		scoped = derTag(0x04, scoped)
+	}
+	if c.authKey != nil {
+		flags |= snmpFlagAuth
+	}
+
+	build := func(authParams []byte) []byte {
+		usm := derSeq(
+			derTag(0x04, e.ID),
+			derInteger(boots),
+			derInteger(now),
+			derTag(0x04, []byte(c.creds.User)),
+			derTag(0x04, authParams),
+			derTag(0x04, privParams),
+		)
+		return derSeq(
+			derInteger(3),
+			derSeq(derInteger(msgID), derInteger(65507), derTag(0x04, []byte{flags}), derInteger(3)),
+			derTag(0x04, usm),
+			scoped,
+		)
+	}
+	if c.authKey == nil {
+		return build(nil), nil
+	}
+	// The MAC is computed over the message with zeroed authentication
+	// parameters, then put in their place
+	mac := hmac.New(c.hash, c.authKey)
+	mac.Write(build(make([]byte, c.macLen)))
+	return build(mac.Sum(nil)[:c.macLen]), nil
+}
+
+// decode returns the PDU type, varbinds and error status of a response
+func (c *snmpClient) decode(msg []byte) (byte, []snmpVarbind, int, error) {
+	var parts []asn1.RawValue
+	if _, err := asn1.Unmarshal(msg, &parts); err != nil || len(parts) < 3 {
+		return 0, nil, 0, errors.New("invalid SNMP response")
+	}
+
+	pdu := parts[len(parts)-1]
+	if c.creds.Version == "3" {
+		if len(parts) < 4 {
+			return 0, nil, 0, errors.New("invalid SNMPv3 response")
+		}
+		var header []asn1.RawValue
+		asn1.Unmarshal(parts[1].FullBytes, &header)
+		var usm []asn1.RawValue
+		asn1.Unmarshal(parts[2].Bytes, &usm)
+		if len(header) < 3 || len(header[2].Bytes) != 1 || len(usm) < 6 {
+			return 0, nil, 0, errors.New("invalid SNMPv3 header")
+		}
+
+		scoped := parts[3].FullBytes
+		if header[2].Bytes[0]&snmpFlagPriv != 0 {
+			if c.privKey == nil {
+				return 0, nil, 0, errors.New("encrypted SNMPv3 response without privacy credentials")
+			}
+			boots, _ := derInt(asn1.RawValue{Bytes: usm[1].FullBytes})
+			engineTime, _ := derInt(asn1.RawValue{Bytes: usm[2].FullBytes})
+			var err error
+			if scoped, err = c.decrypt(parts[3].Bytes, usm[5].Bytes, boots, engineTime); err != nil {
+				return 0, nil, 0, err
+			}
+		}
+		var scopedPDU []asn1.RawValue
+		if _, err := asn1.Unmarshal(scoped, &scopedPDU); err != nil || len(scopedPDU) < 3 {
+			return 0, nil, 0, errors.New("invalid SNMPv3 scoped PDU")
+		}
+		pdu = scopedPDU[2]
+	}
+
+	// PDU contents are the fields of a SEQUENCE under the PDU's own tag
+	var fields []asn1.RawValue
+	for rest := pdu.Bytes; len(rest) > 0; {
+		var f asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &f); err != nil {
+			return 0, nil, 0, errors.New("invalid SNMP PDU")
+		}
+		fields = append(fields, f)
+	}
+	if len(fields) < 4 {
+		return 0, nil, 0, errors.New("invalid SNMP PDU")
+	}
+	errStatus, _ := derInt(asn1.RawValue{Bytes: fields[1].FullBytes})
+
+	var list []asn1.RawValue
+	if _, err := asn1.Unmarshal(fields[3].FullBytes, &list); err != nil {
+		return 0, nil, 0, errors.New("invalid SNMP varbind list")
+	}
+	var varbinds []snmpVarbind
+	for _, item := range list {
+		var oid asn1.ObjectIdentifier
+		rest, err := asn1.Unmarshal(item.Bytes, &oid)
+		if err != nil {
+			return 0, nil, 0, errors.New("invalid SNMP varbind")
+		}
+		var value asn1.RawValue
+		if _, err := asn1.Unmarshal(rest, &value); err != nil {
+			return 0, nil, 0, errors.New("invalid SNMP varbind value")
+		}
+		varbinds = append(varbinds, snmpVarbind{OID: oid, Value: value})
+	}
+	return byte(0xa0 | pdu.Tag), varbinds, errStatus, nil
+}
+
+// encrypt applies CBC-DES (RFC 3414 section 8) or CFB128-AES-128
+// (RFC 3826) to a scoped PDU and returns the salt sent as privacy
+// parameters
+func (c *snmpClient) encrypt(plain []byte, boots, engineTime int) ([]byte, []byte, error) {
+	c.salt++
+	salt := make([]byte, 8)
+	if strings.HasPrefix(strings.ToUpper(c.creds.PrivProto), "AES") {
+		binary.BigEndian.PutUint64(salt, c.salt)
+		block, err := aes.NewCipher(c.privKey[:16])
+		if err != nil {
+			return nil, nil, err
+		}
+		out := make([]byte, len(plain))
+		cipher.NewCFBEncrypter(block, aesIV(boots, engineTime, salt)).XORKeyStream(out, plain)
+		return salt, out, nil
+	}
+
+	binary.BigEndian.PutUint32(salt, uint32(boots))
+	binary.BigEndian.PutUint32(salt[4:], uint32(c.salt))
+	block, err := des.NewCipher(c.privKey[:8])
+	if err != nil {
+		return nil, nil, err
+	}
+	if pad := len(plain) % 8; pad != 0 {
+		plain = append(plain, make([]byte, 8-pad)...)
+	}
+	out := make([]byte, len(plain))
+	cipher.NewCBCEncrypter(block, desIV(c.privKey, salt)).CryptBlocks(out, plain)
+	return salt, out, nil
+}
+
+func (c *snmpClient) decrypt(data, salt []byte, boots, engineTime int) ([]byte, error) {
+	if len(salt) != 8 {
+		return nil, errors.New("invalid SNMPv3 privacy parameters")
+	}
+	out := make([]byte, len(data))
+	if strings.HasPrefix(strings.ToUpper(c.creds.PrivProto), "AES") {
+		block, err := aes.NewCipher(c.privKey[:16])
+		if err != nil {
+			return nil, err
+		}
+		cipher.NewCFBDecrypter(block, aesIV(boots, engineTime, salt)).XORKeyStream(out, data)
+		return out, nil
+	}
+
+	if len(data)%8 != 0 {
+		return nil, errors.New("invalid DES-encrypted SNMPv3 PDU")
+	}
+	block, err := des.NewCipher(c.privKey[:8])
+	if err != nil {
+		return nil, err
+	}
+	cipher.NewCBCDecrypter(block, desIV(c.privKey, salt)).CryptBlocks(out, data)
+	return out, nil
+}
+
+func aesIV(boots, engineTime int, salt []byte) []byte {
+	iv := make([]byte, 16)
+	binary.BigEndian.PutUint32(iv, uint32(boots))
+	binary.BigEndian.PutUint32(iv[4:], uint32(engineTime))
+	copy(iv[8:], salt)
+	return iv
+}
+
+// desIV XORs the pre-IV, the second half of the privacy key, with the salt
+func desIV(key, salt []byte) []byte {
+	iv := make([]byte, 8)
+	for i := range iv {
+		iv[i] = key[8+i] ^ salt[i]
+	}
+	return iv
+}
+
+func oidHasPrefix(oid, prefix asn1.ObjectIdentifier) bool {
+	return len(oid) > len(prefix) && oidCompare(oid[:len(prefix)], prefix) == 0
+}
+
+func oidCompare(a, b asn1.ObjectIdentifier) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(a) - len(b)
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"hash"
+	"testing"
+)
+
+func TestLocalizeKey(t *testing.T) {
+	engineID, _ := hex.DecodeString("000000000000000000000002")
+	tests := []struct {
+		name    string
+		newHash func() hash.Hash
+		want    string
+	}{
+		// RFC 3414 appendix A.3
+		{"A.3.1 MD5", md5.New, "526f5eed9fcce26f8964c2930787d82b"},
+		{"A.3.2 SHA", sha1.New, "6695febc9288e36282235fc7151f128497b38f3f"},
+	}
+	for _, tt := range tests {
+		if got := hex.EncodeToString(localizeKey(tt.newHash, "maplesyrup", engineID)); got != tt.want {
+			t.Errorf("%s: localizeKey = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+)
+
+// Tables walked for SNMP-assisted discovery (RFC 4293)
+var (
+	oidIPNetToMediaPhys    = asn1.ObjectIdentifier{1, 3, 6, 1, 2, 1, 4, 22, 1, 2}
+	oidIPNetToMediaType    = asn1.ObjectIdentifier{1, 3, 6, 1, 2, 1, 4, 22, 1, 4}
+	oidIPNetToPhysicalPhys = asn1.ObjectIdentifier{1, 3, 6, 1, 2, 1, 4, 35, 1, 4}
+	oidIPNetToPhysicalType = asn1.ObjectIdentifier{1, 3, 6, 1, 2, 1, 4, 35, 1, 6}
+	oidIPAdEntAddr         = asn1.ObjectIdentifier{1, 3, 6, 1, 2, 1, 4, 20, 1, 1}
+	oidIPAddressIfIndex    = asn1.ObjectIdentifier{1, 3, 6, 1, 2, 1, 4, 34, 1, 3}
+)
+
+// snmpNeighbor is a live address learned from a network device
+type snmpNeighbor struct {
+	Addr   string
+	MAC    string
+	Device string
+}
+
+// snmpDiscoverHosts walks the ARP/neighbor caches and interface address
+// tables of every device and returns the addresses found, each once.
+// Devices that cannot be queried are reported and skipped.
+func snmpDiscoverHosts(devices []string, creds snmpCredentials, timeout time.Duration) []snmpNeighbor {
+	seen := map[string]bool{}
+	var found []snmpNeighbor
+	for _, device := range devices {
+		device = strings.TrimSpace(device)
+		if device == "" {
+			continue
+		}
+		neighbors, err := snmpDeviceNeighbors(device, creds, timeout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "SNMP discovery on %s: %v\n", device, err)
+		}
+		added := 0
+		for _, n := range neighbors {
+			if !seen[n.Addr] {
+				seen[n.Addr] = true
+				found = append(found, n)
+				added++
+			}
+		}
+		fmt.Fprintf(os.Stderr, "SNMP discovery on %s: %d new hosts\n", device, added)
+	}
+	return found
+}
+
+// snmpDeviceNeighbors queries one device. Neighbor entries marked invalid
+// are skipped, as are loopback, link-local and multicast addresses. Devices
+// often lack some of the tables, so a table that cannot be walked is skipped
+// and the others are still used; its error is returned with the neighbors.
+func snmpDeviceNeighbors(device string, creds snmpCredentials, timeout time.Duration) ([]snmpNeighbor, error) {
+	client, err := newSNMPClient(device, creds, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	var neighbors []snmpNeighbor
+	add := func(addr netip.Addr, mac []byte) {
+		addr = addr.Unmap()
+		if !addr.IsValid() || addr.IsUnspecified() || addr.IsLoopback() || addr.IsMulticast() ||
+			addr.IsLinkLocalUnicast() || addr == netip.AddrFrom4([4]byte{255, 255, 255, 255}) {
+			return
+		}
+		n := snmpNeighbor{Addr: addr.String(), Device: device}
+		if len(mac) == 6 {
+			n.MAC = net.HardwareAddr(mac).String()
+		}
+		neighbors = append(neighbors, n)
+	}
+
+	// A device that times out before answering anything is not asked for
+	// the remaining tables
+	var errs []error
+	answered, unreachable := false, false
+	walk := func(column asn1.ObjectIdentifier) []snmpVarbind {
+		if unreachable {
+			return nil
+		}
+		rows, err := client.walk(column)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("walking %s: %w", column, err))
+			unreachable = !answered && len(rows) == 0 && errors.Is(err, os.ErrDeadlineExceeded)
+		}
+		answered = answered || err == nil || len(rows) > 0
+		return rows
+	}
+
+	// ipNetToMediaTable, indexed by ifIndex and IPv4 address; type 2 is invalid
+	invalid := snmpIndexValues(walk(oidIPNetToMediaType), oidIPNetToMediaType, 2)
+	for _, vb := range walk(oidIPNetToMediaPhys) {
+		index := vb.OID[len(oidIPNetToMediaPhys):]
+		if len(index) != 5 || invalid[oidKey(index)] {
+			continue
+		}
+		add(netip.AddrFrom4([4]byte{byte(index[1]), byte(index[2]), byte(index[3]), byte(index[4])}), vb.Value.Bytes)
+	}
+
+	// ipNetToPhysicalTable, indexed by ifIndex, address type and a
+	// length-prefixed address, covers IPv6 neighbors too
+	invalid = snmpIndexValues(walk(oidIPNetToPhysicalType), oidIPNetToPhysicalType, 2)
+	for _, vb := range walk(oidIPNetToPhysicalPhys) {
+		index := vb.OID[len(oidIPNetToPhysicalPhys):]
+		if len(index) < 3 || invalid[oidKey(index)] {
+			continue
+		}
+		if addr, ok := indexAddr(index[1:]); ok {
+			add(addr, vb.Value.Bytes)
+		}
+	}
+
+	// The device's own interface addresses, from ipAddrTable and ipAddressTable
+	for _, vb := range walk(oidIPAdEntAddr) {
+		if addr, ok := netip.AddrFromSlice(vb.Value.Bytes); ok {
+			add(addr, nil)
+		}
+	}
+	for _, vb := range walk(oidIPAddressIfIndex) {
+		if addr, ok := indexAddr(vb.OID[len(oidIPAddressIfIndex):]); ok {
+			add(addr, nil)
+		}
+	}
+	return neighbors, errors.Join(errs...)
+}
+
+// snmpIndexValues returns the row indexes of a walked INTEGER column whose
+// value equals want
+func snmpIndexValues(rows []snmpVarbind, column asn1.ObjectIdentifier, want int) map[string]bool {
+	matched := map[string]bool{}
+	for _, vb := range rows {
+		if v, ok := derInt(asn1.RawValue{Bytes: vb.Value.FullBytes}); ok && v == want {
+			matched[oidKey(vb.OID[len(column):])] = true
+		}
+	}
+	return matched
+}
+
+// indexAddr decodes an InetAddressType, InetAddress pair from a table
+// index: the type, the address length, then one sub-identifier per byte
+func indexAddr(index []int) (netip.Addr, bool) {
+	if len(index) < 2 || index[1] != len(index)-2 {
+		return netip.Addr{}, false
+	}
+	b := make([]byte, index[1])
+	for i := range b {
+		b[i] = byte(index[2+i])
+	}
+	switch {
+	case index[0] == 1 && len(b) == 4, index[0] == 2 && len(b) == 16:
+		return netip.AddrFromSlice(b)
+	}
+	return netip.Addr{}, false
+}
+
+func oidKey(index []int) string {
+	return asn1.ObjectIdentifier(index).String()
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestIndexAddr(t *testing.T) {
+	tests := []struct {
+		name  string
+		index []int
+		want  string
+	}{
+		{"ipv4", []int{1, 4, 192, 168, 1, 20}, "192.168.1.20"},
+		{"ipv6", []int{2, 16, 0xfd, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}, "fd00::1"},
+		{"length does not match", []int{1, 4, 10, 0, 0}, ""},
+		{"ipv6 type with ipv4 length", []int{2, 4, 10, 0, 0, 1}, ""},
+		{"ipv4z", []int{3, 8, 10, 0, 0, 1, 0, 0, 0, 1}, ""},
+		{"unknown type", []int{0, 0}, ""},
+		{"too short", []int{1}, ""},
+	}
+	for _, tt := range tests {
+		addr, ok := indexAddr(tt.index)
+		if tt.want == "" {
+			if ok {
+				t.Errorf("%s: indexAddr = %s, want none", tt.name, addr)
+			}
+			continue
+		}
+		if !ok || addr != netip.MustParseAddr(tt.want) {
+			t.Errorf("%s: indexAddr = %s, %v; want %s", tt.name, addr, ok, tt.want)
+		}
+	}
+}
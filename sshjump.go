@@ -0,0 +1,300 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// jumpHost, when set, carries every TCP dial of the scan through an SSH
+// bastion
+var jumpHost *sshJump
+
+// jumpSlotWait is how long a dial waits for one of the -ssh-channels slots
+// before giving up on the port
+const jumpSlotWait = 30 * time.Second
+
+// errJumpBusy is returned when no channel to the bastion frees up in time, so
+// the port was not tried at all
+var errJumpBusy = errors.New("ssh jump: no free channel")
+
+// sshJump opens direct-tcpip channels on an SSH connection to a bastion,
+// limiting how many are open at once since servers cap them per connection
+type sshJump struct {
+	client   *ssh.Client
+	slots    chan struct{}
+	slotWait time.Duration
+}
+
+// sshJumpOptions configure how the bastion is reached and authenticated
+type sshJumpOptions struct {
+	KeyFile        string
+	KnownHostsFile string
+	Insecure       bool
+	Channels       int
+	Timeout        time.Duration
+}
+
+// newSSHJump connects to user@host[:port], authenticating with the SSH agent
+// and a private key file
+func newSSHJump(dest string, opts sshJumpOptions) (*sshJump, error) {
+	user, addr, ok := strings.Cut(dest, "@")
+	if !ok || user == "" || addr == "" {
+		return nil, fmt.Errorf("invalid -ssh-jump %q, expected user@host[:port]", dest)
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), "22")
+	}
+
+	auth, err := sshAuthMethods(opts.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	hostKeys := ssh.InsecureIgnoreHostKey()
+	if !opts.Insecure {
+		if hostKeys, err = knownhosts.New(opts.KnownHostsFile); err != nil {
+			return nil, fmt.Errorf("known hosts: %w (use -ssh-insecure to skip host key checks)", err)
+		}
+	}
+
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: hostKeys,
+		Timeout:         opts.Timeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if opts.Channels < 1 {
+		opts.Channels = 1
+	}
+	return &sshJump{client: client, slots: make(chan struct{}, opts.Channels), slotWait: jumpSlotWait}, nil
+}
+
+// sshAuthMethods offers the agent's keys first, then the key file, or the
+// default keys in ~/.ssh when none is given
+func sshAuthMethods(keyFile string) ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+
+	files := []string{keyFile}
+	if keyFile == "" {
+		home, _ := os.UserHomeDir()
+		files = nil
+		for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+			files = append(files, filepath.Join(home, ".ssh", name))
+		}
+	}
+	var signers []ssh.Signer
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			if keyFile != "" {
+				return nil, err
+			}
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			var missing *ssh.PassphraseMissingError
+			if errors.As(err, &missing) {
+				fmt.Fprintf(os.Stderr, "Skipping passphrase-protected key %s; load it into ssh-agent instead\n", file)
+				continue
+			}
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+	if len(methods) == 0 {
+		return nil, errors.New("no SSH agent or private key available for -ssh-jump")
+	}
+	return methods, nil
+}
+
+// Dial opens a direct-tcpip channel to host:port from the bastion. Waiting
+// for a free channel is bounded by slotWait and fails with errJumpBusy; only
+// the bastion's connect counts against the timeout.
+func (j *sshJump) Dial(host string, port int, timeout time.Duration) (net.Conn, error) {
+	wait := time.NewTimer(j.slotWait)
+	select {
+	case j.slots <- struct{}{}:
+		wait.Stop()
+	case <-wait.C:
+		return nil, fmt.Errorf("%w within %v", errJumpBusy, j.slotWait)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	type dialed struct {
+		conn net.Conn
+		err  error
+	}
+	done := make(chan dialed, 1)
+	go func() {
+		conn, err := j.client.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+		done <- dialed{conn, err}
+	}()
+
+	select {
+	case d := <-done:
+		if d.err != nil {
+			<-j.slots
+			return nil, d.err
+		}
+		return newJumpConn(d.conn, func() { <-j.slots }), nil
+	case <-timer.C:
+		// Close the channel whenever the bastion gets around to opening it.
+		// Bastions can take minutes to give up on a connect, so the slot is
+		// freed after another timeout rather than held until then.
+		release := sync.OnceFunc(func() { <-j.slots })
+		go func() {
+			grace := time.AfterFunc(timeout, release)
+			if d := <-done; d.err == nil {
+				d.conn.Close()
+			}
+			grace.Stop()
+			release()
+		}()
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}
+	}
+}
+
+func (j *sshJump) Close() error {
+	return j.client.Close()
+}
+
+// jumpConn adds deadlines to an SSH channel, which does not support them. A
+// read that times out keeps running and its data is returned by the next
+// Read. A write that times out closes the channel, since the rest of it
+// cannot be taken back.
+type jumpConn struct {
+	net.Conn
+	release func()
+	once    sync.Once
+
+	mu            sync.Mutex
+	deadline      time.Time
+	writeDeadline time.Time
+	pending       chan jumpRead
+	leftover      []byte
+	err           error // returned once leftover is drained
+}
+
+type jumpRead struct {
+	data []byte
+	err  error
+}
+
+func newJumpConn(conn net.Conn, release func()) *jumpConn {
+	return &jumpConn{Conn: conn, release: release}
+}
+
+func (c *jumpConn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	if len(c.leftover) > 0 {
+		n := copy(p, c.leftover)
+		c.leftover = c.leftover[n:]
+		var err error
+		if len(c.leftover) == 0 {
+			err, c.err = c.err, nil
+		}
+		c.mu.Unlock()
+		return n, err
+	}
+	if c.pending == nil {
+		c.pending = make(chan jumpRead, 1)
+		buf := make([]byte, len(p))
+		go func(ch chan jumpRead) {
+			n, err := c.Conn.Read(buf)
+			ch <- jumpRead{buf[:n], err}
+		}(c.pending)
+	}
+	pending, deadline := c.pending, c.deadline
+	c.mu.Unlock()
+
+	var expired <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case r := <-pending:
+		c.mu.Lock()
+		c.pending = nil
+		n := copy(p, r.data)
+		c.leftover = r.data[n:]
+		if len(c.leftover) > 0 {
+			c.err, r.err = r.err, nil
+		}
+		c.mu.Unlock()
+		return n, r.err
+	case <-expired:
+		return 0, os.ErrDeadlineExceeded
+	}
+}
+
+func (c *jumpConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	deadline := c.writeDeadline
+	c.mu.Unlock()
+	if deadline.IsZero() {
+		return c.Conn.Write(p)
+	}
+	if !time.Now().Before(deadline) {
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	// Writes block while the peer's channel window is full
+	expired := time.AfterFunc(time.Until(deadline), func() { c.Conn.Close() })
+	n, err := c.Conn.Write(p)
+	if !expired.Stop() {
+		return n, os.ErrDeadlineExceeded
+	}
+	return n, err
+}
+
+func (c *jumpConn) SetDeadline(t time.Time) error {
+	c.SetWriteDeadline(t)
+	return c.SetReadDeadline(t)
+}
+
+func (c *jumpConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *jumpConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.writeDeadline = t
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *jumpConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// startJumpServer runs an SSH server that answers direct-tcpip requests by
+// target host: "echo" echoes, "sink" never reads, "slow" answers only once
+// the test ends and anything else is refused. It returns a client for it.
+func startJumpServer(t *testing.T, channels int) *sshJump {
+	t.Helper()
+	_, hostKey, _ := ed25519.GenerateKey(rand.Reader)
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) { return nil, nil },
+	}
+	config.AddHostKey(hostSigner)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	t.Cleanup(func() {
+		close(stop)
+		ln.Close()
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveJump(conn, config, stop)
+		}
+	}()
+
+	// The client authenticates with a key file; the agent is not used
+	_, clientKey, _ := ed25519.GenerateKey(rand.Reader)
+	block, err := ssh.MarshalPrivateKey(clientKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	jump, err := newSSHJump("scanner@"+ln.Addr().String(), sshJumpOptions{
+		KeyFile:  keyFile,
+		Insecure: true,
+		Channels: channels,
+		Timeout:  time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { jump.Close() })
+	return jump
+}
+
+func serveJump(conn net.Conn, config *ssh.ServerConfig, stop chan struct{}) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChan := range chans {
+		var target struct {
+			Host     string
+			Port     uint32
+			OrigHost string
+			OrigPort uint32
+		}
+		if newChan.ChannelType() != "direct-tcpip" || ssh.Unmarshal(newChan.ExtraData(), &target) != nil {
+			newChan.Reject(ssh.UnknownChannelType, "unsupported")
+			continue
+		}
+		go func(newChan ssh.NewChannel) {
+			switch target.Host {
+			case "echo", "sink":
+				ch, reqs, err := newChan.Accept()
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				if target.Host == "echo" {
+					io.Copy(ch, ch)
+				} else {
+					<-stop
+				}
+				ch.Close()
+			case "slow":
+				<-stop
+				newChan.Reject(ssh.ConnectionFailed, "timed out")
+			default:
+				newChan.Reject(ssh.ConnectionFailed, "connection refused")
+			}
+		}(newChan)
+	}
+}
+
+func TestSSHJumpDial(t *testing.T) {
+	jump := startJumpServer(t, 2)
+
+	tests := []struct {
+		name    string
+		host    string
+		timeout time.Duration
+		wantErr func(error) bool
+	}{
+		{"echo", "echo", time.Second, nil},
+		{"refused", "closed", time.Second, func(err error) bool {
+			var open *ssh.OpenChannelError
+			return errors.As(err, &open) && open.Reason == ssh.ConnectionFailed
+		}},
+		{"timeout", "slow", 50 * time.Millisecond, func(err error) bool { return errors.Is(err, os.ErrDeadlineExceeded) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := jump.Dial(tt.host, 7, tt.timeout)
+			if tt.wantErr != nil {
+				if err == nil || !tt.wantErr(err) {
+					t.Fatalf("Dial error = %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(time.Second))
+			if _, err := conn.Write([]byte("ping")); err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 4)
+			if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+				t.Fatalf("read %q, %v", buf, err)
+			}
+		})
+	}
+}
+
+func TestSSHJumpSlots(t *testing.T) {
+	jump := startJumpServer(t, 1)
+	jump.slotWait = 100 * time.Millisecond
+
+	// A busy bastion is reported as such, not as a closed port
+	held, err := jump.Dial("echo", 7, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jump.Dial("echo", 7, time.Second); !errors.Is(err, errJumpBusy) {
+		t.Fatalf("Dial with no free channel: %v, want errJumpBusy", err)
+	}
+	held.Close()
+
+	// An abandoned dial gives its slot back after another timeout even though
+	// the bastion is still connecting
+	jump.slotWait = time.Second
+	if _, err := jump.Dial("slow", 7, 100*time.Millisecond); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Dial to slow host: %v, want a timeout", err)
+	}
+	start := time.Now()
+	conn, err := jump.Dial("echo", 7, time.Second)
+	if err != nil {
+		t.Fatalf("Dial after abandoned dial: %v", err)
+	}
+	conn.Close()
+	if waited := time.Since(start); waited > 500*time.Millisecond {
+		t.Errorf("waited %v for the abandoned slot", waited)
+	}
+}
+
+func TestJumpConnDeadlines(t *testing.T) {
+	jump := startJumpServer(t, 2)
+
+	conn, err := jump.Dial("sink", 7, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Read past deadline: %v", err)
+	}
+
+	// The sink never reads, so writes block once the channel window is full
+	conn.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	done := make(chan error, 1)
+	go func() {
+		chunk := make([]byte, 64*1024)
+		for {
+			if _, err := conn.Write(chunk); err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("Write past deadline: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Write blocked past its deadline")
+	}
+	if _, err := conn.Write([]byte("x")); err == nil {
+		t.Error("channel still writable after a write timed out")
+	}
+}
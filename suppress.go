@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"time"
+)
+
+// Acceptance records who accepted the risk of a finding, why, and until when
+type Acceptance struct {
+	Justification string `json:"justification" xml:"justification"`
+	Approver      string `json:"approver" xml:"approver,attr"`
+	Expires       string `json:"expires" xml:"expires,attr"`
+}
+
+// Suppression accepts the findings it matches until it expires. It matches
+// either a finding fingerprint or a host glob, port, protocol and finding
+// type, where empty fields match anything.
+type Suppression struct {
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Host        string `json:"host,omitempty"`
+	Port        int    `json:"port,omitempty"`
+	Protocol    string `json:"protocol,omitempty"`
+	Finding     string `json:"finding,omitempty"`
+	Acceptance
+
+	expires time.Time
+}
+
+func (s *Suppression) String() string {
+	if s.Fingerprint != "" {
+		return "fingerprint " + s.Fingerprint
+	}
+	desc := "host " + s.Host
+	if s.Port != 0 {
+		desc += fmt.Sprintf(" port %d", s.Port)
+	}
+	if s.Finding != "" {
+		desc += " finding " + s.Finding
+	}
+	return desc
+}
+
+// suppressionList is the layout of the -suppressions file
+type suppressionList struct {
+	Suppressions []*Suppression `json:"suppressions"`
+}
+
+func loadSuppressions(file string) (*suppressionList, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var list suppressionList
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	for i, s := range list.Suppressions {
+		if s.Fingerprint == "" && s.Host == "" {
+			return nil, fmt.Errorf("%s: suppression %d needs a fingerprint or host", file, i+1)
+		}
+		if s.Justification == "" || s.Approver == "" {
+			return nil, fmt.Errorf("%s: suppression %d (%s) needs a justification and approver", file, i+1, s)
+		}
+		if s.expires, err = parseExpiry(s.Expires); err != nil {
+			return nil, fmt.Errorf("%s: suppression %d (%s): %w", file, i+1, s, err)
+		}
+		if _, err := path.Match(s.Host, ""); err != nil {
+			return nil, fmt.Errorf("%s: suppression %d: bad host pattern %q", file, i+1, s.Host)
+		}
+	}
+	return &list, nil
+}
+
+// parseExpiry accepts a date, which expires at the end of that day UTC, or
+// an RFC 3339 timestamp
+func parseExpiry(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, fmt.Errorf("missing expiry date")
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t.Add(24 * time.Hour), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// Apply marks the open ports and findings of a host that an unexpired
+// suppression accepts. Expired suppressions no longer apply, so their
+// findings resurface, and are returned so they can be reported.
+func (l *suppressionList) Apply(summary *ScanSummary, now time.Time) []*Suppression {
+	var expired []*Suppression
+	match := func(res ScanResult, findingType string) *Acceptance {
+		for _, s := range l.Suppressions {
+			if !s.matches(summary.Target, res, findingType) {
+				continue
+			}
+			if now.After(s.expires) {
+				expired = append(expired, s)
+				continue
+			}
+			return &s.Acceptance
+		}
+		return nil
+	}
+
+	for i := range summary.Ports {
+		res := &summary.Ports[i]
+		res.Accepted = nil
+		if res.State == "open" {
+			res.Accepted = match(*res, FindingOpenPort)
+		}
+		for j := range res.Findings {
+			res.Findings[j].Accepted = match(*res, res.Findings[j].Type)
+		}
+	}
+	return expired
+}
+
+func (s *Suppression) matches(host string, res ScanResult, findingType string) bool {
+	if s.Fingerprint != "" {
+		// TCP findings also match fingerprints from before the protocol was
+		// part of them
+		return s.Fingerprint == findingFingerprint(host, res.proto(), res.Port, findingType) ||
+			res.proto() == "tcp" && s.Fingerprint == legacyFingerprint(host, res.Port, findingType)
+	}
+	if ok, _ := path.Match(s.Host, host); !ok {
+		return false
+	}
+	return (s.Port == 0 || s.Port == res.Port) &&
+		(s.Protocol == "" || s.Protocol == res.proto()) &&
+		(s.Finding == "" || s.Finding == findingType)
+}
+
+// acceptedFindings lists the findings of a host that are currently accepted
+func acceptedFindings(summary ScanSummary) []hostFinding {
+	var accepted []hostFinding
+	for _, f := range collectFindings(summary) {
+		if f.Finding.Accepted != nil {
+			accepted = append(accepted, f)
+		}
+	}
+	return accepted
+}
+
+func warnExpired(expired []*Suppression) {
+	seen := map[*Suppression]bool{}
+	for _, s := range expired {
+		if !seen[s] {
+			seen[s] = true
+			fmt.Fprintf(os.Stderr, "Suppression of %s approved by %s expired on %s; findings resurface\n", s, s.Approver, s.Expires)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSuppressionMatches(t *testing.T) {
+	tcp := ScanResult{Port: 443, Protocol: "tcp", State: "open"}
+	udp := ScanResult{Port: 161, Protocol: "udp", State: "open"}
+	saved := ScanResult{Port: 443, State: "open"} // saved before protocols were recorded
+	const host = "10.0.0.2"
+
+	tests := []struct {
+		name        string
+		suppression Suppression
+		res         ScanResult
+		finding     string
+		want        bool
+	}{
+		{"fingerprint", Suppression{Fingerprint: findingFingerprint(host, "tcp", 443, "sslvpn-exposed")}, tcp, "sslvpn-exposed", true},
+		{"fingerprint of another finding", Suppression{Fingerprint: findingFingerprint(host, "tcp", 443, "sslvpn-exposed")}, tcp, FindingOpenPort, false},
+		{"fingerprint of another protocol", Suppression{Fingerprint: findingFingerprint(host, "tcp", 161, "snmp-public")}, udp, "snmp-public", false},
+		{"fingerprint without protocol", Suppression{Fingerprint: findingFingerprint(host, "tcp", 443, FindingOpenPort)}, saved, FindingOpenPort, true},
+		{"legacy fingerprint", Suppression{Fingerprint: legacyFingerprint(host, 443, "sslvpn-exposed")}, tcp, "sslvpn-exposed", true},
+		{"legacy fingerprint on udp", Suppression{Fingerprint: legacyFingerprint(host, 161, "snmp-public")}, udp, "snmp-public", false},
+		{"fingerprint ignores other fields", Suppression{Fingerprint: findingFingerprint(host, "udp", 161, "snmp-public"), Host: "10.9.9.9"}, udp, "snmp-public", true},
+		{"host", Suppression{Host: host}, tcp, "sslvpn-exposed", true},
+		{"host glob", Suppression{Host: "10.0.0.*"}, udp, "snmp-public", true},
+		{"other host", Suppression{Host: "10.0.1.*"}, tcp, "sslvpn-exposed", false},
+		{"port", Suppression{Host: "*", Port: 443}, tcp, FindingOpenPort, true},
+		{"other port", Suppression{Host: "*", Port: 22}, tcp, FindingOpenPort, false},
+		{"protocol", Suppression{Host: "*", Port: 161, Protocol: "udp"}, udp, "snmp-public", true},
+		{"other protocol", Suppression{Host: "*", Port: 443, Protocol: "udp"}, tcp, FindingOpenPort, false},
+		{"tcp for saved results", Suppression{Host: "*", Protocol: "tcp"}, saved, FindingOpenPort, true},
+		{"finding", Suppression{Host: host, Finding: "snmp-public"}, udp, "snmp-public", true},
+		{"other finding", Suppression{Host: host, Finding: "snmp-public"}, udp, FindingOpenPort, false},
+	}
+	for _, tt := range tests {
+		if got := tt.suppression.matches(host, tt.res, tt.finding); got != tt.want {
+			t.Errorf("%s: matches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLoadSuppressions(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{"valid", `{"suppressions": [{"host": "10.0.0.*", "port": 22, "justification": "Bastion", "approver": "alice", "expires": "2099-01-01"}]}`, ""},
+		{"no match fields", `{"suppressions": [{"port": 22, "justification": "Bastion", "approver": "alice", "expires": "2099-01-01"}]}`, "needs a fingerprint or host"},
+		{"no approver", `{"suppressions": [{"host": "*", "justification": "Bastion", "expires": "2099-01-01"}]}`, "needs a justification and approver"},
+		{"no expiry", `{"suppressions": [{"host": "*", "justification": "Bastion", "approver": "alice"}]}`, "missing expiry date"},
+		{"bad expiry", `{"suppressions": [{"host": "*", "justification": "Bastion", "approver": "alice", "expires": "next year"}]}`, "cannot parse"},
+		{"bad host pattern", `{"suppressions": [{"host": "10.0.0.[", "justification": "Bastion", "approver": "alice", "expires": "2099-01-01"}]}`, "bad host pattern"},
+		{"not json", `suppressions:`, "invalid character"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "suppressions.json")
+			if err := os.WriteFile(file, []byte(tt.data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := loadSuppressions(file)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseExpiry(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		// A date lasts until the end of that day
+		{"2025-06-30", time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{"2025-06-30T12:00:00Z", time.Date(2025, 6, 30, 12, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := parseExpiry(tt.in)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseExpiry(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+// suppressionFixture accepts the SSH port of 10.0.0.2 until mid-2025, its
+// SNMP finding until 2030, and the SSL-VPN finding through a suppression that
+// expired first and one that is still valid
+func suppressionFixture() *suppressionList {
+	s := func(sup Suppression, expires string) *Suppression {
+		sup.Approver = "alice"
+		sup.Justification = "accepted"
+		sup.Expires = expires
+		sup.expires, _ = parseExpiry(expires)
+		return &sup
+	}
+	return &suppressionList{Suppressions: []*Suppression{
+		s(Suppression{Host: "10.0.0.2", Port: 22}, "2025-06-30"),
+		s(Suppression{Fingerprint: findingFingerprint("10.0.0.2", "udp", 161, "snmp-public")}, "2030-01-01"),
+		s(Suppression{Host: "10.0.0.*", Finding: "sslvpn-exposed"}, "2024-01-01"),
+		s(Suppression{Host: "10.0.0.2", Port: 443, Finding: "sslvpn-exposed"}, "2030-01-01"),
+	}}
+}
+
+func TestSuppressionApply(t *testing.T) {
+	list := suppressionFixture()
+
+	accepted := func(summary ScanSummary) []string {
+		var types []string
+		for _, f := range acceptedFindings(summary) {
+			types = append(types, f.Finding.Type)
+		}
+		return types
+	}
+
+	summary := reportFixture()[0]
+	summary.Ports[0].Accepted = nil
+	expired := list.Apply(&summary, time.Date(2025, 6, 30, 23, 0, 0, 0, time.UTC))
+	if want := []string{FindingOpenPort, "sslvpn-exposed", "snmp-public"}; !reflect.DeepEqual(accepted(summary), want) {
+		t.Errorf("accepted = %q, want %q", accepted(summary), want)
+	}
+	// The expired suppression is passed over for the next one that matches
+	if len(expired) != 1 || expired[0] != list.Suppressions[2] {
+		t.Errorf("expired = %v, want the sslvpn-exposed suppression", expired)
+	}
+	if a := summary.Ports[1].Findings[0].Accepted; a != &list.Suppressions[3].Acceptance {
+		t.Errorf("sslvpn-exposed accepted by %+v", a)
+	}
+
+	// A day later the SSH suppression has expired and the port resurfaces
+	expired = list.Apply(&summary, time.Date(2025, 7, 1, 1, 0, 0, 0, time.UTC))
+	if want := []string{"sslvpn-exposed", "snmp-public"}; !reflect.DeepEqual(accepted(summary), want) {
+		t.Errorf("accepted after expiry = %q, want %q", accepted(summary), want)
+	}
+	if summary.Ports[0].Accepted != nil {
+		t.Error("port 22 still accepted after its suppression expired")
+	}
+	if len(expired) != 2 || expired[0] != list.Suppressions[0] {
+		t.Errorf("expired = %v, want the port 22 and sslvpn-exposed suppressions", expired)
+	}
+}
+
+func TestSuppressedSection(t *testing.T) {
+	summary := reportFixture()[0]
+	summary.Ports[0].Accepted = nil
+	suppressionFixture().Apply(&summary, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	var buf bytes.Buffer
+	if err := writeText(&buf, []ScanSummary{summary}); err != nil {
+		t.Fatal(err)
+	}
+	text := buf.String()
+	for _, want := range []string{
+		"22/tcp open [medium 3.0] (accepted)",
+		"SUPPRESSED (risk accepted):\n" +
+			"22/tcp open-port: accepted (approved by alice, expires 2025-06-30)\n" +
+			"443/tcp sslvpn-exposed: accepted (approved by alice, expires 2030-01-01)\n" +
+			"161/udp snmp-public: accepted (approved by alice, expires 2030-01-01)\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("text output lacks %q:\n%s", want, text)
+		}
+	}
+	// Accepted findings are only listed in the suppressed section
+	if strings.Contains(text, "! sslvpn-exposed") || strings.Contains(text, "! snmp-public") {
+		t.Errorf("accepted findings listed with the open ports:\n%s", text)
+	}
+	if types := findingTypes(summary.Ports[1].Findings); len(types) != 0 {
+		t.Errorf("findingTypes = %q, want accepted findings left out", types)
+	}
+}
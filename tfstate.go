@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// tfTarget is an address found in Terraform state, with the ports its
+// security groups allow in
+type tfTarget struct {
+	Resource string
+	Declared []string
+	// HasGroups is false when no security group is known for the resource,
+	// in which case nothing can be compared
+	HasGroups bool
+}
+
+type tfState struct {
+	Version   int `json:"version"`
+	Resources []struct {
+		Module    string `json:"module"`
+		Mode      string `json:"mode"`
+		Type      string `json:"type"`
+		Name      string `json:"name"`
+		Instances []struct {
+			IndexKey   interface{}     `json:"index_key"`
+			Attributes json.RawMessage `json:"attributes"`
+		} `json:"instances"`
+	} `json:"resources"`
+}
+
+// tfAttributes holds the attributes read from the resource types used
+type tfAttributes struct {
+	ID               string   `json:"id"`
+	Name             string   `json:"name"`
+	PublicIP         string   `json:"public_ip"`
+	PrivateIP        string   `json:"private_ip"`
+	IPv6Addresses    []string `json:"ipv6_addresses"`
+	DNSName          string   `json:"dns_name"`
+	SecurityGroups   []string `json:"security_groups"`
+	VPCSecurityGroup []string `json:"vpc_security_group_ids"`
+
+	// aws_security_group
+	Ingress []tfIngress `json:"ingress"`
+
+	// aws_security_group_rule and aws_vpc_security_group_ingress_rule
+	tfIngress
+	Type            string `json:"type"`
+	SecurityGroupID string `json:"security_group_id"`
+	IPProtocol      string `json:"ip_protocol"`
+}
+
+type tfIngress struct {
+	FromPort *int   `json:"from_port"`
+	ToPort   *int   `json:"to_port"`
+	Protocol string `json:"protocol"`
+}
+
+// ports renders an ingress rule as declared port ranges. All traffic (-1)
+// allows every TCP and UDP port whatever ports the rule names, as AWS ignores
+// them; ICMP and other protocols are ignored.
+func (r tfIngress) ports() []string {
+	proto := strings.ToLower(r.Protocol)
+	protos := map[string][]string{
+		"tcp": {"tcp"}, "6": {"tcp"},
+		"udp": {"udp"}, "17": {"udp"},
+		"-1": {"tcp", "udp"}, "all": {"tcp", "udp"},
+	}[proto]
+
+	low, high := 0, 65535
+	allTraffic := proto == "-1" || proto == "all"
+	if !allTraffic && r.FromPort != nil && r.ToPort != nil && (*r.FromPort != 0 || *r.ToPort != 0) && *r.FromPort >= 0 {
+		low, high = *r.FromPort, *r.ToPort
+	}
+	var ports []string
+	for _, proto := range protos {
+		if low == high {
+			ports = append(ports, fmt.Sprintf("%d/%s", low, proto))
+		} else {
+			ports = append(ports, fmt.Sprintf("%d-%d/%s", low, high, proto))
+		}
+	}
+	return ports
+}
+
+// loadTerraformTargets reads terraform.tfstate files and returns the public
+// addresses of aws_instance resources, or their private ones when private is
+// set, and the DNS names of load balancers, each with the ports allowed by
+// the ingress rules of its security groups. Rule sources are not considered.
+func loadTerraformTargets(files []string, private bool) (map[string]*tfTarget, []string, error) {
+	type resource struct {
+		name  string
+		attrs tfAttributes
+		kind  string
+	}
+	var resources []resource
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		var state tfState
+		if err := json.Unmarshal(data, &state); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if state.Version < 4 {
+			return nil, nil, fmt.Errorf("%s: state version %d is not supported, run terraform 0.12 or later", file, state.Version)
+		}
+		for _, r := range state.Resources {
+			if r.Mode != "managed" {
+				continue
+			}
+			for _, inst := range r.Instances {
+				var attrs tfAttributes
+				if err := json.Unmarshal(inst.Attributes, &attrs); err != nil {
+					return nil, nil, fmt.Errorf("%s: %s.%s: %w", file, r.Type, r.Name, err)
+				}
+				name := r.Type + "." + r.Name
+				if r.Module != "" {
+					name = r.Module + "." + name
+				}
+				if inst.IndexKey != nil {
+					name += fmt.Sprintf("[%v]", inst.IndexKey)
+				}
+				resources = append(resources, resource{name: name, attrs: attrs, kind: r.Type})
+			}
+		}
+	}
+
+	// Security groups are matched by ID, and by name for EC2-Classic and
+	// default VPC instances that list them in security_groups
+	allowed := map[string][]string{}
+	for _, r := range resources {
+		switch r.kind {
+		case "aws_security_group":
+			var rules []string
+			for _, rule := range r.attrs.Ingress {
+				rules = append(rules, rule.ports()...)
+			}
+			allowed[r.attrs.ID] = append(allowed[r.attrs.ID], rules...)
+			if r.attrs.Name != "" {
+				allowed[r.attrs.Name] = append(allowed[r.attrs.Name], rules...)
+			}
+		case "aws_security_group_rule":
+			if r.attrs.Type == "ingress" {
+				allowed[r.attrs.SecurityGroupID] = append(allowed[r.attrs.SecurityGroupID], r.attrs.tfIngress.ports()...)
+			}
+		case "aws_vpc_security_group_ingress_rule":
+			rule := r.attrs.tfIngress
+			rule.Protocol = r.attrs.IPProtocol
+			allowed[r.attrs.SecurityGroupID] = append(allowed[r.attrs.SecurityGroupID], rule.ports()...)
+		}
+	}
+
+	targets := map[string]*tfTarget{}
+	var order []string
+	for _, r := range resources {
+		var addrs []string
+		switch r.kind {
+		case "aws_instance":
+			if private || r.attrs.PublicIP == "" {
+				addrs = append(addrs, r.attrs.PrivateIP)
+			} else {
+				addrs = append(addrs, r.attrs.PublicIP)
+			}
+			addrs = append(addrs, r.attrs.IPv6Addresses...)
+		case "aws_lb", "aws_alb", "aws_elb":
+			addrs = append(addrs, r.attrs.DNSName)
+		default:
+			continue
+		}
+
+		t := &tfTarget{Resource: r.name}
+		seen := map[string]bool{}
+		for _, group := range append(r.attrs.VPCSecurityGroup, r.attrs.SecurityGroups...) {
+			rules, ok := allowed[group]
+			if !ok {
+				continue
+			}
+			t.HasGroups = true
+			for _, p := range rules {
+				if !seen[p] {
+					seen[p] = true
+					t.Declared = append(t.Declared, p)
+				}
+			}
+		}
+		sort.Strings(t.Declared)
+
+		for _, addr := range addrs {
+			if addr == "" || targets[addr] != nil {
+				continue
+			}
+			targets[addr] = t
+			order = append(order, addr)
+		}
+	}
+	return targets, order, nil
+}
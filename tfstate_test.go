@@ -0,0 +1,104 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTFIngressPorts(t *testing.T) {
+	port := func(p int) *int { return &p }
+	tests := []struct {
+		name string
+		rule tfIngress
+		want []string
+	}{
+		{"single tcp port", tfIngress{port(443), port(443), "tcp"}, []string{"443/tcp"}},
+		{"tcp range by number", tfIngress{port(8000), port(8100), "6"}, []string{"8000-8100/tcp"}},
+		{"udp", tfIngress{port(53), port(53), "UDP"}, []string{"53/udp"}},
+		{"all traffic", tfIngress{port(0), port(0), "-1"}, []string{"0-65535/tcp", "0-65535/udp"}},
+		{"all traffic with ports", tfIngress{port(22), port(22), "all"}, []string{"0-65535/tcp", "0-65535/udp"}},
+		{"all traffic without ports", tfIngress{nil, nil, "-1"}, []string{"0-65535/tcp", "0-65535/udp"}},
+		{"tcp without ports", tfIngress{nil, nil, "tcp"}, []string{"0-65535/tcp"}},
+		{"icmp", tfIngress{port(-1), port(-1), "icmp"}, nil},
+		{"icmp by number", tfIngress{port(8), port(0), "1"}, nil},
+	}
+	for _, tt := range tests {
+		if got := tt.rule.ports(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: ports() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLoadTerraformTargets(t *testing.T) {
+	targets, order, err := loadTerraformTargets([]string{"testdata/terraform.tfstate"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	web := []string{"22/tcp", "443/tcp", "80/tcp"}
+	want := map[string]tfTarget{
+		// Matched by ID, with an aws_security_group_rule added to the group
+		"203.0.113.10": {Resource: "aws_instance.web[0]", Declared: web, HasGroups: true},
+		"2001:db8::10": {Resource: "aws_instance.web[0]", Declared: web, HasGroups: true},
+		"203.0.113.11": {Resource: "aws_instance.web[1]", Declared: web, HasGroups: true},
+		// Matched by name, without a public address
+		"10.0.2.5": {Resource: "aws_instance.legacy", Declared: []string{"3389/tcp"}, HasGroups: true},
+		// aws_vpc_security_group_ingress_rule only
+		"203.0.113.20": {Resource: "module.dns.aws_instance.resolver", Declared: []string{"53/tcp", "53/udp"}, HasGroups: true},
+		"203.0.113.30": {Resource: "aws_instance.build[runner]", Declared: []string{"0-65535/tcp", "0-65535/udp", "22/tcp", "443/tcp", "80/tcp"}, HasGroups: true},
+		"203.0.113.40": {Resource: "aws_instance.unmanaged_groups"},
+		"front-1234567890.eu-west-1.elb.amazonaws.com": {Resource: "aws_lb.front", Declared: web, HasGroups: true},
+	}
+	got := map[string]tfTarget{}
+	for addr, target := range targets {
+		got[addr] = *target
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("targets =\n%+v\nwant\n%+v", got, want)
+	}
+	wantOrder := []string{
+		"203.0.113.10", "2001:db8::10", "203.0.113.11", "10.0.2.5", "203.0.113.20",
+		"203.0.113.30", "203.0.113.40", "front-1234567890.eu-west-1.elb.amazonaws.com",
+	}
+	if !reflect.DeepEqual(order, wantOrder) {
+		t.Errorf("order = %q, want %q", order, wantOrder)
+	}
+
+	_, order, err = loadTerraformTargets([]string{"testdata/terraform.tfstate"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantOrder = []string{
+		"10.0.1.10", "2001:db8::10", "10.0.1.11", "10.0.2.5", "10.0.3.20",
+		"10.0.4.30", "10.0.5.40", "front-1234567890.eu-west-1.elb.amazonaws.com",
+	}
+	if !reflect.DeepEqual(order, wantOrder) {
+		t.Errorf("private order = %q, want %q", order, wantOrder)
+	}
+}
+
+func TestLoadTerraformTargetsErrors(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"old state", `{"version": 3, "modules": []}`},
+		{"not json", `terraform {}`},
+		{"bad attributes", `{"version": 4, "resources": [{"mode": "managed", "type": "aws_instance", "name": "web", "instances": [{"attributes": {"public_ip": 1}}]}]}`},
+	}
+	for _, tt := range tests {
+		file := filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "_")+".tfstate")
+		if err := os.WriteFile(file, []byte(tt.data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := loadTerraformTargets([]string{file}, false); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+	if _, _, err := loadTerraformTargets([]string{filepath.Join(dir, "missing.tfstate")}, false); err == nil {
+		t.Error("missing file accepted")
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// transcripts, when set, records the bytes sent and received on every
+// scanner connection for -save-transcripts
+var transcripts *transcriptRecorder
+
+// Transcript is the evidence of what was sent to and received from one port,
+// one exchange per connection or UDP request
+type Transcript struct {
+	ID        string               `json:"id"`
+	Target    string               `json:"target"`
+	Port      int                  `json:"port"`
+	Protocol  string               `json:"protocol"`
+	Truncated bool                 `json:"truncated,omitempty"`
+	Exchanges []TranscriptExchange `json:"exchanges"`
+
+	size int
+}
+
+// TranscriptExchange is one connection, labelled with the probe or scan
+// stage that made it. A TLS connection is recorded twice: as the encrypted
+// bytes on the wire and, marked Decrypted, as the plaintext inside the session.
+type TranscriptExchange struct {
+	Probe     string            `json:"probe"`
+	Decrypted bool              `json:"decrypted,omitempty"`
+	Start     time.Time         `json:"start"`
+	Events    []TranscriptEvent `json:"events"`
+}
+
+// TranscriptEvent is one write or read; Data is base64 in JSON
+type TranscriptEvent struct {
+	Time time.Time `json:"time"`
+	Dir  string    `json:"dir"`
+	Data []byte    `json:"data"`
+}
+
+// Transcript event directions
+const (
+	TranscriptSent     = "sent"
+	TranscriptReceived = "received"
+)
+
+// transcriptRecorder collects transcripts for the host being scanned. The
+// probe of a connection is the one last announced for its port, falling back
+// to the current scan stage. Its methods do nothing on a nil recorder.
+type transcriptRecorder struct {
+	dir   string
+	limit int
+
+	mu     sync.Mutex
+	stage  string
+	probes map[string]string
+	open   map[string]*Transcript
+}
+
+func newTranscriptRecorder(dir string, limit int) (*transcriptRecorder, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &transcriptRecorder{
+		dir:    dir,
+		limit:  limit,
+		stage:  "scan",
+		probes: map[string]string{},
+		open:   map[string]*Transcript{},
+	}, nil
+}
+
+func transcriptKey(host, proto string, port int) string {
+	return fmt.Sprintf("%s|%s|%d", host, proto, port)
+}
+
+// setStage labels the connections made from now on that no probe claims
+func (r *transcriptRecorder) setStage(stage string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.stage = stage
+	r.mu.Unlock()
+}
+
+// setProbe labels the connections made to a port from now on, until it is
+// cleared with an empty name
+func (r *transcriptRecorder) setProbe(host, proto string, port int, name string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if name == "" {
+		delete(r.probes, transcriptKey(host, proto, port))
+	} else {
+		r.probes[transcriptKey(host, proto, port)] = name
+	}
+}
+
+// begin starts an exchange on a port's transcript
+func (r *transcriptRecorder) begin(host, proto string, port int, decrypted bool) *transcriptExchange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := transcriptKey(host, proto, port)
+	t := r.open[key]
+	if t == nil {
+		t = &Transcript{Target: host, Port: port, Protocol: proto}
+		r.open[key] = t
+	}
+	probe := r.probes[key]
+	if probe == "" {
+		probe = r.stage
+	}
+	t.Exchanges = append(t.Exchanges, TranscriptExchange{Probe: probe, Decrypted: decrypted, Start: time.Now()})
+	return &transcriptExchange{recorder: r, transcript: t, index: len(t.Exchanges) - 1}
+}
+
+// transcriptExchange appends the events of one exchange
+type transcriptExchange struct {
+	recorder   *transcriptRecorder
+	transcript *Transcript
+	index      int
+}
+
+// add records data up to the transcript's size limit
+func (e *transcriptExchange) add(dir string, data []byte) {
+	r := e.recorder
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t := e.transcript
+	if room := r.limit - t.size; len(data) > room {
+		data = data[:max(room, 0)]
+		t.Truncated = true
+	}
+	if len(data) == 0 {
+		return
+	}
+	t.size += len(data)
+	ex := &t.Exchanges[e.index]
+	ex.Events = append(ex.Events, TranscriptEvent{Time: time.Now(), Dir: dir, Data: append([]byte(nil), data...)})
+}
+
+// recordedConn copies the bytes of a connection into a transcript
+type recordedConn struct {
+	net.Conn
+	exchange *transcriptExchange
+}
+
+func (c *recordedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.exchange.add(TranscriptReceived, p[:n])
+	}
+	return n, err
+}
+
+func (c *recordedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.exchange.add(TranscriptSent, p[:n])
+	}
+	return n, err
+}
+
+// record wraps a TCP connection to a port
+func (r *transcriptRecorder) record(conn net.Conn, host string, port int) net.Conn {
+	if r == nil {
+		return conn
+	}
+	return &recordedConn{Conn: conn, exchange: r.begin(host, "tcp", port, false)}
+}
+
+// recordDecrypted wraps a TLS client connection to a port, whose underlying
+// connection is recorded as ciphertext, to record the session's plaintext too
+func (r *transcriptRecorder) recordDecrypted(conn net.Conn, host string, port int) net.Conn {
+	if r == nil {
+		return conn
+	}
+	return &recordedConn{Conn: conn, exchange: r.begin(host, "tcp", port, true)}
+}
+
+// recordUDP records a UDP request and the reply accepted for it, if any
+func (r *transcriptRecorder) recordUDP(host string, port int, request, reply []byte) {
+	if r == nil {
+		return
+	}
+	e := r.begin(host, "udp", port, false)
+	e.add(TranscriptSent, request)
+	if reply != nil {
+		e.add(TranscriptReceived, reply)
+	}
+}
+
+// save writes the transcripts of a scanned host's ports and references them
+// from its results. Transcripts of ports not in the results, such as UDP
+// probes nothing answered, are dropped.
+func (r *transcriptRecorder) save(summary *ScanSummary) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range summary.Ports {
+		res := &summary.Ports[i]
+		t := r.open[transcriptKey(summary.Target, res.proto(), res.Port)]
+		if t == nil {
+			continue
+		}
+		sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%d|%d", t.Target, t.Protocol, t.Port, time.Now().UnixNano())))
+		t.ID = hex.EncodeToString(sum[:8])
+		data, err := json.MarshalIndent(t, "", "  ")
+		if err == nil {
+			err = os.WriteFile(filepath.Join(r.dir, t.ID+".json"), data, 0o644)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error saving transcript of %s %d/%s: %v\n", t.Target, t.Port, t.Protocol, err)
+			continue
+		}
+		res.Transcript = t.ID
+	}
+	for key := range r.open {
+		if strings.HasPrefix(key, summary.Target+"|") {
+			delete(r.open, key)
+		}
+	}
+}
+
+// runTranscript implements the transcript subcommand, which prints saved
+// transcripts as text or as a hex dump
+func runTranscript(args []string) error {
+	fs := flag.NewFlagSet("transcript", flag.ExitOnError)
+	dir := fs.String("dir", ".", "Directory transcript IDs are looked up in")
+	format := fs.String("format", "text", "Output format: text or hex")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: portscanner transcript [flags] id|file.json...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no transcripts given")
+	}
+	if *format != "text" && *format != "hex" {
+		return fmt.Errorf("unknown transcript format %q, expected text or hex", *format)
+	}
+
+	for _, arg := range fs.Args() {
+		file := arg
+		if !strings.HasSuffix(arg, ".json") {
+			file = filepath.Join(*dir, arg+".json")
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var t Transcript
+		if err := json.Unmarshal(data, &t); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		writeTranscript(os.Stdout, &t, *format == "hex")
+	}
+	return nil
+}
+
+func writeTranscript(w io.Writer, t *Transcript, hexDump bool) {
+	fmt.Fprintf(w, "=== Transcript %s: %s %d/%s ===\n", t.ID, t.Target, t.Port, t.Protocol)
+	for _, ex := range t.Exchanges {
+		probe := ex.Probe
+		if ex.Decrypted {
+			probe += " (decrypted TLS)"
+		}
+		fmt.Fprintf(w, "\n--- %s, %s ---\n", probe, ex.Start.Format(time.RFC3339Nano))
+		for _, ev := range ex.Events {
+			arrow := ">"
+			if ev.Dir == TranscriptReceived {
+				arrow = "<"
+			}
+			fmt.Fprintf(w, "%s %s %s %d bytes\n", ev.Time.Format("15:04:05.000"), arrow, ev.Dir, len(ev.Data))
+			if hexDump {
+				io.WriteString(w, hex.Dump(ev.Data))
+			} else {
+				text := printableText(ev.Data)
+				if !strings.HasSuffix(text, "\n") {
+					text += "\n"
+				}
+				io.WriteString(w, text)
+			}
+		}
+	}
+	if t.Truncated {
+		fmt.Fprintln(w, "\n(truncated at the size limit)")
+	}
+	fmt.Fprintln(w)
+}
+
+// printableText shows data as text, escaping bytes that are not printable
+// UTF-8 other than line breaks and tabs
+func printableText(data []byte) string {
+	var b strings.Builder
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		switch {
+		case r == '\n' || r == '\t':
+			b.WriteRune(r)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == utf8.RuneError && size == 1, r < 0x20, r == 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, data[0])
+		default:
+			b.WriteRune(r)
+		}
+		data = data[size:]
+	}
+	return b.String()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrintableText(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"plain", "SSH-2.0-OpenSSH_9.6", "SSH-2.0-OpenSSH_9.6"},
+		{"line breaks", "HTTP/1.1 200 OK\r\nServer: nginx\r\n\r\n", "HTTP/1.1 200 OK\\r\nServer: nginx\\r\n\\r\n"},
+		{"tab", "a\tb", "a\tb"},
+		{"utf-8", "café ✓", "café ✓"},
+		{"control bytes", "\x00\x1b[0m\x7f", `\x00\x1b[0m\x7f`},
+		{"invalid utf-8", "\xff\xfeok\xc3", `\xff\xfeok\xc3`},
+		{"tls record", "\x16\x03\x01\x00\x05", `\x16\x03\x01\x00\x05`},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := printableText([]byte(tt.data)); got != tt.want {
+				t.Errorf("printableText(%q) = %q, want %q", tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTranscriptDecryptedTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "transcript-test")
+	}))
+	defer srv.Close()
+	addr := srv.Listener.Addr().(*net.TCPAddr)
+
+	recorder, err := newTranscriptRecorder(t.TempDir(), 1<<16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transcripts = recorder
+	t.Cleanup(func() { transcripts = nil })
+	recorder.setStage("probes")
+
+	client := probeHTTPClient(time.Second)
+	resp, _, err := fetch(client, srv.URL+"/", 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	// Closing the connection still writes a TLS alert through the recorder,
+	// so the transcript is only read under its lock
+	client.CloseIdleConnections()
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	tr := recorder.open[transcriptKey(addr.IP.String(), "tcp", addr.Port)]
+	if tr == nil || len(tr.Exchanges) != 2 {
+		t.Fatalf("transcript = %+v, want an encrypted and a decrypted exchange", tr)
+	}
+	sent := func(ex TranscriptExchange) []byte {
+		var b []byte
+		for _, ev := range ex.Events {
+			if ev.Dir == TranscriptSent {
+				b = append(b, ev.Data...)
+			}
+		}
+		return b
+	}
+	wire, plain := tr.Exchanges[0], tr.Exchanges[1]
+	if wire.Decrypted || !plain.Decrypted {
+		t.Fatalf("decrypted = %v, %v, want false, true", wire.Decrypted, plain.Decrypted)
+	}
+	if bytes.Contains(sent(wire), []byte("GET / HTTP/1.1")) {
+		t.Error("the connection's exchange holds the plaintext request")
+	}
+	if !bytes.HasPrefix(sent(plain), []byte("GET / HTTP/1.1\r\n")) {
+		t.Errorf("decrypted exchange sent %q, want the request", sent(plain))
+	}
+
+	var out strings.Builder
+	writeTranscript(&out, tr, false)
+	if !strings.Contains(out.String(), "--- probes (decrypted TLS), ") || !strings.Contains(out.String(), "Server: transcript-test") {
+		t.Errorf("writeTranscript output:\n%s", out.String())
+	}
+}